@@ -0,0 +1,294 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+type seriesKind int
+
+const (
+	countSeriesKind seriesKind = iota
+	rateSeriesKind
+	gaugeSeriesKind
+	histogramSeriesKind // every sample received, for sinks which keep distributions
+)
+
+func (k seriesKind) String() string {
+	switch k {
+	case countSeriesKind:
+		return "count"
+	case rateSeriesKind:
+		return "rate"
+	case gaugeSeriesKind:
+		return "gauge"
+	case histogramSeriesKind:
+		return "histogram"
+	}
+
+	return "unknown"
+}
+
+// a single aggregated point for one metric context, produced on each flush
+type aggregatedSeries struct {
+	name       string
+	kind       seriesKind
+	metricType dogstatsd.MetricType // the type of metric the point was aggregated from
+	timestamp  time.Time
+	value      float64 // for histograms, the number of samples scaled up by sample rate
+	interval   time.Duration
+	tags       []string
+	samples    weightedSamples // histograms only, sorted
+}
+
+// the values received for one metric name, type and tag set during a flush interval
+type aggContext struct {
+	name       string
+	metricType dogstatsd.MetricType
+	tags       []string
+
+	sum     float64             // counters, scaled up by sample rate
+	last    float64             // gauges
+	set     map[string]struct{} // sets
+	samples weightedSamples     // timers, histograms and distributions
+	count   float64             // number of samples, scaled up by sample rate
+}
+
+// samples, each weighted by how many it stands for given its sample rate, sortable by value
+type weightedSamples struct {
+	values  []float64
+	weights []float64
+}
+
+func (s *weightedSamples) add(value float64, weight float64) {
+	s.values = append(s.values, value)
+	s.weights = append(s.weights, weight)
+}
+
+func (s weightedSamples) Len() int           { return len(s.values) }
+func (s weightedSamples) Less(i, j int) bool { return s.values[i] < s.values[j] }
+func (s weightedSamples) Swap(i, j int) {
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	s.weights[i], s.weights[j] = s.weights[j], s.weights[i]
+}
+
+// aggregator combines metrics into one point per context per flush interval, much as the datadog
+// agent does: counters become rates, gauges keep their last value, sets count their unique
+// values, and timers and histograms are summarized as .count, .avg, .median, .95percentile and
+// .max (along with a histogram of every sample, for sinks which want it). Distributions are
+// summarized the same way, unlike the agent, which sends them on as sketches for Datadog to
+// compute percentiles across every host
+type aggregator struct {
+	interval time.Duration
+
+	mu       sync.Mutex
+	contexts map[string]*aggContext
+	flushed  time.Time // when the last flush was, or the aggregator was created
+}
+
+func newAggregator(interval time.Duration) *aggregator {
+	return &aggregator{
+		interval: interval,
+		contexts: map[string]*aggContext{},
+		flushed:  time.Now(),
+	}
+}
+
+func (a *aggregator) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok {
+		return nil
+	}
+
+	tags := make([]string, len(metric.Tags))
+	copy(tags, metric.Tags)
+	sort.Strings(tags)
+
+	sampleRate := metric.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := metric.Name + "|" + metric.MetricType.String() + "|" + strings.Join(tags, ",")
+	ctx, ok := a.contexts[key]
+	if !ok {
+		ctx = &aggContext{
+			name:       metric.Name,
+			metricType: metric.MetricType,
+			tags:       tags,
+			set:        map[string]struct{}{},
+		}
+		a.contexts[key] = ctx
+	}
+
+	for _, value := range metric.Values {
+		switch metric.MetricType {
+		case dogstatsd.CounterMetricType:
+			ctx.sum += value.Numeric / sampleRate
+		case dogstatsd.GaugeMetricType:
+			ctx.last = value.Numeric
+		case dogstatsd.SetMetricType:
+			ctx.set[value.Raw] = struct{}{}
+		default:
+			ctx.samples.add(value.Numeric, 1/sampleRate)
+			ctx.count += 1 / sampleRate
+		}
+	}
+
+	return nil
+}
+
+// nearest-rank percentile of sorted samples
+func samplePercentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// flush returns a point for every context updated since the last flush, and forgets them. Rates
+// are over the time since the last flush, which can be more than the interval if a sink was slow
+// to submit, or less if the flush was early on shutdown
+func (a *aggregator) flush(now time.Time) []aggregatedSeries {
+	a.mu.Lock()
+	contexts := a.contexts
+	a.contexts = map[string]*aggContext{}
+	interval := a.interval
+	if now.After(a.flushed) {
+		interval = now.Sub(a.flushed)
+	}
+	a.flushed = now
+	a.mu.Unlock()
+
+	series := []aggregatedSeries{}
+	point := func(ctx *aggContext, suffix string, kind seriesKind, value float64) aggregatedSeries {
+		return aggregatedSeries{
+			name:       ctx.name + suffix,
+			kind:       kind,
+			metricType: ctx.metricType,
+			timestamp:  now,
+			value:      value,
+			interval:   interval,
+			tags:       ctx.tags,
+		}
+	}
+
+	for _, ctx := range contexts {
+		switch ctx.metricType {
+		case dogstatsd.CounterMetricType:
+			series = append(series, point(ctx, "", rateSeriesKind, ctx.sum/interval.Seconds()))
+		case dogstatsd.GaugeMetricType:
+			series = append(series, point(ctx, "", gaugeSeriesKind, ctx.last))
+		case dogstatsd.SetMetricType:
+			series = append(series, point(ctx, "", gaugeSeriesKind, float64(len(ctx.set))))
+		default:
+			samples := ctx.samples.values
+			if len(samples) == 0 {
+				continue
+			}
+
+			sort.Sort(ctx.samples)
+			sum := 0.0
+			for _, sample := range samples {
+				sum += sample
+			}
+
+			hist := point(ctx, "", histogramSeriesKind, ctx.count)
+			hist.samples = ctx.samples
+
+			series = append(series,
+				hist,
+				point(ctx, ".count", rateSeriesKind, ctx.count/interval.Seconds()),
+				point(ctx, ".avg", gaugeSeriesKind, sum/float64(len(samples))),
+				point(ctx, ".median", gaugeSeriesKind, samplePercentile(samples, 0.5)),
+				point(ctx, ".95percentile", gaugeSeriesKind, samplePercentile(samples, 0.95)),
+				point(ctx, ".max", gaugeSeriesKind, samples[len(samples)-1]),
+			)
+		}
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return strings.Join(series[i].tags, ",") < strings.Join(series[j].tags, ",")
+	})
+
+	return series
+}
+
+// seriesSink receives the aggregated series on each flush
+type seriesSink interface {
+	String() string
+	submit(series []aggregatedSeries) error
+}
+
+// flusher flushes an aggregator to its sinks every interval, and once more when stopped
+type flusher struct {
+	agg    *aggregator
+	sinks  []seriesSink
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newFlusher(agg *aggregator, sinks ...seriesSink) *flusher {
+	f := &flusher{
+		agg:    agg,
+		sinks:  sinks,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	go f.run()
+	return f
+}
+
+func (f *flusher) flush() {
+	series := f.agg.flush(time.Now())
+	if len(series) == 0 {
+		return
+	}
+
+	for _, sink := range f.sinks {
+		if err := sink.submit(series); err != nil {
+			log.Printf("flush error (%s): %s", sink.String(), err.Error())
+		}
+	}
+}
+
+func (f *flusher) run() {
+	defer close(f.doneCh)
+
+	ticker := time.NewTicker(f.agg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.flush()
+		case <-f.stopCh:
+			f.flush()
+			return
+		}
+	}
+}
+
+func (f *flusher) stop() {
+	close(f.stopCh)
+	<-f.doneCh
+}
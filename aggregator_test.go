@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregator(t *testing.T) {
+	agg := newAggregator(10 * time.Second)
+	for _, msg := range []string{
+		"page.views:10|c|#route:home,env:dev",
+		"page.views:5|c|@0.5|#env:dev,route:home",
+		"page.views:1|c",
+		"fuel.level:0.5|g",
+		"fuel.level:0.25|g",
+		"users:a|s",
+		"users:1|s",
+		"users:2|s",
+		"users:1|s",
+		"api.latency:1:2:3:4|ms",
+		"api.latency:100|ms|@0.5",
+		"_sc|db|0",
+		"not a metric",
+	} {
+		assert.NoError(t, agg.handler([]byte(msg)))
+	}
+
+	now := time.Unix(100, 0)
+	point := func(name string, kind seriesKind, metricType dogstatsd.MetricType, value float64, tags ...string) aggregatedSeries {
+		if tags == nil {
+			tags = []string{}
+		}
+		return aggregatedSeries{name: name, kind: kind, metricType: metricType, timestamp: now, value: value, interval: 10 * time.Second, tags: tags}
+	}
+
+	latency := point("api.latency", histogramSeriesKind, dogstatsd.TimerMetricType, 6)
+	latency.samples = weightedSamples{values: []float64{1, 2, 3, 4, 100}, weights: []float64{1, 1, 1, 1, 2}}
+
+	assert.Equal(t, []aggregatedSeries{
+		latency,
+		point("api.latency.95percentile", gaugeSeriesKind, dogstatsd.TimerMetricType, 100),
+		point("api.latency.avg", gaugeSeriesKind, dogstatsd.TimerMetricType, 22),
+		point("api.latency.count", rateSeriesKind, dogstatsd.TimerMetricType, 0.6),
+		point("api.latency.max", gaugeSeriesKind, dogstatsd.TimerMetricType, 100),
+		point("api.latency.median", gaugeSeriesKind, dogstatsd.TimerMetricType, 3),
+		point("fuel.level", gaugeSeriesKind, dogstatsd.GaugeMetricType, 0.25),
+		point("page.views", rateSeriesKind, dogstatsd.CounterMetricType, 0.1),
+		point("page.views", rateSeriesKind, dogstatsd.CounterMetricType, 2, "env:dev", "route:home"),
+		point("users", gaugeSeriesKind, dogstatsd.SetMetricType, 2),
+	}, agg.flush(now))
+
+	// contexts are forgotten once flushed
+	assert.Empty(t, agg.flush(now))
+}
+
+func TestAggregatorElapsedInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	// rates are over the time since the last flush, not the configured interval
+	agg := newAggregator(10 * time.Second)
+	agg.flushed = time.Unix(100, 0)
+	for _, step := range []struct {
+		now      int64
+		interval time.Duration
+		rate     float64
+	}{
+		{now: 105, interval: 5 * time.Second, rate: 2},
+		{now: 125, interval: 20 * time.Second, rate: 0.5},
+	} {
+		agg.handler([]byte("page.views:10|c"))
+		series := agg.flush(time.Unix(step.now, 0))
+		assert.Len(series, 1)
+		assert.Equal(step.interval, series[0].interval)
+		assert.Equal(step.rate, series[0].value)
+	}
+}
+
+type testSeriesSink struct {
+	flushes [][]aggregatedSeries
+}
+
+func (s *testSeriesSink) String() string {
+	return "test"
+}
+
+func (s *testSeriesSink) submit(series []aggregatedSeries) error {
+	s.flushes = append(s.flushes, series)
+	return nil
+}
+
+func TestFlusher(t *testing.T) {
+	agg := newAggregator(time.Hour)
+	sink := &testSeriesSink{}
+	f := newFlusher(agg, sink)
+
+	agg.handler([]byte("page.views:1|c"))
+	f.stop()
+
+	assert.Len(t, sink.flushes, 1)
+	assert.Equal(t, "page.views", sink.flushes[0][0].name)
+}
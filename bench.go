@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// probes carry their send time so that the receiver can measure latency
+const (
+	benchProbeName     = "dogstatsd_local.bench.probe"
+	benchProbeInterval = 100 * time.Millisecond
+)
+
+// the tag identifying a probe; it includes the run's start time so that a running instance,
+// which keeps what it received earlier, can't match a probe from a previous run
+func benchProbeTag(start time.Time, n int) string {
+	return fmt.Sprintf("probe:%s-%d", strconv.FormatInt(start.UnixNano(), 36), n)
+}
+
+type benchMixEntry struct {
+	code   string
+	weight int
+}
+
+// parse a traffic mix like c:50,g:20,ms:20,e:5,sc:5 into message kinds and relative weights;
+// kinds are metric types, e for events and sc for service checks
+func parseBenchMix(str string) ([]benchMixEntry, error) {
+	mix := []benchMixEntry{}
+	total := 0
+
+	for _, part := range strings.Split(str, ",") {
+		code, weight, ok := strings.Cut(strings.TrimSpace(part), ":")
+		w, err := strconv.Atoi(weight)
+		if !ok || err != nil || w < 0 {
+			return nil, fmt.Errorf("INVALID_MIX (%s)", part)
+		}
+
+		if code != "e" && code != "sc" {
+			metricType, ok := metricTypeNames[code]
+			if !ok {
+				return nil, fmt.Errorf("INVALID_MIX (%s)", part)
+			}
+			code = metricType.Code()
+		}
+
+		mix = append(mix, benchMixEntry{code: code, weight: w})
+		total += w
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("INVALID_MIX (%s)", str)
+	}
+
+	return mix, nil
+}
+
+// benchGenerator builds random messages drawn from a fixed set of metric names and tag values
+type benchGenerator struct {
+	rand        *rand.Rand
+	mix         []benchMixEntry
+	totalWeight int
+	metrics     int
+	tags        int
+	cardinality int
+}
+
+func newBenchGenerator(seed int64, mix []benchMixEntry, metrics, tags, cardinality int) *benchGenerator {
+	g := &benchGenerator{
+		rand:        rand.New(rand.NewSource(seed)),
+		mix:         mix,
+		metrics:     metrics,
+		tags:        tags,
+		cardinality: cardinality,
+	}
+
+	for _, entry := range mix {
+		g.totalWeight += entry.weight
+	}
+
+	return g
+}
+
+func (g *benchGenerator) next() string {
+	code := g.mix[0].code
+	n := g.rand.Intn(g.totalWeight)
+	for _, entry := range g.mix {
+		if n < entry.weight {
+			code = entry.code
+			break
+		}
+		n -= entry.weight
+	}
+
+	tags := make([]string, g.tags)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag%d:value%d", i, g.rand.Intn(g.cardinality))
+	}
+	tagStr := ""
+	if len(tags) > 0 {
+		tagStr = "|#" + strings.Join(tags, ",")
+	}
+
+	id := g.rand.Intn(g.metrics)
+	switch code {
+	case "e":
+		title := fmt.Sprintf("bench event %d", id)
+		return fmt.Sprintf("_e{%d,10}:%s|bench text%s", len(title), title, tagStr)
+	case "sc":
+		return fmt.Sprintf("_sc|bench.check_%d|%d%s", id, g.rand.Intn(4), tagStr)
+	}
+
+	return fmt.Sprintf("bench.metric_%d:%d|%s%s", id, g.rand.Intn(1000), code, tagStr)
+}
+
+// packet joins batch messages into a single newline-separated packet
+func (g *benchGenerator) packet(batch int) string {
+	msgs := make([]string, batch)
+	for i := range msgs {
+		msgs[i] = g.next()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+type benchConfig struct {
+	target      string // empty to benchmark an in-process listener
+	statsUrl    string // HTTP API of the target, for received counts and latency
+	duration    time.Duration
+	drain       time.Duration
+	senders     int
+	rate        float64 // packets per second across all senders, 0 for unlimited
+	batch       int
+	mix         []benchMixEntry
+	metrics     int
+	tags        int
+	cardinality int
+}
+
+type benchReport struct {
+	Target         string        `json:"target"`
+	Duration       time.Duration `json:"duration_ns"`
+	Senders        int           `json:"senders"`
+	Batch          int           `json:"batch"`
+	PacketsSent    int64         `json:"packets_sent"`
+	MessagesSent   int64         `json:"messages_sent"`
+	BytesSent      int64         `json:"bytes_sent"`
+	SendErrors     int64         `json:"send_errors"`
+	PacketsPerSec  float64       `json:"packets_per_sec"`
+	MessagesPerSec float64       `json:"messages_per_sec"`
+
+	// unknown unless the receiver can be observed
+	Received     *int64  `json:"messages_received,omitempty"`
+	DropRate     float64 `json:"drop_rate"`
+	PoolRejected *int64  `json:"pool_rejected,omitempty"`
+	Probes       int     `json:"latency_probes"`
+	LatencyP50   float64 `json:"latency_p50_ms"`
+	LatencyP99   float64 `json:"latency_p99_ms"`
+	LatencyMax   float64 `json:"latency_max_ms"`
+}
+
+// bench receiver: the listener and handler pool from the main pipeline, counting messages
+// rather than printing them
+type benchReceiver struct {
+	srv   dogstatsd.Server
+	async asyncMsgHandler
+	done  chan struct{}
+
+	received int64
+	rejected int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func newBenchReceiver() (*benchReceiver, error) {
+	r := &benchReceiver{done: make(chan struct{})}
+	r.async = newAsyncMsgHandler(r.handler, handlerPoolSize, handlerBufferSize)
+	r.srv = dogstatsd.NewServer("127.0.0.1:0", func(packet []byte, _ net.Addr) error {
+		for _, msg := range dogstatsd.SplitPacket(packet) {
+			if err := r.async.handler(msg); err != nil {
+				atomic.AddInt64(&r.rejected, 1)
+			}
+		}
+		return nil
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(r.done)
+		errCh <- r.srv.Listen()
+	}()
+
+	if r.srv.Addr() == nil {
+		r.async.stop()
+		return nil, <-errCh
+	}
+
+	return r, nil
+}
+
+func (r *benchReceiver) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	atomic.AddInt64(&r.received, 1)
+	if err != nil {
+		return nil
+	}
+
+	if metric, ok := dMsg.(dogstatsd.Metric); ok && metric.Name == benchProbeName && len(metric.Values) > 0 {
+		sent, _ := strconv.ParseInt(metric.Values[0].Raw, 10, 64)
+		r.mu.Lock()
+		r.latencies = append(r.latencies, time.Since(time.Unix(0, sent)))
+		r.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (r *benchReceiver) stop() {
+	r.srv.Stop()
+	<-r.done
+	r.async.stop()
+}
+
+// the number of messages a running instance has received, from its HTTP API
+func benchReceivedCount(statsUrl string) (int64, error) {
+	resp, err := http.Get(strings.TrimRight(statsUrl, "/") + "/stats")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var stats httpApiStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, err
+	}
+
+	return stats.Messages, nil
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// bench sends generated traffic to the target (or an in-process receiver) for the configured
+// duration, or until stopCh is closed, then reports what was sent and received
+func bench(cfg benchConfig, stopCh <-chan struct{}) (*benchReport, error) {
+	var receiver *benchReceiver
+	target := cfg.target
+	if target == "" {
+		var err error
+		if receiver, err = newBenchReceiver(); err != nil {
+			return nil, err
+		}
+		defer receiver.stop()
+		target = receiver.srv.Addr().String()
+	}
+
+	var receivedBefore int64
+	if cfg.statsUrl != "" {
+		var err error
+		if receivedBefore, err = benchReceivedCount(cfg.statsUrl); err != nil {
+			return nil, err
+		}
+	}
+
+	conns := make([]net.Conn, cfg.senders+1)
+	streams := make([]bool, cfg.senders+1)
+	for i := range conns {
+		var err error
+		if conns[i], streams[i], err = dialTarget(target); err != nil {
+			return nil, err
+		}
+		defer conns[i].Close()
+	}
+
+	report := &benchReport{Target: target, Senders: cfg.senders, Batch: cfg.batch}
+
+	stop := make(chan struct{})
+	timer := time.AfterFunc(cfg.duration, func() { close(stop) })
+	go func() {
+		select {
+		case <-stopCh:
+			if timer.Stop() {
+				close(stop)
+			}
+		case <-stop:
+		}
+	}()
+
+	write := func(i int, packet string, msgs int) {
+		if streams[i] {
+			packet += "\n"
+		}
+
+		if _, err := conns[i].Write([]byte(packet)); err != nil {
+			atomic.AddInt64(&report.SendErrors, 1)
+			return
+		}
+
+		atomic.AddInt64(&report.PacketsSent, 1)
+		atomic.AddInt64(&report.MessagesSent, int64(msgs))
+		atomic.AddInt64(&report.BytesSent, int64(len(packet)))
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i := 0; i < cfg.senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			gen := newBenchGenerator(start.UnixNano()+int64(i), cfg.mix, cfg.metrics, cfg.tags, cfg.cardinality)
+			var interval time.Duration
+			if cfg.rate > 0 {
+				interval = time.Duration(float64(time.Second) * float64(cfg.senders) / cfg.rate)
+			}
+
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				// pace against the start time so that slow writes are caught up on
+				if interval > 0 {
+					if wait := time.Until(start.Add(time.Duration(n) * interval)); wait > 0 {
+						select {
+						case <-stop:
+							return
+						case <-time.After(wait):
+						}
+					}
+				}
+
+				write(i, gen.packet(cfg.batch), cfg.batch)
+			}
+		}(i)
+	}
+
+	// probes measure latency: an in-process receiver reads the send time from the value, and a
+	// running instance is asked to wait for each probe over its HTTP API
+	var probeMu sync.Mutex
+	var probeLatencies []time.Duration
+	if receiver != nil || cfg.statsUrl != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(benchProbeInterval)
+			defer ticker.Stop()
+
+			var probeWg sync.WaitGroup
+			defer probeWg.Wait()
+
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+
+				sent := time.Now()
+				write(cfg.senders, fmt.Sprintf("%s:%d|g|#%s", benchProbeName, sent.UnixNano(), benchProbeTag(start, n)), 1)
+				if receiver != nil {
+					continue
+				}
+
+				probeWg.Add(1)
+				go func(n int) {
+					defer probeWg.Done()
+
+					query := url.Values{"name": {benchProbeName}, "tag": {benchProbeTag(start, n)}}
+					if _, err := waitForUrl(cfg.statsUrl, query, 5*time.Second); err == nil {
+						probeMu.Lock()
+						probeLatencies = append(probeLatencies, time.Since(sent))
+						probeMu.Unlock()
+					}
+				}(n)
+			}
+		}()
+	}
+
+	<-stop
+	report.Duration = time.Since(start)
+	wg.Wait()
+
+	// give the receiver time to work through anything still queued
+	time.Sleep(cfg.drain)
+
+	seconds := report.Duration.Seconds()
+	report.PacketsPerSec = float64(report.PacketsSent) / seconds
+	report.MessagesPerSec = float64(report.MessagesSent) / seconds
+
+	var received int64 = -1
+	switch {
+	case receiver != nil:
+		received = atomic.LoadInt64(&receiver.received)
+		rejected := atomic.LoadInt64(&receiver.rejected)
+		report.PoolRejected = &rejected
+
+		receiver.mu.Lock()
+		probeLatencies = receiver.latencies
+		receiver.mu.Unlock()
+	case cfg.statsUrl != "":
+		receivedAfter, err := benchReceivedCount(cfg.statsUrl)
+		if err != nil {
+			return nil, err
+		}
+		received = receivedAfter - receivedBefore
+	}
+
+	if received >= 0 {
+		report.Received = &received
+		if report.MessagesSent > 0 && received < report.MessagesSent {
+			report.DropRate = float64(report.MessagesSent-received) / float64(report.MessagesSent)
+		}
+	}
+
+	probeMu.Lock()
+	sort.Slice(probeLatencies, func(i, j int) bool { return probeLatencies[i] < probeLatencies[j] })
+	report.Probes = len(probeLatencies)
+	report.LatencyP50 = percentile(probeLatencies, 0.5)
+	report.LatencyP99 = percentile(probeLatencies, 0.99)
+	report.LatencyMax = percentile(probeLatencies, 1)
+	probeMu.Unlock()
+
+	return report, nil
+}
+
+func writeTextBenchReport(w io.Writer, report *benchReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "target\t%s\n", report.Target)
+	fmt.Fprintf(tw, "duration\t%s (%d senders, %d messages per packet)\n", report.Duration.Truncate(time.Millisecond), report.Senders, report.Batch)
+	fmt.Fprintf(tw, "sent\t%d packets, %d messages, %d bytes (%d errors)\n", report.PacketsSent, report.MessagesSent, report.BytesSent, report.SendErrors)
+	fmt.Fprintf(tw, "throughput\t%.0f packets/s, %.0f messages/s\n", report.PacketsPerSec, report.MessagesPerSec)
+
+	if report.Received != nil {
+		fmt.Fprintf(tw, "received\t%d messages (%.2f%% dropped)\n", *report.Received, report.DropRate*100)
+	} else {
+		fmt.Fprintf(tw, "received\tunknown (set -stats-url to the target's HTTP API)\n")
+	}
+
+	if report.PoolRejected != nil {
+		fmt.Fprintf(tw, "pool rejected\t%d messages (POOL_CAPACITY_EXCEEDED)\n", *report.PoolRejected)
+	}
+
+	if report.Probes > 0 {
+		fmt.Fprintf(tw, "latency\tp50 %.2fms, p99 %.2fms, max %.2fms (%d probes)\n", report.LatencyP50, report.LatencyP99, report.LatencyMax, report.Probes)
+	}
+
+	return tw.Flush()
+}
+
+// bench subcommand: generate synthetic traffic and report how much of it was received
+func runBench(args []string) int {
+	cfg := benchConfig{}
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.StringVar(&cfg.target, "target", "", "where to send (see send -target); by default an in-process listener with the same handler pool is benchmarked")
+	flags.StringVar(&cfg.statsUrl, "stats-url", "", "HTTP API of the -target instance (see -http), used to count received messages and measure latency")
+	flags.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to send for")
+	flags.DurationVar(&cfg.drain, "drain", time.Second, "how long to wait after sending before counting received messages")
+	flags.IntVar(&cfg.senders, "senders", 4, "number of concurrent senders, each with its own connection")
+	flags.Float64Var(&cfg.rate, "rate", 0, "packets per second across all senders (0 sends as fast as possible)")
+	flags.IntVar(&cfg.batch, "batch", 1, "messages per packet, newline-separated")
+	mix := flags.String("mix", "c:40,g:20,ms:20,h:10,d:5,s:3,e:1,sc:1", "relative weights of each message kind: metric types, e (events) and sc (service checks)")
+	flags.IntVar(&cfg.metrics, "metrics", 100, "number of distinct metric names")
+	flags.IntVar(&cfg.tags, "tags", 3, "tags per message")
+	flags.IntVar(&cfg.cardinality, "cardinality", 10, "distinct values of each tag")
+	asJson := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s bench [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var err error
+	if cfg.mix, err = parseBenchMix(*mix); err != nil {
+		log.Println(err.Error())
+		return 2
+	}
+
+	if flags.NArg() != 0 || cfg.senders < 1 || cfg.batch < 1 || cfg.metrics < 1 || cfg.tags < 0 || cfg.cardinality < 1 || cfg.rate < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	report, err := bench(cfg, stopCh)
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		writeTextBenchReport(os.Stdout, report)
+	}
+
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBenchMix(t *testing.T) {
+	mix, err := parseBenchMix("c:50, timer:20,e:1,sc:0")
+	assert.NoError(t, err)
+	assert.Equal(t, []benchMixEntry{{"c", 50}, {"ms", 20}, {"e", 1}, {"sc", 0}}, mix)
+
+	for _, str := range []string{"c", "c:x", "c:-1", "q:1", "c:0"} {
+		_, err := parseBenchMix(str)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestBenchGenerator(t *testing.T) {
+	mix, _ := parseBenchMix("c:1,g:1,s:1,ms:1,h:1,d:1,e:1,sc:1")
+	gen := newBenchGenerator(1, mix, 5, 2, 3)
+
+	names := map[string]struct{}{}
+	tags := map[string]struct{}{}
+	for _, msg := range dogstatsd.SplitPacket([]byte(gen.packet(1000))) {
+		dMsg, err := dogstatsd.Parse(msg)
+		assert.NoError(t, err, string(msg))
+
+		switch m := dMsg.(type) {
+		case dogstatsd.Metric:
+			names[m.Name] = struct{}{}
+			assert.Len(t, m.Tags, 2)
+			for _, tag := range m.Tags {
+				tags[tag] = struct{}{}
+			}
+		case dogstatsd.Event:
+			assert.True(t, strings.HasPrefix(m.Title, "bench event "))
+		}
+	}
+
+	assert.Len(t, names, 5)
+	assert.Len(t, tags, 6)
+}
+
+func TestBenchProbeTag(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	assert.Equal(t, "probe:"+strconv.FormatInt(start.UnixNano(), 36)+"-3", benchProbeTag(start, 3))
+
+	// probes from different runs are never confused
+	assert.NotEqual(t, benchProbeTag(start, 0), benchProbeTag(start.Add(time.Nanosecond), 0))
+}
+
+func TestBench(t *testing.T) {
+	mix, _ := parseBenchMix("c:1,g:1")
+	report, err := bench(benchConfig{
+		duration:    300 * time.Millisecond,
+		drain:       100 * time.Millisecond,
+		senders:     2,
+		rate:        200,
+		batch:       5,
+		mix:         mix,
+		metrics:     10,
+		tags:        1,
+		cardinality: 2,
+	}, nil)
+	assert.NoError(t, err)
+
+	// probe packets carry a single message
+	probes := int64(report.Probes)
+	assert.InDelta(t, 60, report.PacketsSent-probes, 20)
+	assert.Equal(t, (report.PacketsSent-probes)*5+probes, report.MessagesSent)
+	assert.Equal(t, report.MessagesSent, *report.Received)
+	assert.Equal(t, int64(0), *report.PoolRejected)
+	assert.Equal(t, 0.0, report.DropRate)
+	assert.Greater(t, report.Probes, 0)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// 1KiB per tag key per metric, accurate to within a few percent
+const cardinalityPrecision = 10
+
+type unboundedTagValueKind struct {
+	name  string
+	match func(string) bool
+}
+
+var (
+	uuidPattern      = regexp.MustCompile(`(?i)^[0-9a-f]{8}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{12}$`)
+	emailPattern     = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[a-zA-Z]{2,}$`)
+	timestampPattern = regexp.MustCompile(`^1[0-9]{9}([0-9]{3}){0,3}$|^[0-9]{4}-[0-9]{2}-[0-9]{2}[T ][0-9]{2}:[0-9]{2}`)
+	hexIdPattern     = regexp.MustCompile(`(?i)^[0-9a-f]{16,}$`)
+	tokenIdPattern   = regexp.MustCompile(`^(?:[a-z]+[_-])?([A-Za-z0-9]{16,})$`)
+)
+
+// tag values which almost always mean a tag has unbounded cardinality, in order of precedence
+var unboundedTagValueKinds = []unboundedTagValueKind{
+	{"uuid", uuidPattern.MatchString},
+	{"email", emailPattern.MatchString},
+	{"timestamp", timestampPattern.MatchString},
+	{"request id", isRequestId},
+}
+
+// request ids are long hex strings, or long tokens (optionally prefixed, like req_...) of
+// mixed letters and digits
+func isRequestId(value string) bool {
+	if hexIdPattern.MatchString(value) {
+		return true
+	}
+
+	match := tokenIdPattern.FindStringSubmatch(value)
+	if match == nil {
+		return false
+	}
+
+	digits := 0
+	for _, c := range match[1] {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	return digits >= 4 && digits < len(match[1])
+}
+
+// classify a tag value as likely to be unbounded, returning the kind of value it looks like
+func classifyUnboundedTagValue(value string) (string, bool) {
+	for _, kind := range unboundedTagValueKinds {
+		if kind.match(value) {
+			return kind.name, true
+		}
+	}
+
+	return "", false
+}
+
+type cardinalityKey struct {
+	distinct *hyperLogLog
+	warned   bool
+	kinds    map[string]struct{}
+}
+
+// cardinalityDetector estimates the number of distinct values of each tag key per metric
+// name, warning when a key crosses the threshold or has values which look unbounded
+type cardinalityDetector struct {
+	threshold int
+
+	mu   sync.Mutex
+	keys map[string]*cardinalityKey
+}
+
+func newCardinalityDetector(threshold int) *cardinalityDetector {
+	return &cardinalityDetector{
+		threshold: threshold,
+		keys:      map[string]*cardinalityKey{},
+	}
+}
+
+func (c *cardinalityDetector) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range metric.Tags {
+		tagKey, value, _ := strings.Cut(tag, ":")
+
+		key, ok := c.keys[metric.Name+"|"+tagKey]
+		if !ok {
+			key = &cardinalityKey{
+				distinct: newHyperLogLog(cardinalityPrecision),
+				kinds:    map[string]struct{}{},
+			}
+			c.keys[metric.Name+"|"+tagKey] = key
+		}
+
+		key.distinct.add(value)
+		if estimate := int(key.distinct.estimate()); !key.warned && estimate > c.threshold {
+			key.warned = true
+			log.Printf(
+				"WARNING: HIGH CARDINALITY tag %s on metric %s: ~%d distinct values (threshold %d)",
+				tagKey, metric.Name, estimate, c.threshold,
+			)
+		}
+
+		if kind, ok := classifyUnboundedTagValue(value); ok {
+			if _, warned := key.kinds[kind]; !warned {
+				key.kinds[kind] = struct{}{}
+				log.Printf(
+					"WARNING: UNBOUNDED tag %s on metric %s: value looks unbounded (%s: %s)",
+					tagKey, metric.Name, kind, tag,
+				)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyUnboundedTagValue(t *testing.T) {
+	var tests = []struct {
+		value string
+		kind  string
+	}{
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c3301", "uuid"},
+		{"3F2504E04F8911D39A0C0305E82C3301", "uuid"},
+		{"jane.doe@example.com", "email"},
+		{"1652180400", "timestamp"},
+		{"1652180400123", "timestamp"},
+		{"2022-05-10T12:00:00Z", "timestamp"},
+		{"5d41402abc4b2a76b9719d911017c592aa", "request id"},
+		{"req_01G2KZ8Y5QH3V7X9P4M6N8R2T0", "request id"},
+		{"dev", ""},
+		{"us-east-1", ""},
+		{"200", ""},
+		{"checkout-service", ""},
+		{"kubernetesnodepool", ""},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			kind, ok := classifyUnboundedTagValue(tt.value)
+			assert.Equal(tt.kind != "", ok)
+			assert.Equal(tt.kind, kind)
+		})
+	}
+}
+
+func TestCardinalityDetectorHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	c := newCardinalityDetector(50)
+	for i := 0; i < 500; i++ {
+		assert.NoError(c.handler([]byte(fmt.Sprintf("page.views:1|c|#env:dev,user:u%d", i))))
+		assert.NoError(c.handler([]byte(fmt.Sprintf("page.errors:1|c|#user:u%d", i%20))))
+	}
+
+	// only the key which crossed the threshold is reported, and only once
+	assert.Equal(1, strings.Count(logged.String(), "WARNING"), logged.String())
+	assert.Contains(logged.String(), "WARNING: HIGH CARDINALITY tag user on metric page.views: ~")
+	assert.Contains(logged.String(), "distinct values (threshold 50)")
+}
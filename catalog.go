@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// how many distinct values to remember for each tag key
+const catalogTagValueSamples = 5
+
+type catalogEntry struct {
+	Name        string              `json:"name"`
+	Types       []string            `json:"types"`
+	TagKeys     []string            `json:"tag_keys"`
+	TagValues   map[string][]string `json:"tag_value_samples"`
+	SampleRates []float64           `json:"sample_rates"`
+	Packets     int64               `json:"packets"`
+	FirstSeen   time.Time           `json:"first_seen"`
+	LastSeen    time.Time           `json:"last_seen"`
+	Conflicts   bool                `json:"conflicts"`
+
+	types       map[dogstatsd.MetricType]struct{}
+	tagValues   map[string]map[string]struct{}
+	sampleRates map[float64]struct{}
+}
+
+// catalog tracks every metric name seen, warning when a name is sent with more than one
+// type or sample rate
+type catalog struct {
+	mu      sync.Mutex
+	entries map[string]*catalogEntry
+}
+
+func newCatalog() *catalog {
+	return &catalog{
+		entries: map[string]*catalogEntry{},
+	}
+}
+
+func (c *catalog) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[metric.Name]
+	if !ok {
+		entry = &catalogEntry{
+			Name:        metric.Name,
+			FirstSeen:   metric.Timestamp,
+			types:       map[dogstatsd.MetricType]struct{}{},
+			tagValues:   map[string]map[string]struct{}{},
+			sampleRates: map[float64]struct{}{},
+		}
+		c.entries[metric.Name] = entry
+	}
+
+	// the handler pool doesn't preserve arrival order
+	entry.Packets++
+	if metric.Timestamp.Before(entry.FirstSeen) {
+		entry.FirstSeen = metric.Timestamp
+	}
+	if metric.Timestamp.After(entry.LastSeen) {
+		entry.LastSeen = metric.Timestamp
+	}
+
+	if _, ok := entry.types[metric.MetricType]; !ok {
+		entry.types[metric.MetricType] = struct{}{}
+		if len(entry.types) > 1 {
+			entry.Conflicts = true
+			log.Printf(
+				"WARNING: TYPE CONFLICT for metric %s: seen as %s (packet: %s)",
+				metric.Name, strings.Join(entry.typeNames(), ", "), string(metric.Data()),
+			)
+		}
+	}
+
+	if _, ok := entry.sampleRates[metric.SampleRate]; !ok {
+		entry.sampleRates[metric.SampleRate] = struct{}{}
+		if len(entry.sampleRates) > 1 {
+			entry.Conflicts = true
+			log.Printf(
+				"WARNING: SAMPLE RATE CONFLICT for metric %s: seen with rates %s (packet: %s)",
+				metric.Name, strings.Join(entry.sampleRateNames(), ", "), string(metric.Data()),
+			)
+		}
+	}
+
+	for _, tag := range metric.Tags {
+		key, value, hasValue := strings.Cut(tag, ":")
+		values, ok := entry.tagValues[key]
+		if !ok {
+			values = map[string]struct{}{}
+			entry.tagValues[key] = values
+		}
+
+		if hasValue && len(values) < catalogTagValueSamples {
+			values[value] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (e *catalogEntry) typeNames() []string {
+	names := make([]string, 0, len(e.types))
+	for metricType := range e.types {
+		names = append(names, metricType.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *catalogEntry) sampleRateNames() []string {
+	names := make([]string, 0, len(e.sampleRates))
+	for rate := range e.sampleRates {
+		names = append(names, strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *catalog) snapshot() []*catalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*catalogEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entry := *e
+		entry.Types = e.typeNames()
+
+		entry.SampleRates = make([]float64, 0, len(e.sampleRates))
+		for rate := range e.sampleRates {
+			entry.SampleRates = append(entry.SampleRates, rate)
+		}
+		sort.Float64s(entry.SampleRates)
+
+		entry.TagKeys = make([]string, 0, len(e.tagValues))
+		entry.TagValues = map[string][]string{}
+		for key, values := range e.tagValues {
+			entry.TagKeys = append(entry.TagKeys, key)
+			for value := range values {
+				entry.TagValues[key] = append(entry.TagValues[key], value)
+			}
+			sort.Strings(entry.TagValues[key])
+		}
+		sort.Strings(entry.TagKeys)
+
+		entries = append(entries, &entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+// dump the catalog to dest: "-" prints it as text to stderr, otherwise it is written to
+// the named file as JSON (.json) or text
+func (c *catalog) dump(dest string) error {
+	entries := c.snapshot()
+
+	if dest == "-" {
+		return writeTextCatalog(os.Stderr, entries)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(dest)) == ".json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	return writeTextCatalog(f, entries)
+}
+
+func writeTextCatalog(w io.Writer, entries []*catalogEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPES\tSAMPLE RATES\tPACKETS\tFIRST SEEN\tLAST SEEN\tTAGS")
+	for _, e := range entries {
+		rates := make([]string, 0, len(e.SampleRates))
+		for _, rate := range e.SampleRates {
+			rates = append(rates, strconv.FormatFloat(rate, 'g', -1, 64))
+		}
+
+		tags := make([]string, 0, len(e.TagKeys))
+		for _, key := range e.TagKeys {
+			if len(e.TagValues[key]) == 0 {
+				tags = append(tags, key)
+				continue
+			}
+			tags = append(tags, fmt.Sprintf("%s:[%s]", key, strings.Join(e.TagValues[key], ",")))
+		}
+
+		name := e.Name
+		if e.Conflicts {
+			name = "!" + name
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			name,
+			strings.Join(e.Types, ","),
+			strings.Join(rates, ","),
+			e.Packets,
+			e.FirstSeen.Format(time.RFC3339),
+			e.LastSeen.Format(time.RFC3339),
+			strings.Join(tags, " "),
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCatalog(t *testing.T) *catalog {
+	c := newCatalog()
+	msgs := []string{
+		"api.requests:1|c|#env:dev,debug",
+		"api.requests:1|g|#env:prod",
+		"api.requests:1|g|#env:prod",
+		"api.latency:10|ms|@0.5",
+		"api.latency:20|ms|@0.5",
+		"api.latency:30|ms",
+		"db.queries:1|c|#env:dev",
+		"_e{5,4}:title|text",
+		"not a metric",
+	}
+	for i := 0; i < 7; i++ {
+		msgs = append(msgs, fmt.Sprintf("db.queries:1|c|#shard:%d", i))
+	}
+
+	for _, msg := range msgs {
+		assert.NoError(t, c.handler([]byte(msg)))
+	}
+	return c
+}
+
+func TestCatalogConflicts(t *testing.T) {
+	assert := assert.New(t)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	entries := testCatalog(t).snapshot()
+	assert.Len(entries, 3)
+
+	latency, requests, queries := entries[0], entries[1], entries[2]
+	assert.Equal("api.latency", latency.Name)
+	assert.True(latency.Conflicts)
+	assert.Equal([]string{"timer"}, latency.Types)
+	assert.Equal([]float64{0.5, 1}, latency.SampleRates)
+
+	assert.Equal("api.requests", requests.Name)
+	assert.True(requests.Conflicts)
+	assert.Equal([]string{"counter", "gauge"}, requests.Types)
+	assert.Equal(int64(3), requests.Packets)
+	assert.Equal([]string{"debug", "env"}, requests.TagKeys)
+	assert.Equal(map[string][]string{"env": {"dev", "prod"}}, requests.TagValues)
+
+	// only so many values are sampled for each key
+	assert.Equal("db.queries", queries.Name)
+	assert.False(queries.Conflicts)
+	assert.Equal([]string{"env", "shard"}, queries.TagKeys)
+	assert.Len(queries.TagValues["shard"], catalogTagValueSamples)
+
+	// each conflict is warned about when it's first seen
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if assert.Len(lines, 2) {
+		assert.Contains(lines[0], "WARNING: TYPE CONFLICT for metric api.requests: seen as counter, gauge (packet: api.requests:1|g|#env:prod)")
+		assert.Contains(lines[1], "WARNING: SAMPLE RATE CONFLICT for metric api.latency: seen with rates 0.5, 1 (packet: api.latency:30|ms)")
+	}
+}
+
+func TestCatalogDump(t *testing.T) {
+	assert := assert.New(t)
+
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	c := testCatalog(t)
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "catalog.json")
+	assert.NoError(c.dump(jsonFile))
+	data, err := os.ReadFile(jsonFile)
+	assert.NoError(err)
+
+	entries := []*catalogEntry{}
+	assert.NoError(json.Unmarshal(data, &entries))
+	if assert.Len(entries, 3) {
+		assert.Equal("api.requests", entries[1].Name)
+		assert.Equal([]string{"counter", "gauge"}, entries[1].Types)
+		assert.Equal([]float64{1}, entries[1].SampleRates)
+		assert.True(entries[1].Conflicts)
+		assert.False(entries[1].FirstSeen.IsZero())
+	}
+
+	// conflicting names are marked in text
+	textFile := filepath.Join(dir, "catalog.txt")
+	assert.NoError(c.dump(textFile))
+	data, err = os.ReadFile(textFile)
+	assert.NoError(err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(lines, 4) {
+		assert.Regexp(`^NAME\s+TYPES\s+SAMPLE RATES\s+PACKETS\s+FIRST SEEN\s+LAST SEEN\s+TAGS$`, lines[0])
+		assert.Regexp(`^!api\.latency\s+timer\s+0\.5,1\s+3\s`, lines[1])
+		assert.Regexp(`^!api\.requests\s+counter,gauge\s+1\s+3\s.*\sdebug env:\[dev,prod\]$`, lines[2])
+		assert.Regexp(`^db\.queries\s+counter\s+1\s+8\s.*\senv:\[dev\] shard:\[\d,\d,\d,\d,\d\]$`, lines[3])
+	}
+
+	assert.Error(c.dump(filepath.Join(dir, "missing", "catalog.json")))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"metric counter a x1", "metric counter b x1", "metric gauge c x1", "metric gauge d x1"}
+	b := []string{"metric counter a x1", "metric counter b x2", "metric gauge d x1", "metric gauge e x1"}
+
+	assert.Equal(t, []diffOp{
+		{' ', "metric counter a x1"},
+		{'-', "metric counter b x1"},
+		{'-', "metric gauge c x1"},
+		{'+', "metric counter b x2"},
+		{' ', "metric gauge d x1"},
+		{'+', "metric gauge e x1"},
+	}, diffLines(a, b))
+
+	assert.Empty(t, diffLines(nil, nil))
+	assert.Equal(t, []diffOp{{'-', "a"}}, diffLines([]string{"a"}, nil))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	var tests = []struct {
+		name    string
+		a       []string
+		b       []string
+		context int
+		diff    string
+	}{
+		{
+			"same",
+			[]string{"a", "b"},
+			[]string{"a", "b"},
+			3,
+			"",
+		},
+		{
+			"changed",
+			[]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"},
+			[]string{"1", "2", "3", "4", "five", "6", "7", "8", "9", "10", "11", "12", "13"},
+			3,
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n",
+		},
+		{
+			"added to empty",
+			[]string{},
+			[]string{"a"},
+			3,
+			"--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n",
+		},
+		{
+			"removed",
+			[]string{"a", "b", "c"},
+			[]string{"a", "c"},
+			3,
+			"--- a\n+++ b\n@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			"removed everything",
+			[]string{"a", "b"},
+			[]string{},
+			3,
+			"--- a\n+++ b\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			"added metric",
+			[]string{"metric counter api.requests x2", "metric gauge fuel.level x1"},
+			[]string{"metric counter api.errors x1", "metric counter api.requests x2", "metric gauge fuel.level x1"},
+			3,
+			"--- a\n+++ b\n@@ -1,2 +1,3 @@\n+metric counter api.errors x1\n metric counter api.requests x2\n metric gauge fuel.level x1\n",
+		},
+		{
+			"removed metric",
+			[]string{"metric counter api.errors x1", "metric counter api.requests x2", "metric gauge fuel.level x1"},
+			[]string{"metric counter api.errors x1", "metric gauge fuel.level x1"},
+			3,
+			"--- a\n+++ b\n@@ -1,3 +1,2 @@\n metric counter api.errors x1\n-metric counter api.requests x2\n metric gauge fuel.level x1\n",
+		},
+		{
+			"changed metric",
+			[]string{"metric counter api.requests #env:dev x2", "metric gauge fuel.level x1"},
+			[]string{"metric counter api.requests #env:dev x3", "metric gauge fuel.level x1"},
+			3,
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n-metric counter api.requests #env:dev x2\n+metric counter api.requests #env:dev x3\n metric gauge fuel.level x1\n",
+		},
+		{
+			"no context",
+			[]string{"a", "b", "c", "d"},
+			[]string{"a", "B", "c", "D"},
+			0,
+			"--- a\n+++ b\n@@ -2,1 +2,1 @@\n-b\n+B\n@@ -4,1 +4,1 @@\n-d\n+D\n",
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(tt.diff, unifiedDiff("a", "b", tt.a, tt.b, tt.context))
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package dogstatsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Encode serializes a message into canonical dogstatsd wire format, such that Parse returns
+// the same message. Optional fields are only written when they differ from their defaults, and
+// a message with a field the format can't represent (a tag containing a comma, say) is an error
+func Encode(msg Msg) ([]byte, error) {
+	switch m := msg.(type) {
+	case Metric:
+		return encodeMetric(m)
+	case Event:
+		return encodeEvent(m)
+	case ServiceCheck:
+		return encodeServiceCheck(m)
+	}
+
+	return nil, fmt.Errorf("UNENCODABLE_MSG_TYPE (%T)", msg)
+}
+
+// check a field contains none of the separators which would change how it is parsed
+func checkField(field string, value string, separators string) error {
+	if strings.ContainsAny(value, separators) {
+		return fmt.Errorf("UNENCODABLE_%s (%q)", field, value)
+	}
+
+	return nil
+}
+
+func encodeTags(tags []string) (string, error) {
+	for _, tag := range tags {
+		if err := checkField("TAG", tag, ",|\n"); err != nil {
+			return "", err
+		}
+	}
+
+	return "#" + strings.Join(tags, ","), nil
+}
+
+// extras are written back as-is, so they mustn't look like a field with a meaning of its own
+func checkExtras(extras []string, prefixes ...string) error {
+	for _, extra := range extras {
+		if err := checkField("EXTRA", extra, "|\n"); err != nil {
+			return err
+		}
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(extra, prefix) {
+				return fmt.Errorf("UNENCODABLE_EXTRA (%q)", extra)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newlines in event text and service check messages are escaped, so a literal \n would be
+// read back as a newline
+func escapeNewlines(field string, value string) (string, error) {
+	if strings.Contains(value, `\n`) {
+		return "", fmt.Errorf("UNENCODABLE_%s (%q)", field, value)
+	}
+
+	return strings.ReplaceAll(value, "\n", `\n`), nil
+}
+
+func unescapeNewlines(value string) string {
+	return strings.ReplaceAll(value, `\n`, "\n")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metric.name:value1:value2|type|@sample_rate|#tag1:value,tag2|c:container_id|extras
+func encodeMetric(metric Metric) ([]byte, error) {
+	if metric.Name == "" {
+		return nil, fmt.Errorf("UNENCODABLE_NAME (%q)", metric.Name)
+	}
+	if err := checkField("NAME", metric.Name, ":|\n"); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(metric.Name, "_e{") || strings.HasPrefix(metric.Name, "_sc") {
+		return nil, fmt.Errorf("UNENCODABLE_NAME (%q)", metric.Name)
+	}
+
+	code := metric.MetricType.Code()
+	if code == "" {
+		return nil, fmt.Errorf("UNENCODABLE_TYPE (%d)", metric.MetricType)
+	}
+
+	if len(metric.Values) == 0 {
+		return nil, fmt.Errorf("UNENCODABLE_VALUES (none)")
+	}
+
+	var b strings.Builder
+	b.WriteString(metric.Name)
+
+	// the raw value is kept when it is an equivalent spelling of the numeric value
+	for _, value := range metric.Values {
+		if math.IsNaN(value.Numeric) || math.IsInf(value.Numeric, 0) {
+			return nil, fmt.Errorf("UNENCODABLE_VALUE (%g)", value.Numeric)
+		}
+
+		raw := formatFloat(value.Numeric)
+		if value.Raw != "" {
+			parsed, err := strconv.ParseFloat(value.Raw, 64)
+			if err != nil || parsed != value.Numeric || strings.ContainsAny(value.Raw, ":|\n") {
+				return nil, fmt.Errorf("UNENCODABLE_VALUE (%q)", value.Raw)
+			}
+			raw = value.Raw
+		}
+
+		b.WriteString(":" + raw)
+	}
+
+	b.WriteString("|" + code)
+
+	if metric.SampleRate != 1 {
+		if !(metric.SampleRate > 0 && metric.SampleRate < 1) {
+			return nil, fmt.Errorf("UNENCODABLE_SAMPLE_RATE (%g)", metric.SampleRate)
+		}
+		b.WriteString("|@" + formatFloat(metric.SampleRate))
+	}
+
+	if len(metric.Tags) > 0 {
+		tags, err := encodeTags(metric.Tags)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("|" + tags)
+	}
+
+	if metric.ContainerId != "" {
+		if err := checkField("CONTAINER_ID", metric.ContainerId, "|\n"); err != nil {
+			return nil, err
+		}
+		b.WriteString("|c:" + metric.ContainerId)
+	}
+
+	if err := checkExtras(metric.Extras, "@", "#", "c:"); err != nil {
+		return nil, err
+	}
+	for _, extra := range metric.Extras {
+		b.WriteString("|" + extra)
+	}
+
+	return []byte(b.String()), nil
+}
+
+// _e{<TITLE_UTF8_LENGTH>,<TEXT_UTF8_LENGTH>}:<TITLE>|<TEXT>|d:<TIMESTAMP>|h:<HOSTNAME>|k:<AGGREGATION_KEY>|p:<PRIORITY>|s:<SOURCE_TYPE>|t:<ALERT_TYPE>|#<TAGS>
+func encodeEvent(event Event) ([]byte, error) {
+	title, err := escapeNewlines("TITLE", event.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := escapeNewlines("TEXT", event.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "_e{%d,%d}:%s|%s", len(title), len(text), title, text)
+
+	if !event.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "|d:%d", event.Timestamp.Unix())
+	}
+
+	for _, field := range []struct {
+		name, prefix, value string
+	}{
+		{"HOSTNAME", "h:", event.Hostname},
+		{"AGGREGATION_KEY", "k:", event.AggregationKey},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if err := checkField(field.name, field.value, "|\n"); err != nil {
+			return nil, err
+		}
+		b.WriteString("|" + field.prefix + field.value)
+	}
+
+	switch event.Priority {
+	case NormalEventPriority:
+	case LowEventPriority:
+		b.WriteString("|p:low")
+	default:
+		return nil, fmt.Errorf("UNENCODABLE_PRIORITY (%d)", event.Priority)
+	}
+
+	if event.SourceType != "" {
+		if err := checkField("SOURCE_TYPE", event.SourceType, "|\n"); err != nil {
+			return nil, err
+		}
+		b.WriteString("|s:" + event.SourceType)
+	}
+
+	switch event.AlertType {
+	case InfoEventAlertType:
+	case SuccessEventAlertType, WarningEventAlertType, ErrorEventAlertType:
+		b.WriteString("|t:" + event.AlertType.String())
+	default:
+		return nil, fmt.Errorf("UNENCODABLE_ALERT_TYPE (%d)", event.AlertType)
+	}
+
+	if len(event.Tags) > 0 {
+		tags, err := encodeTags(event.Tags)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("|" + tags)
+	}
+
+	if err := checkExtras(event.Extras, "d:", "h:", "k:", "p:", "s:", "t:", "#"); err != nil {
+		return nil, err
+	}
+	for _, extra := range event.Extras {
+		b.WriteString("|" + extra)
+	}
+
+	return []byte(b.String()), nil
+}
+
+// _sc|<NAME>|<STATUS>|d:<TIMESTAMP>|h:<HOSTNAME>|#<TAGS>|m:<SERVICE_CHECK_MESSAGE>
+func encodeServiceCheck(serviceCheck ServiceCheck) ([]byte, error) {
+	if err := checkField("NAME", serviceCheck.Name, "|\n"); err != nil {
+		return nil, err
+	}
+
+	if serviceCheck.Status < OkServiceCheckStatusType || serviceCheck.Status > UnknownServiceCheckStatusType {
+		return nil, fmt.Errorf("UNENCODABLE_STATUS (%d)", serviceCheck.Status)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "_sc|%s|%d", serviceCheck.Name, serviceCheck.Status)
+
+	if !serviceCheck.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "|d:%d", serviceCheck.Timestamp.Unix())
+	}
+
+	if serviceCheck.Hostname != "" {
+		if err := checkField("HOSTNAME", serviceCheck.Hostname, "|\n"); err != nil {
+			return nil, err
+		}
+		b.WriteString("|h:" + serviceCheck.Hostname)
+	}
+
+	if len(serviceCheck.Tags) > 0 {
+		tags, err := encodeTags(serviceCheck.Tags)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("|" + tags)
+	}
+
+	if err := checkExtras(serviceCheck.Extras, "d:", "h:", "#", "m:"); err != nil {
+		return nil, err
+	}
+	for _, extra := range serviceCheck.Extras {
+		b.WriteString("|" + extra)
+	}
+
+	// the message comes last
+	if serviceCheck.Message != "" {
+		message, err := escapeNewlines("MESSAGE", serviceCheck.Message)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkField("MESSAGE", message, "|"); err != nil {
+			return nil, err
+		}
+		b.WriteString("|m:" + message)
+	}
+
+	return []byte(b.String()), nil
+}
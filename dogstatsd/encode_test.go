@@ -0,0 +1,319 @@
+package dogstatsd
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	var tests = []struct {
+		name string
+		msg  Msg
+		data string
+	}{
+		{
+			"counter",
+			Metric{Name: "page.views", MetricType: CounterMetricType, Values: []MetricValue{{Numeric: 1}}, SampleRate: 1},
+			"page.views:1|c",
+		},
+		{
+			"timer with everything",
+			Metric{
+				Name:        "api.latency",
+				MetricType:  TimerMetricType,
+				Values:      []MetricValue{{Raw: "12.0", Numeric: 12}, {Numeric: 0.25}},
+				SampleRate:  0.5,
+				Tags:        []string{"env:dev", "route:/home"},
+				ContainerId: "c1",
+				Extras:      []string{"T1656581400"},
+			},
+			"api.latency:12.0:0.25|ms|@0.5|#env:dev,route:/home|c:c1|T1656581400",
+		},
+		{
+			"event",
+			Event{
+				Title:          "Déploy | finished",
+				Text:           "line 1\nline 2",
+				Timestamp:      time.Unix(10, 0),
+				Hostname:       "host.name",
+				AggregationKey: "deploys",
+				Priority:       LowEventPriority,
+				SourceType:     "jenkins",
+				AlertType:      ErrorEventAlertType,
+				Tags:           []string{"env:dev"},
+			},
+			`_e{18,14}:Déploy | finished|line 1\nline 2|d:10|h:host.name|k:deploys|p:low|s:jenkins|t:error|#env:dev`,
+		},
+		{
+			"service check",
+			ServiceCheck{
+				Name:      "db.up",
+				Status:    CriticalServiceCheckStatusType,
+				Timestamp: time.Unix(10, 0),
+				Hostname:  "host.name",
+				Tags:      []string{"env:dev"},
+				Message:   "timed out\nafter 10s",
+			},
+			`_sc|db.up|2|d:10|h:host.name|#env:dev|m:timed out\nafter 10s`,
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.msg)
+			assert.NoError(err)
+			assert.Equal(tt.data, string(data))
+
+			parsed, err := Parse(data)
+			assert.NoError(err)
+			assert.Equal(normalizeMsg(tt.msg), normalizeMsg(parsed))
+		})
+	}
+
+	// without a timestamp, the receive time is used
+	data, err := Encode(Event{Title: "Error", Text: "Error"})
+	assert.NoError(err)
+	assert.Equal("_e{5,5}:Error|Error", string(data))
+}
+
+func TestEncodeErrors(t *testing.T) {
+	metric := func(fn func(m *Metric)) Metric {
+		m := Metric{Name: "a", MetricType: GaugeMetricType, Values: []MetricValue{{Numeric: 1}}, SampleRate: 1}
+		fn(&m)
+		return m
+	}
+
+	var tests = []struct {
+		msg Msg
+		err string
+	}{
+		{metric(func(m *Metric) { m.Name = "" }), `UNENCODABLE_NAME ("")`},
+		{metric(func(m *Metric) { m.Name = "a:b" }), `UNENCODABLE_NAME ("a:b")`},
+		{metric(func(m *Metric) { m.Name = "_sc.checks" }), `UNENCODABLE_NAME ("_sc.checks")`},
+		{metric(func(m *Metric) { m.MetricType = 42 }), "UNENCODABLE_TYPE (42)"},
+		{metric(func(m *Metric) { m.Values = nil }), "UNENCODABLE_VALUES (none)"},
+		{metric(func(m *Metric) { m.Values[0].Numeric = math.NaN() }), "UNENCODABLE_VALUE (NaN)"},
+		{metric(func(m *Metric) { m.Values[0].Raw = "2" }), `UNENCODABLE_VALUE ("2")`},
+		{metric(func(m *Metric) { m.SampleRate = 0 }), "UNENCODABLE_SAMPLE_RATE (0)"},
+		{metric(func(m *Metric) { m.Tags = []string{"a,b"} }), `UNENCODABLE_TAG ("a,b")`},
+		{metric(func(m *Metric) { m.Tags = []string{"a\nb"} }), `UNENCODABLE_TAG ("a\nb")`},
+		{metric(func(m *Metric) { m.ContainerId = "a|b" }), `UNENCODABLE_CONTAINER_ID ("a|b")`},
+		{metric(func(m *Metric) { m.Extras = []string{"#tag"} }), `UNENCODABLE_EXTRA ("#tag")`},
+		{Event{Title: `a\nb`}, `UNENCODABLE_TITLE ("a\\nb")`},
+		{Event{Title: "a", Hostname: "a|b"}, `UNENCODABLE_HOSTNAME ("a|b")`},
+		{Event{Title: "a", Priority: 5}, "UNENCODABLE_PRIORITY (5)"},
+		{Event{Title: "a", AlertType: 5}, "UNENCODABLE_ALERT_TYPE (5)"},
+		{Event{Title: "a", Extras: []string{"t:info"}}, `UNENCODABLE_EXTRA ("t:info")`},
+		{ServiceCheck{Name: "a", Status: 4}, "UNENCODABLE_STATUS (4)"},
+		{ServiceCheck{Name: "a", Message: "a|b"}, `UNENCODABLE_MESSAGE ("a|b")`},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			_, err := Encode(tt.msg)
+			assert.EqualError(err, tt.err)
+		})
+	}
+}
+
+// normalize fields which a round trip through the wire format doesn't preserve: the raw
+// datagram, the receive time of metrics, derived durations and nil versus empty slices
+func normalizeMsg(msg Msg) Msg {
+	emptyToNil := func(s []string) []string {
+		if len(s) == 0 {
+			return nil
+		}
+		return s
+	}
+
+	switch m := msg.(type) {
+	case Metric:
+		m.data = nil
+		m.Timestamp = time.Time{}
+		values := make([]MetricValue, len(m.Values))
+		for i, value := range m.Values {
+			if value.Raw == "" {
+				value.Raw = formatFloat(value.Numeric)
+			}
+			value.Duration = 0
+			values[i] = value
+		}
+		m.Values = values
+		m.Tags = emptyToNil(m.Tags)
+		m.Extras = emptyToNil(m.Extras)
+		return m
+	case Event:
+		m.data = nil
+		m.Tags = emptyToNil(m.Tags)
+		m.Extras = emptyToNil(m.Extras)
+		return m
+	case ServiceCheck:
+		m.data = nil
+		m.Tags = emptyToNil(m.Tags)
+		m.Extras = emptyToNil(m.Extras)
+		return m
+	}
+
+	return msg
+}
+
+var (
+	// characters which never need escaping, including multi-byte ones to check lengths are
+	// counted in bytes
+	safeChars = []rune("abcxyzABC019_-./é日")
+	// every separator in the format, which only some fields may contain
+	allChars = []rune("abc019_-./é日 :|@#,\n\\")
+)
+
+func randString(r *rand.Rand, chars []rune, max int) string {
+	s := make([]rune, r.Intn(max+1))
+	for i := range s {
+		s[i] = chars[r.Intn(len(chars))]
+	}
+	return string(s)
+}
+
+func randStrings(r *rand.Rand, chars []rune, max int) []string {
+	s := make([]string, r.Intn(max+1))
+	for i := range s {
+		s[i] = randString(r, chars, 10)
+	}
+	return s
+}
+
+func randFloat(r *rand.Rand) float64 {
+	switch r.Intn(3) {
+	case 0:
+		return float64(r.Intn(2000) - 1000)
+	case 1:
+		return r.NormFloat64() * 1000
+	}
+	return r.ExpFloat64() * 1e-6
+}
+
+func randTimestamp(r *rand.Rand) time.Time {
+	return time.Unix(r.Int63n(4e9), 0)
+}
+
+// randMetric builds a random metric from chars; with safeChars it can always be encoded
+func randMetric(r *rand.Rand, chars []rune) Metric {
+	metric := Metric{
+		Name:       "m" + randString(r, chars, 20),
+		MetricType: MetricType(r.Intn(6)),
+		SampleRate: 1,
+		Tags:       randStrings(r, chars, 4),
+		Extras:     randStrings(r, chars, 2),
+	}
+
+	for i := r.Intn(3); i >= 0; i-- {
+		value := randFloat(r)
+		raw := []string{
+			formatFloat(value),
+			strconv.FormatFloat(value, 'f', -1, 64),
+			strconv.FormatFloat(value, 'e', -1, 64),
+		}[r.Intn(3)]
+		metric.Values = append(metric.Values, MetricValue{Raw: raw, Numeric: value})
+	}
+
+	if r.Intn(2) == 0 {
+		metric.SampleRate = r.Float64()
+	}
+
+	if r.Intn(2) == 0 {
+		metric.ContainerId = randString(r, chars, 10)
+	}
+
+	return metric
+}
+
+func randEvent(r *rand.Rand, chars []rune) Event {
+	// newlines in titles and text are escaped
+	textChars := append([]rune{'\n', '|'}, chars...)
+
+	return Event{
+		Title:          randString(r, textChars, 20),
+		Text:           randString(r, textChars, 50),
+		Timestamp:      randTimestamp(r),
+		Hostname:       randString(r, chars, 10),
+		AggregationKey: randString(r, chars, 10),
+		Priority:       EventPriority(r.Intn(2)),
+		SourceType:     randString(r, chars, 10),
+		AlertType:      EventAlertType(r.Intn(4)),
+		Tags:           randStrings(r, chars, 4),
+		Extras:         randStrings(r, chars, 2),
+	}
+}
+
+func randServiceCheck(r *rand.Rand, chars []rune) ServiceCheck {
+	return ServiceCheck{
+		Name:      randString(r, chars, 20),
+		Status:    ServiceCheckStatus(r.Intn(4)),
+		Timestamp: randTimestamp(r),
+		Hostname:  randString(r, chars, 10),
+		Tags:      randStrings(r, chars, 4),
+		Extras:    randStrings(r, chars, 2),
+		Message:   randString(r, append([]rune{'\n'}, chars...), 30),
+	}
+}
+
+// encodableMsg generates messages which can always be encoded
+type encodableMsg struct{ Msg }
+
+func (encodableMsg) Generate(r *rand.Rand, size int) reflect.Value {
+	msgs := []Msg{randMetric(r, safeChars), randEvent(r, safeChars), randServiceCheck(r, safeChars)}
+	return reflect.ValueOf(encodableMsg{msgs[r.Intn(len(msgs))]})
+}
+
+// arbitraryMsg generates messages whose fields may contain any separator
+type arbitraryMsg struct{ Msg }
+
+func (arbitraryMsg) Generate(r *rand.Rand, size int) reflect.Value {
+	msgs := []Msg{randMetric(r, allChars), randEvent(r, allChars), randServiceCheck(r, allChars)}
+	return reflect.ValueOf(arbitraryMsg{msgs[r.Intn(len(msgs))]})
+}
+
+func roundTrips(msg Msg) bool {
+	data, err := Encode(msg)
+	if err != nil {
+		return false
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		return false
+	}
+
+	// the encoding is canonical: encoding the parsed message gives the same datagram
+	again, err := Encode(parsed)
+	if err != nil || string(again) != string(data) {
+		return false
+	}
+
+	return reflect.DeepEqual(normalizeMsg(msg), normalizeMsg(parsed))
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	config := &quick.Config{MaxCount: 5000}
+
+	// every message built from safe characters can be encoded, and parses back the same
+	err := quick.Check(func(m encodableMsg) bool { return roundTrips(m.Msg) }, config)
+	assert.NoError(t, err)
+
+	// any other message is either rejected by the encoder, or parses back the same
+	err = quick.Check(func(m arbitraryMsg) bool {
+		if _, err := Encode(m.Msg); err != nil {
+			return true
+		}
+		return roundTrips(m.Msg)
+	}, config)
+	assert.NoError(t, err)
+}
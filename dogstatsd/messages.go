@@ -0,0 +1,515 @@
+// Package dogstatsd parses the dogstatsd protocol (<=v1.2) and provides a UDP server which
+// receives it
+package dogstatsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MsgType int
+
+func (d MsgType) String() string {
+	switch d {
+	case MetricMsgType:
+		return "metric"
+	case EventMsgType:
+		return "event"
+	case ServiceCheckMsgType:
+		return "service_check"
+	}
+
+	return "unknown"
+}
+
+const (
+	MetricMsgType MsgType = iota
+	ServiceCheckMsgType
+	EventMsgType
+)
+
+// Msg is a parsed datagram: a Metric, Event or ServiceCheck
+type Msg interface {
+	Type() MsgType
+	Data() []byte
+}
+
+func parseMetricMsg(buf []byte) (Msg, error) {
+	metric := Metric{
+		data:       buf,
+		Timestamp:  time.Now(),
+		Values:     []MetricValue{},
+		SampleRate: 1.0,
+		Tags:       []string{},
+	}
+
+	// sample message: metric.name:value1:value2|type|@sample_rate|#tag1:value,tag2|c:container_id
+	pieces := strings.Split(string(buf), "|")
+	if len(pieces) < 2 {
+		return nil, errors.New("INVALID_MSG_MISSING_NAME_VALUE_OR_TYPE")
+	}
+
+	addrAndValues := strings.Split(pieces[0], ":")
+	if len(addrAndValues) < 2 {
+		return nil, fmt.Errorf("INVALID_MSG_MISSING_NAME_AND_VALUE (%s)", pieces[0])
+	}
+
+	metric.Name = addrAndValues[0]
+	rawValues := addrAndValues[1:]
+
+	switch pieces[1] {
+	case "c":
+		metric.MetricType = CounterMetricType
+	case "g":
+		metric.MetricType = GaugeMetricType
+	case "s":
+		metric.MetricType = SetMetricType
+	case "ms":
+		metric.MetricType = TimerMetricType
+	case "h":
+		metric.MetricType = HistogramMetricType
+	case "d":
+		metric.MetricType = DistributionMetricType
+	default:
+		return nil, fmt.Errorf("INVALID_MSG_INVALID_TYPE (%s)", pieces[1])
+	}
+
+	// all numeric values are ints or floats, stored as floats
+	for _, rawValue := range rawValues {
+		value := MetricValue{
+			Raw: rawValue,
+		}
+
+		floatValue, err := strconv.ParseFloat(rawValue, 64)
+		if err != nil {
+			return nil, fmt.Errorf("INVALID_MSG_INVALID_VALUE (%s)", rawValue)
+		}
+		value.Numeric = floatValue
+
+		if metric.MetricType == TimerMetricType {
+			value.Duration = time.Duration(value.Numeric) / time.Millisecond
+		}
+
+		metric.Values = append(metric.Values, value)
+	}
+
+	// parse out sample rate, tags, container id, and any extras
+	for _, piece := range pieces[2:] {
+		if strings.HasPrefix(piece, "@") {
+			sampleRate, err := strconv.ParseFloat(piece[1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("INVALID_SAMPLE_RATE (%s)", piece[:1])
+			}
+			metric.SampleRate = sampleRate
+			continue
+		}
+
+		if strings.HasPrefix(piece, "#") {
+			tags := strings.Split(piece[1:], ",")
+			metric.Tags = append(metric.Tags, tags...)
+			continue
+		}
+
+		if strings.HasPrefix(piece, "c:") {
+			metric.ContainerId = piece[2:]
+			continue
+		}
+
+		metric.Extras = append(metric.Extras, piece)
+	}
+
+	return metric, nil
+}
+
+type MetricType int
+
+func (d MetricType) String() string {
+	switch d {
+	case GaugeMetricType:
+		return "gauge"
+	case CounterMetricType:
+		return "counter"
+	case SetMetricType:
+		return "set"
+	case TimerMetricType:
+		return "timer"
+	case HistogramMetricType:
+		return "histogram"
+	case DistributionMetricType:
+		return "distribution"
+	}
+
+	return "unknown"
+}
+
+// Code is the metric type's wire format, or empty if it has none
+func (d MetricType) Code() string {
+	switch d {
+	case GaugeMetricType:
+		return "g"
+	case CounterMetricType:
+		return "c"
+	case SetMetricType:
+		return "s"
+	case TimerMetricType:
+		return "ms"
+	case HistogramMetricType:
+		return "h"
+	case DistributionMetricType:
+		return "d"
+	}
+
+	return ""
+}
+
+const (
+	GaugeMetricType MetricType = iota
+	CounterMetricType
+	SetMetricType
+	TimerMetricType
+	HistogramMetricType
+	DistributionMetricType
+)
+
+type MetricValue struct {
+	Raw      string
+	Numeric  float64
+	Duration time.Duration
+}
+
+type Metric struct {
+	data      []byte
+	Timestamp time.Time
+
+	Name string
+
+	MetricType MetricType
+	Values     []MetricValue
+
+	SampleRate  float64
+	Tags        []string
+	ContainerId string
+	Extras      []string
+}
+
+func (d Metric) Data() []byte {
+	return d.data
+}
+
+func (d Metric) Type() MsgType {
+	return MetricMsgType
+}
+
+// _sc|<NAME>|<STATUS>|d:<TIMESTAMP>|h:<HOSTNAME>|#<TAG_KEY_1>:<TAG_VALUE_1>,<TAG_2>|m:<SERVICE_CHECK_MESSAGE>
+func parseServiceCheckMsg(buf []byte) (Msg, error) {
+	serviceCheck := ServiceCheck{
+		data:      buf,
+		Timestamp: time.Now(),
+		Tags:      []string{},
+		Extras:    []string{},
+	}
+
+	pieces := strings.Split(string(buf), "|")
+	if len(pieces) < 3 {
+		return nil, errors.New("INVALID_MSG_MISSING_NAME_OR_STATUS")
+	}
+
+	serviceCheck.Name = pieces[1]
+
+	switch pieces[2] {
+	case "0":
+		serviceCheck.Status = OkServiceCheckStatusType
+	case "1":
+		serviceCheck.Status = WarningServiceCheckStatusType
+	case "2":
+		serviceCheck.Status = CriticalServiceCheckStatusType
+	case "3":
+		serviceCheck.Status = UnknownServiceCheckStatusType
+	default:
+		return nil, fmt.Errorf("INVALID_MSG_INVALID_STATUS (%s)", pieces[2])
+	}
+
+	for _, piece := range pieces[3:] {
+		if strings.HasPrefix(piece, "d:") {
+			unixTime, err := strconv.ParseInt(piece[2:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("INVALID_TIMESTAMP (%s)", piece[2:])
+			}
+
+			serviceCheck.Timestamp = time.Unix(unixTime, 0)
+			continue
+		}
+
+		if strings.HasPrefix(piece, "h:") {
+			serviceCheck.Hostname = piece[2:]
+			continue
+		}
+
+		if strings.HasPrefix(piece, "#") {
+			tags := strings.Split(piece[1:], ",")
+			serviceCheck.Tags = append(serviceCheck.Tags, tags...)
+			continue
+		}
+
+		if strings.HasPrefix(piece, "m:") {
+			serviceCheck.Message = unescapeNewlines(piece[2:])
+			continue
+		}
+
+		serviceCheck.Extras = append(serviceCheck.Extras, piece)
+	}
+
+	return serviceCheck, nil
+}
+
+type ServiceCheckStatus int
+
+const (
+	OkServiceCheckStatusType ServiceCheckStatus = iota
+	WarningServiceCheckStatusType
+	CriticalServiceCheckStatusType
+	UnknownServiceCheckStatusType
+)
+
+func (s ServiceCheckStatus) String() string {
+	switch s {
+	case OkServiceCheckStatusType:
+		return "OK"
+	case WarningServiceCheckStatusType:
+		return "WARNING"
+	case CriticalServiceCheckStatusType:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+type ServiceCheck struct {
+	data      []byte
+	Name      string
+	Status    ServiceCheckStatus
+	Timestamp time.Time
+	Hostname  string
+	Tags      []string
+	Message   string
+	Extras    []string
+}
+
+func (ServiceCheck) Type() MsgType {
+	return ServiceCheckMsgType
+}
+
+func (d ServiceCheck) Data() []byte {
+	return d.data
+}
+
+// docs: https://docs.datadoghq.com/developers/dogstatsd/datagram_shell/?tab=events
+// _e{<TITLE_UTF8_LENGTH>,<TEXT_UTF8_LENGTH>}:<TITLE>|<TEXT>|d:<TIMESTAMP>|h:<HOSTNAME>|p:<PRIORITY>|t:<ALERT_TYPE>|#<TAG_KEY_1>:<TAG_VALUE_1>,<TAG_2>
+func parseEventMsg(buf []byte) (Msg, error) {
+	event := Event{
+		data:      buf,
+		Timestamp: time.Now(),
+		Tags:      []string{},
+	}
+
+	// the title and text are sliced out by their lengths, so may contain any character
+	header, rest, ok := strings.Cut(string(buf), ":")
+	if !ok || !strings.HasSuffix(header, "}") {
+		return nil, fmt.Errorf("INVALID_MSG_MISSING_TITLE (%s)", header)
+	}
+
+	titleLength, textLength, err := parseEventLengths(header[len("_e{") : len(header)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// lengths are checked one at a time, as adding them first could overflow
+	if titleLength >= len(rest) || textLength > len(rest)-titleLength-1 || rest[titleLength] != '|' {
+		return nil, errors.New("INVALID_MSG_MISSING_TITLE_OR_TEXT")
+	}
+	event.Title = unescapeNewlines(rest[:titleLength])
+	event.Text = unescapeNewlines(rest[titleLength+1 : titleLength+1+textLength])
+
+	pieces := []string{}
+	if rest = rest[titleLength+1+textLength:]; rest != "" {
+		if rest[0] != '|' {
+			return nil, fmt.Errorf("INVALID_MSG_TEXT_LENGTH (%d)", textLength)
+		}
+		pieces = strings.Split(rest[1:], "|")
+	}
+
+	for _, piece := range pieces {
+		if strings.HasPrefix(piece, "d:") {
+			unixTime, err := strconv.ParseInt(piece[2:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("INVALID_TIMESTAMP (%s)", piece[2:])
+			}
+
+			event.Timestamp = time.Unix(unixTime, 0)
+			continue
+		}
+
+		if strings.HasPrefix(piece, "h:") {
+			event.Hostname = piece[2:]
+			continue
+		}
+
+		if strings.HasPrefix(piece, "k:") {
+			event.AggregationKey = piece[2:]
+			continue
+		}
+
+		if strings.HasPrefix(piece, "p:") {
+			switch piece[2:] {
+			case "low":
+				event.Priority = LowEventPriority
+			case "normal":
+				event.Priority = NormalEventPriority
+			default:
+				return nil, fmt.Errorf("INVALID_MSG_INVALID_PRIORITY (%s)", piece[2:])
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(piece, "s:") {
+			event.SourceType = piece[2:]
+			continue
+		}
+
+		if strings.HasPrefix(piece, "t:") {
+			switch piece[2:] {
+			case "info":
+				event.AlertType = InfoEventAlertType
+			case "success":
+				event.AlertType = SuccessEventAlertType
+			case "warning":
+				event.AlertType = WarningEventAlertType
+			case "error":
+				event.AlertType = ErrorEventAlertType
+			default:
+				return nil, fmt.Errorf("INVALID_MSG_INVALID_ALERT_TYPE (%s)", piece[2:])
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(piece, "#") {
+			tags := strings.Split(piece[1:], ",")
+			event.Tags = append(event.Tags, tags...)
+			continue
+		}
+
+		event.Extras = append(event.Extras, piece)
+	}
+
+	return event, nil
+}
+
+// parse the title and text lengths from an event header: _e{<TITLE_LENGTH>,<TEXT_LENGTH>}
+func parseEventLengths(lengths string) (int, int, error) {
+	title, text, ok := strings.Cut(lengths, ",")
+	titleLength, err := strconv.Atoi(title)
+	if !ok || err != nil || titleLength < 0 {
+		return 0, 0, fmt.Errorf("INVALID_MSG_INVALID_LENGTHS (%s)", lengths)
+	}
+
+	textLength, err := strconv.Atoi(text)
+	if err != nil || textLength < 0 {
+		return 0, 0, fmt.Errorf("INVALID_MSG_INVALID_LENGTHS (%s)", lengths)
+	}
+
+	return titleLength, textLength, nil
+}
+
+type EventPriority int
+
+const (
+	NormalEventPriority EventPriority = iota
+	LowEventPriority
+)
+
+func (p EventPriority) String() string {
+	switch p {
+	case NormalEventPriority:
+		return "normal"
+	case LowEventPriority:
+		return "low"
+	}
+	return "unknown"
+}
+
+type EventAlertType int
+
+const (
+	InfoEventAlertType EventAlertType = iota
+	SuccessEventAlertType
+	WarningEventAlertType
+	ErrorEventAlertType
+)
+
+func (a EventAlertType) String() string {
+	switch a {
+	case InfoEventAlertType:
+		return "info"
+	case SuccessEventAlertType:
+		return "success"
+	case WarningEventAlertType:
+		return "warning"
+	case ErrorEventAlertType:
+		return "error"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	data  []byte
+	Title string
+	Text  string
+
+	Timestamp      time.Time
+	Hostname       string
+	AggregationKey string
+	Priority       EventPriority
+	SourceType     string
+	AlertType      EventAlertType
+	Tags           []string
+	Extras         []string
+}
+
+func (e Event) Data() []byte {
+	return e.data
+}
+
+func (e Event) Type() MsgType {
+	return EventMsgType
+}
+
+// SplitPacket splits a datagram into the newline-separated messages it carries, as sent by
+// clients which buffer several messages per packet, skipping empty lines
+func SplitPacket(buf []byte) [][]byte {
+	msgs := [][]byte{}
+	for _, msg := range bytes.Split(buf, []byte("\n")) {
+		if len(msg) > 0 {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return msgs
+}
+
+// Parse a dogstatsd datagram, returning the correct message type back
+func Parse(buf []byte) (Msg, error) {
+	if bytes.HasPrefix(buf, []byte("_e{")) {
+		return parseEventMsg(buf)
+	}
+
+	if bytes.HasPrefix(buf, []byte("_sc")) {
+		return parseServiceCheckMsg(buf)
+	}
+
+	return parseMetricMsg(buf)
+}
@@ -0,0 +1,295 @@
+package dogstatsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDogstatsdMetricMsg(t *testing.T) {
+	var tests = []struct {
+		rawMsg      string
+		name        string
+		metricType  MetricType
+		values      []float64
+		sampleRate  float64
+		tags        []string
+		containerId string
+	}{
+		{
+			"page.views:1|c",
+			"page.views",
+			CounterMetricType,
+			[]float64{1.0},
+			1.0,
+			[]string{},
+			"",
+		},
+		{
+			"fuel.level:0.5|g",
+			"fuel.level",
+			GaugeMetricType,
+			[]float64{0.5},
+			1.0,
+			[]string{},
+			"",
+		},
+		{
+			"song.length:240|h|@0.5",
+			"song.length",
+			HistogramMetricType,
+			[]float64{240},
+			0.5,
+			[]string{},
+			"",
+		},
+		{
+			"users.uniques:1234|s",
+			"users.uniques",
+			SetMetricType,
+			[]float64{1234},
+			1.0,
+			[]string{},
+			"",
+		},
+		{
+			"users.online:1|c|@0.5|#country:china",
+			"users.online",
+			CounterMetricType,
+			[]float64{1},
+			0.5,
+			[]string{"country:china"},
+			"",
+		},
+		{
+			"page.views:1:2:9001|d|@0.5|#env:ci,test:1,error|c:83c0a99c0a54c0c187f461c7980e9b57f3f6a8b0c918c8d93df19a9de6f3fe1d",
+			"page.views",
+			DistributionMetricType,
+			[]float64{1, 2, 9001},
+			0.5,
+			[]string{"env:ci", "test:1", "error"},
+			"83c0a99c0a54c0c187f461c7980e9b57f3f6a8b0c918c8d93df19a9de6f3fe1d",
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.rawMsg, func(t *testing.T) {
+			msg, _ := Parse([]byte(tt.rawMsg))
+			assert.Equal(MetricMsgType, msg.Type())
+			assert.Equal([]byte(tt.rawMsg), msg.Data())
+
+			metric, _ := msg.(Metric)
+			assert.Equal(tt.name, metric.Name)
+			assert.Equal(tt.metricType, metric.MetricType)
+			assert.InDelta(time.Now().UnixMicro(), metric.Timestamp.UnixMicro(), 100)
+
+			floatVals := []float64{}
+			for _, val := range metric.Values {
+				floatVals = append(floatVals, val.Numeric)
+			}
+			assert.Equal(tt.values, floatVals)
+
+			assert.Equal(tt.sampleRate, metric.SampleRate)
+			assert.Equal(tt.tags, metric.Tags)
+			assert.Equal(tt.containerId, metric.ContainerId)
+
+			assert.Empty(metric.Extras)
+		})
+	}
+}
+
+func TestParseDogstatsdEventMsg(t *testing.T) {
+	var tests = []struct {
+		rawMsg         string
+		title          string
+		text           string
+		ts             time.Time
+		hostname       string
+		aggregationKey string
+		priority       EventPriority
+		sourceType     string
+		alertType      EventAlertType
+		tags           []string
+	}{
+		{
+			"_e{21,36}:An exception occurred|Cannot parse CSV file from 10.0.0.17|t:warning|#err_type:bad_file",
+			"An exception occurred",
+			"Cannot parse CSV file from 10.0.0.17",
+			time.Now(),
+			"",
+			"",
+			NormalEventPriority,
+			"",
+			WarningEventAlertType,
+			[]string{"err_type:bad_file"},
+		},
+		{
+			"_e{21,42}:An exception occurred|Cannot parse JSON request:\\\\n{\"foo: \"bar\"}|p:low|#err_type:bad_request",
+			"An exception occurred",
+			"Cannot parse JSON request:\\\n{\"foo: \"bar\"}",
+			time.Now(),
+			"",
+			"",
+			LowEventPriority,
+			"",
+			InfoEventAlertType,
+			[]string{"err_type:bad_request"},
+		},
+		{
+			"_e{11,12}:Error|Title|Text: a|b\\nc|s:my apps",
+			"Error|Title",
+			"Text: a|b\nc",
+			time.Now(),
+			"",
+			"",
+			NormalEventPriority,
+			"my apps",
+			InfoEventAlertType,
+			[]string{},
+		},
+		{
+			"_e{5,5}:Error|Error|d:10|h:host.name|k:host.name|p:normal|s:unknown|t:error|#key:val,a:1,b",
+			"Error",
+			"Error",
+			time.Unix(10, 0),
+			"host.name",
+			"host.name",
+			NormalEventPriority,
+			"unknown",
+			ErrorEventAlertType,
+			[]string{"key:val", "a:1", "b"},
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.rawMsg, func(t *testing.T) {
+			msg, _ := Parse([]byte(tt.rawMsg))
+			assert.Equal(EventMsgType, msg.Type())
+			assert.Equal([]byte(tt.rawMsg), msg.Data())
+
+			event, _ := msg.(Event)
+			assert.Equal(tt.title, event.Title)
+			assert.Equal(tt.text, event.Text)
+			assert.InDelta(tt.ts.UnixMicro(), event.Timestamp.UnixMicro(), 100)
+			assert.Equal(tt.hostname, event.Hostname)
+			assert.Equal(tt.aggregationKey, event.AggregationKey)
+			assert.Equal(tt.priority, event.Priority)
+			assert.Equal(tt.sourceType, event.SourceType)
+			assert.Equal(tt.alertType, event.AlertType)
+			assert.Equal(tt.tags, event.Tags)
+			assert.Empty(event.Extras)
+		})
+	}
+}
+
+func TestParseDogstatsdServiceCheckMsg(t *testing.T) {
+	var tests = []struct {
+		rawMsg   string
+		name     string
+		status   ServiceCheckStatus
+		ts       time.Time
+		hostname string
+		tags     []string
+		message  string
+	}{
+		{
+			"_sc|Redis connection|2|#env:dev|m:Redis connection timed out after 10s",
+			"Redis connection",
+			CriticalServiceCheckStatusType,
+			time.Now(),
+			"",
+			[]string{"env:dev"},
+			"Redis connection timed out after 10s",
+		},
+		{
+			"_sc|DB connection|0|d:10|h:host.name|#env:ci,key:1,val|m:Nothing to report",
+			"DB connection",
+			OkServiceCheckStatusType,
+			time.Unix(10, 0),
+			"host.name",
+			[]string{"env:ci", "key:1", "val"},
+			"Nothing to report",
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.rawMsg, func(t *testing.T) {
+			msg, _ := Parse([]byte(tt.rawMsg))
+			assert.Equal(ServiceCheckMsgType, msg.Type())
+			assert.Equal([]byte(tt.rawMsg), msg.Data())
+
+			serviceCheck, _ := msg.(ServiceCheck)
+			assert.Equal(tt.name, serviceCheck.Name)
+			assert.Equal(tt.status, serviceCheck.Status)
+			assert.InDelta(tt.ts.UnixMicro(), serviceCheck.Timestamp.UnixMicro(), 100)
+			assert.Equal(tt.hostname, serviceCheck.Hostname)
+			assert.Equal(tt.tags, serviceCheck.Tags)
+			assert.Equal(tt.message, serviceCheck.Message)
+			assert.Empty(serviceCheck.Extras)
+		})
+	}
+}
+
+func TestSplitPacket(t *testing.T) {
+	var tests = []struct {
+		packet string
+		msgs   []string
+	}{
+		{"page.views:1|c", []string{"page.views:1|c"}},
+		{"page.views:1|c\nfuel.level:0.5|g\n", []string{"page.views:1|c", "fuel.level:0.5|g"}},
+		{"\n\n_sc|db|0\n\n", []string{"_sc|db|0"}},
+		{"", []string{}},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.packet, func(t *testing.T) {
+			msgs := []string{}
+			for _, msg := range SplitPacket([]byte(tt.packet)) {
+				msgs = append(msgs, string(msg))
+			}
+			assert.Equal(tt.msgs, msgs)
+		})
+	}
+}
+
+func TestParseDogstatsdEventMsgErrors(t *testing.T) {
+	var tests = []struct {
+		rawMsg string
+		err    string
+	}{
+		{"_e{5,5}Error|Error", "INVALID_MSG_MISSING_TITLE (_e{5,5}Error|Error)"},
+		{"_e{5}:Error|Error", "INVALID_MSG_INVALID_LENGTHS (5)"},
+		{"_e{a,5}:Error|Error", "INVALID_MSG_INVALID_LENGTHS (a,5)"},
+		{"_e{5,9}:Error|Error", "INVALID_MSG_MISSING_TITLE_OR_TEXT"},
+		{"_e{4,5}:Error|Error", "INVALID_MSG_MISSING_TITLE_OR_TEXT"},
+		{"_e{5,4}:Error|Error", "INVALID_MSG_TEXT_LENGTH (4)"},
+		{"_e{9223372036854775807,0}:a|b", "INVALID_MSG_MISSING_TITLE_OR_TEXT"},
+		{"_e{1,9223372036854775806}:a|b", "INVALID_MSG_MISSING_TITLE_OR_TEXT"},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.rawMsg, func(t *testing.T) {
+			_, err := Parse([]byte(tt.rawMsg))
+			assert.EqualError(err, tt.err)
+		})
+	}
+}
+
+func TestMetricTypeCode(t *testing.T) {
+	assert := assert.New(t)
+
+	// every type's code parses back to it
+	for metricType := GaugeMetricType; metricType <= DistributionMetricType; metricType++ {
+		msg, err := Parse([]byte("a:1|" + metricType.Code()))
+		assert.NoError(err, metricType.String())
+		assert.Equal(metricType, msg.(Metric).MetricType)
+	}
+
+	assert.Equal("", MetricType(-1).Code())
+}
@@ -0,0 +1,177 @@
+package dogstatsd
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// the largest datagram read, matching the datadog agent's default dogstatsd_buffer_size
+const readBufferSize = 8192
+
+// PacketHandler receives every datagram along with the address it was sent from
+type PacketHandler func(msg []byte, addr net.Addr) error
+
+// Server listens for dogstatsd datagrams, passing each one to its PacketHandler
+type Server interface {
+	// Listen blocks, receiving datagrams until Stop is called
+	Listen() error
+	// Stop waits for Listen to return; it may be called more than once, and returns at once if
+	// the server isn't listening
+	Stop() error
+
+	// Addr blocks until the server is listening and returns the address it is bound to, which
+	// is useful when listening on port 0. It returns nil if the server failed to listen
+	Addr() net.Addr
+}
+
+// NewServer creates a UDP server for addr (host:port)
+func NewServer(addr string, fn PacketHandler) Server {
+	return &udpServer{
+		packetHandler: fn,
+		rawAddr:       addr,
+		readDeadline:  time.Second / 4,
+		writeDeadline: time.Second / 4,
+		errCh:         make(chan error, 1),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+		readyCh:       make(chan struct{}),
+		wg:            sync.WaitGroup{},
+	}
+}
+
+type udpServer struct {
+	packetHandler PacketHandler
+	rawAddr       string
+	addr          net.Addr
+
+	readDeadline  time.Duration
+	writeDeadline time.Duration
+
+	stopCh  chan struct{} // closed by Stop
+	doneCh  chan struct{} // closed once Listen has finished listening
+	errCh   chan error
+	readyCh chan struct{}
+
+	mu        sync.Mutex
+	listening bool
+	stopped   bool
+	stopOnce  sync.Once
+
+	wg sync.WaitGroup
+}
+
+func (u *udpServer) Listen() error {
+	defer func() {
+		select {
+		case <-u.readyCh:
+		default:
+			close(u.readyCh)
+		}
+	}()
+
+	addr, err := net.ResolveUDPAddr("udp", u.rawAddr)
+	if err != nil {
+		return err
+	}
+
+	serverConn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	// a server stopped before it started listening never does
+	u.mu.Lock()
+	if u.stopped {
+		u.mu.Unlock()
+		serverConn.Close()
+		return nil
+	}
+	u.listening = true
+	u.mu.Unlock()
+
+	u.addr = serverConn.LocalAddr()
+	close(u.readyCh)
+
+	u.wg.Add(1)
+	go u.errHandler()
+
+	// large enough for clients which buffer several messages into each packet
+	buf := make([]byte, readBufferSize)
+	respMsg := []byte{}
+
+	for {
+		select {
+		case <-u.stopCh:
+			goto stop
+		default:
+		}
+
+		serverConn.SetDeadline(time.Now().Add(u.readDeadline))
+
+		n, clientAddr, err := serverConn.ReadFromUDP(buf)
+		if err != nil {
+			// check if the error is a timeout error
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+
+			u.errCh <- err
+			continue
+		}
+
+		// copy the message and pass it to the handler function
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		u.packetHandler(msg, clientAddr)
+
+		// respond to the origin connection
+		serverConn.SetWriteDeadline(time.Now().Add(u.writeDeadline))
+		_, err = serverConn.WriteToUDP(respMsg, clientAddr)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+
+			u.errCh <- err
+		}
+	}
+
+stop:
+	serverConn.Close()
+
+	// close the err channel and wait for any in progress errors to complete
+	close(u.errCh)
+	u.wg.Wait()
+	close(u.doneCh)
+	return nil
+}
+
+func (u *udpServer) Addr() net.Addr {
+	<-u.readyCh
+	return u.addr
+}
+
+func (u *udpServer) errHandler() {
+	for err := range u.errCh {
+		log.Println(err.Error())
+	}
+
+	u.wg.Done()
+}
+
+func (u *udpServer) Stop() error {
+	u.mu.Lock()
+	u.stopped = true
+	listening := u.listening
+	u.mu.Unlock()
+
+	u.stopOnce.Do(func() { close(u.stopCh) })
+
+	// wait for the server to finish, if it ever started
+	if listening {
+		<-u.doneCh
+	}
+	return nil
+}
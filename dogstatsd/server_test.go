@@ -0,0 +1,76 @@
+package dogstatsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fail the test rather than hang if fn doesn't return in time
+func withinTimeout(t *testing.T, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	assert := assert.New(t)
+
+	received := make(chan string, 1)
+	srv := NewServer("127.0.0.1:0", func(msg []byte, _ net.Addr) error {
+		received <- string(msg)
+		return nil
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Listen() }()
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	assert.NoError(err)
+	conn.Write([]byte("a:1|c"))
+	conn.Close()
+	assert.Equal("a:1|c", <-received)
+
+	withinTimeout(t, func() {
+		assert.NoError(srv.Stop())
+		assert.NoError(<-errCh)
+		assert.NoError(srv.Stop())
+	})
+}
+
+func TestServerStopWithoutListening(t *testing.T) {
+	assert := assert.New(t)
+
+	// never listened
+	srv := NewServer("127.0.0.1:0", nil)
+	withinTimeout(t, func() {
+		assert.NoError(srv.Stop())
+		assert.NoError(srv.Stop())
+	})
+
+	// stopped first, so never starts
+	withinTimeout(t, func() {
+		assert.NoError(srv.Listen())
+	})
+
+	// failed to listen
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(err)
+	defer busy.Close()
+
+	srv = NewServer(busy.LocalAddr().String(), nil)
+	assert.Error(srv.Listen())
+	assert.Nil(srv.Addr())
+	withinTimeout(t, func() {
+		assert.NoError(srv.Stop())
+	})
+}
@@ -0,0 +1,236 @@
+// Package dogstatsdtest runs an in-process dogstatsd server for go tests, collecting the
+// messages it receives and providing assertions against them
+package dogstatsdtest
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// DefaultTimeout is how long waits and assertions wait for messages to arrive
+const DefaultTimeout = 5 * time.Second
+
+// Server listens on an ephemeral localhost port, parsing and storing everything it receives
+type Server struct {
+	// Timeout overrides DefaultTimeout for this server
+	Timeout time.Duration
+
+	srv  dogstatsd.Server
+	done chan struct{}
+
+	mu          sync.Mutex
+	msgs        []dogstatsd.Msg
+	parseErrors []error
+	updated     chan struct{} // closed whenever a message arrives
+}
+
+// NewServer starts a server, stopping it when the test finishes
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+
+	s := &Server{
+		Timeout: DefaultTimeout,
+		done:    make(chan struct{}),
+		updated: make(chan struct{}),
+	}
+
+	s.srv = dogstatsd.NewServer("127.0.0.1:0", s.handler)
+	go func() {
+		defer close(s.done)
+		if err := s.srv.Listen(); err != nil {
+			tb.Errorf("dogstatsdtest: %s", err.Error())
+		}
+	}()
+
+	if s.srv.Addr() == nil {
+		<-s.done
+		tb.FailNow()
+	}
+
+	tb.Cleanup(func() {
+		s.srv.Stop()
+		<-s.done
+	})
+
+	return s
+}
+
+func (s *Server) handler(packet []byte, _ net.Addr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range dogstatsd.SplitPacket(packet) {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil {
+			s.parseErrors = append(s.parseErrors, err)
+			continue
+		}
+
+		s.msgs = append(s.msgs, dMsg)
+	}
+
+	close(s.updated)
+	s.updated = make(chan struct{})
+	return nil
+}
+
+// Addr is the host:port to send dogstatsd datagrams to
+func (s *Server) Addr() string {
+	return s.srv.Addr().String()
+}
+
+// Messages returns every message received since the server started or was last reset
+func (s *Server) Messages() []dogstatsd.Msg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := make([]dogstatsd.Msg, len(s.msgs))
+	copy(msgs, s.msgs)
+	return msgs
+}
+
+// ParseErrors returns the errors from any datagrams which could not be parsed
+func (s *Server) ParseErrors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(s.parseErrors))
+	copy(errs, s.parseErrors)
+	return errs
+}
+
+// Metrics returns the metrics received with the given name and (at least) the given tags
+func (s *Server) Metrics(name string, tags ...string) []dogstatsd.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.metrics(name, tags)
+}
+
+func (s *Server) metrics(name string, tags []string) []dogstatsd.Metric {
+	metrics := []dogstatsd.Metric{}
+	for _, msg := range s.msgs {
+		metric, ok := msg.(dogstatsd.Metric)
+		if ok && metric.Name == name && hasTags(metric.Tags, tags) {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return metrics
+}
+
+// Reset forgets every message received so far
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.msgs = nil
+	s.parseErrors = nil
+}
+
+// wait until cond (called with the lock held) returns true or the timeout passes, returning
+// whether the condition was met
+func (s *Server) wait(cond func() bool) bool {
+	timeout := time.After(s.Timeout)
+	for {
+		s.mu.Lock()
+		ok := cond()
+		updated := s.updated
+		s.mu.Unlock()
+
+		if ok {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// WaitForMetric waits for a metric with the given name and tags to arrive, returning the
+// first one received; the test fails immediately if none arrives before the timeout
+func (s *Server) WaitForMetric(tb testing.TB, name string, tags ...string) dogstatsd.Metric {
+	tb.Helper()
+
+	var metric dogstatsd.Metric
+	found := s.wait(func() bool {
+		metrics := s.metrics(name, tags)
+		if len(metrics) > 0 {
+			metric = metrics[0]
+		}
+		return len(metrics) > 0
+	})
+
+	if !found {
+		tb.Fatalf("dogstatsdtest: metric %s %v not received within %s", name, tags, s.Timeout)
+	}
+
+	return metric
+}
+
+// CounterSum totals the values of every counter received with the given name and tags,
+// scaled up by their sample rates
+func (s *Server) CounterSum(name string, tags ...string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counterSum(name, tags)
+}
+
+func (s *Server) counterSum(name string, tags []string) float64 {
+	sum := 0.0
+	for _, metric := range s.metrics(name, tags) {
+		if metric.MetricType != dogstatsd.CounterMetricType {
+			continue
+		}
+
+		for _, value := range metric.Values {
+			sum += value.Numeric / metric.SampleRate
+		}
+	}
+
+	return sum
+}
+
+// AssertCounterSum waits for the total of the counters with the given name and tags to reach
+// want, failing the test if it is still different once the timeout passes
+func (s *Server) AssertCounterSum(tb testing.TB, name string, want float64, tags ...string) bool {
+	tb.Helper()
+
+	var got float64
+	ok := s.wait(func() bool {
+		got = s.counterSum(name, tags)
+		return got == want
+	})
+
+	if !ok {
+		tb.Errorf("dogstatsdtest: counter %s %v sum is %g, expected %g", name, tags, got, want)
+	}
+
+	return ok
+}
+
+func hasTags(tags []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+package dogstatsdtest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// records failures instead of failing the real test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func send(t *testing.T, addr string, msgs ...string) {
+	conn, err := net.Dial("udp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range msgs {
+		_, err := conn.Write([]byte(msg))
+		assert.NoError(t, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewServer(t)
+	send(t, s.Addr(),
+		"page.views:1|c|#env:ci,route:home",
+		"page.views:2|c|@0.5|#env:ci,route:about",
+		"page.views:4|c|#env:dev\nfuel.level:0.5|g\nnot a metric",
+		"_e{5,5}:Error|Error",
+	)
+
+	metric := s.WaitForMetric(t, "fuel.level")
+	assert.Equal(dogstatsd.GaugeMetricType, metric.MetricType)
+	assert.Equal("page.views", s.WaitForMetric(t, "page.views", "route:about").Name)
+
+	assert.True(s.AssertCounterSum(t, "page.views", 9))
+	assert.True(s.AssertCounterSum(t, "page.views", 5, "env:ci"))
+	assert.Equal(5.0, s.CounterSum("page.views", "env:ci"))
+	assert.Len(s.Metrics("page.views", "env:ci"), 2)
+
+	// wait for the event, which was sent last
+	assert.Eventually(func() bool { return len(s.Messages()) == 5 }, time.Second, time.Millisecond)
+	assert.Len(s.ParseErrors(), 1)
+
+	s.Reset()
+	assert.Empty(s.Messages())
+	assert.Empty(s.ParseErrors())
+	assert.Equal(0.0, s.CounterSum("page.views"))
+}
+
+func TestServerFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewServer(t)
+	s.Timeout = 50 * time.Millisecond
+	send(t, s.Addr(), "page.views:1|c|#env:ci")
+	s.WaitForMetric(t, "page.views")
+
+	tb := &fakeTB{TB: t}
+	s.WaitForMetric(tb, "page.views", "env:dev")
+	assert.False(s.AssertCounterSum(tb, "page.views", 2))
+	assert.Equal([]string{
+		"dogstatsdtest: metric page.views [env:dev] not received within 50ms",
+		"dogstatsdtest: counter page.views [] sum is 1, expected 2",
+	}, tb.failures)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// https://docs.datadoghq.com/api/latest/events/#post-an-event and
+// https://docs.datadoghq.com/api/latest/service-checks/#submit-a-service-check
+const (
+	eventsPath   = "/api/v1/events"
+	checkRunPath = "/api/v1/check_run"
+
+	// how many events and service checks may wait for a flush before further ones are dropped
+	eventsApiQueueSize = 10000
+	// service checks per request
+	checkRunBatchSize = 100
+)
+
+type eventsApiEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	DateHappened   int64    `json:"date_happened"`
+	Host           string   `json:"host,omitempty"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	Priority       string   `json:"priority"`
+	SourceTypeName string   `json:"source_type_name,omitempty"`
+	AlertType      string   `json:"alert_type"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+type checkRunApiCheck struct {
+	Check     string   `json:"check"`
+	HostName  string   `json:"host_name"`
+	Status    int      `json:"status"`
+	Timestamp int64    `json:"timestamp"`
+	Message   string   `json:"message,omitempty"`
+	Tags      []string `json:"tags"`
+}
+
+// eventsApiSink queues events and service checks, posting them to Datadog-compatible
+// /api/v1/events and /api/v1/check_run endpoints every interval, and once more when stopped. The
+// events API takes one event per request, while service checks are batched
+type eventsApiSink struct {
+	eventsUrl   string
+	checkRunUrl string
+	hostname    string
+	client      *intakeClient
+	interval    time.Duration
+
+	mu      sync.Mutex
+	events  []eventsApiEvent
+	checks  []checkRunApiCheck
+	dropped int64
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newEventsApiSink(base string, hostname string, client *intakeClient, interval time.Duration) *eventsApiSink {
+	s := &eventsApiSink{
+		eventsUrl:   intakeUrl(base, eventsPath),
+		checkRunUrl: intakeUrl(base, checkRunPath),
+		hostname:    hostname,
+		client:      client,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+func (s *eventsApiSink) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.events)+len(s.checks) >= eventsApiQueueSize {
+		if dMsg.Type() != dogstatsd.MetricMsgType {
+			s.dropped++
+		}
+		return nil
+	}
+
+	switch m := dMsg.(type) {
+	case dogstatsd.Event:
+		host := m.Hostname
+		if host == "" {
+			host = s.hostname
+		}
+
+		s.events = append(s.events, eventsApiEvent{
+			Title:          m.Title,
+			Text:           m.Text,
+			DateHappened:   m.Timestamp.Unix(),
+			Host:           host,
+			AggregationKey: m.AggregationKey,
+			Priority:       m.Priority.String(),
+			SourceTypeName: m.SourceType,
+			AlertType:      m.AlertType.String(),
+			Tags:           m.Tags,
+		})
+	case dogstatsd.ServiceCheck:
+		host := m.Hostname
+		if host == "" {
+			host = s.hostname
+		}
+
+		// the check_run API requires tags, even if there are none
+		tags := m.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+
+		s.checks = append(s.checks, checkRunApiCheck{
+			Check:     m.Name,
+			HostName:  host,
+			Status:    int(m.Status),
+			Timestamp: m.Timestamp.Unix(),
+			Message:   m.Message,
+			Tags:      tags,
+		})
+	}
+
+	return nil
+}
+
+// flush posts everything queued, logging (and dropping) anything which can't be submitted
+// after retries
+func (s *eventsApiSink) flush() {
+	s.mu.Lock()
+	events, checks, dropped := s.events, s.checks, s.dropped
+	s.events, s.checks, s.dropped = nil, nil, 0
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("events API queue full, dropped %d events and service checks", dropped)
+	}
+
+	failed := 0
+	for _, event := range events {
+		if err := s.client.postJson(s.eventsUrl, event); err != nil {
+			log.Printf("flush error (%s): %s", s.eventsUrl, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d events not submitted", failed, len(events))
+	}
+
+	for start := 0; start < len(checks); start += checkRunBatchSize {
+		end := start + checkRunBatchSize
+		if end > len(checks) {
+			end = len(checks)
+		}
+
+		if err := s.client.postJson(s.checkRunUrl, checks[start:end]); err != nil {
+			log.Printf("flush error (%s): %d service checks not submitted: %s", s.checkRunUrl, end-start, err.Error())
+		}
+	}
+}
+
+func (s *eventsApiSink) run() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stopCh:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *eventsApiSink) stop() {
+	close(s.stopCh)
+	<-s.doneCh
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsApiSink(t *testing.T) {
+	intake := newTestIntake(t)
+	sink := newEventsApiSink(intake.URL, "host1", newTestIntakeClient(), time.Hour)
+
+	sink.handler([]byte("_e{5,4}:title|text|d:100|p:low|t:warning|k:agg|s:src|#env:dev"))
+	sink.handler([]byte("_e{5,4}:other|text|d:200|h:host2"))
+	sink.handler([]byte("_sc|app.ok|1|d:300|m:uh oh"))
+	sink.handler([]byte("_sc|app.up|0|d:400|h:host2|#env:dev"))
+	sink.handler([]byte("page.views:1|c"))
+	sink.stop()
+
+	assert.Len(t, intake.requests, 3)
+	assert.Equal(t, eventsPath, intake.requests[0].URL.Path)
+	assert.JSONEq(t, `{"title": "title", "text": "text", "date_happened": 100, "host": "host1", "aggregation_key": "agg",
+		"priority": "low", "source_type_name": "src", "alert_type": "warning", "tags": ["env:dev"]}`, string(intake.bodies[0]))
+	assert.JSONEq(t, `{"title": "other", "text": "text", "date_happened": 200, "host": "host2", "priority": "normal", "alert_type": "info"}`, string(intake.bodies[1]))
+
+	assert.Equal(t, checkRunPath, intake.requests[2].URL.Path)
+	assert.JSONEq(t, `[
+		{"check": "app.ok", "host_name": "host1", "status": 1, "timestamp": 300, "message": "uh oh", "tags": []},
+		{"check": "app.up", "host_name": "host2", "status": 0, "timestamp": 400, "tags": ["env:dev"]}
+	]`, string(intake.bodies[2]))
+}
+
+func TestEventsApiSinkBatching(t *testing.T) {
+	intake := newTestIntake(t, http.StatusServiceUnavailable)
+	sink := newEventsApiSink(intake.URL, "host1", newTestIntakeClient(), time.Hour)
+
+	for i := 0; i < checkRunBatchSize+1; i++ {
+		sink.handler([]byte(fmt.Sprintf("_sc|check.%d|0", i)))
+	}
+	sink.flush()
+
+	// the first batch is retried after failing
+	assert.Len(t, intake.requests, 3)
+	assert.Equal(t, intake.bodies[0], intake.bodies[1])
+
+	var checks []checkRunApiCheck
+	for _, body := range intake.bodies[1:] {
+		var batch []checkRunApiCheck
+		assert.NoError(t, json.Unmarshal(body, &batch))
+		checks = append(checks, batch...)
+	}
+	assert.Len(t, checks, checkRunBatchSize+1)
+	assert.Equal(t, "check.100", checks[checkRunBatchSize].Check)
+
+	// nothing is left over to send when stopped
+	sink.stop()
+	assert.Len(t, intake.requests, 3)
+}
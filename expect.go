@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"gopkg.in/yaml.v3"
+)
+
+type valueRange struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+
+	// bounds are inclusive unless set
+	minExclusive bool
+	maxExclusive bool
+}
+
+func (r *valueRange) contains(value float64) bool {
+	if r.Min != nil && (value < *r.Min || r.minExclusive && value == *r.Min) {
+		return false
+	}
+
+	if r.Max != nil && (value > *r.Max || r.maxExclusive && value == *r.Max) {
+		return false
+	}
+
+	return true
+}
+
+func (r *valueRange) String() string {
+	switch {
+	case r.Min != nil && r.Max != nil && *r.Min == *r.Max:
+		return fmt.Sprintf("%g", *r.Min)
+	case r.Min != nil && r.Max != nil:
+		return fmt.Sprintf("between %g and %g", *r.Min, *r.Max)
+	case r.Min != nil && r.minExclusive:
+		return fmt.Sprintf("above %g", *r.Min)
+	case r.Min != nil:
+		return fmt.Sprintf("at least %g", *r.Min)
+	case r.Max != nil && r.maxExclusive:
+		return fmt.Sprintf("below %g", *r.Max)
+	case r.Max != nil:
+		return fmt.Sprintf("at most %g", *r.Max)
+	}
+
+	return "any"
+}
+
+// parse a value predicate: a comparison (>500, >=500, <10, <=10, ==1 or just 1) or an
+// inclusive range (0..500, where either end may be left open)
+func parseValueRange(str string) (*valueRange, error) {
+	parse := func(s string) (*float64, error) {
+		if s == "" {
+			return nil, nil
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("INVALID_VALUE_PREDICATE (%s)", str)
+		}
+		return &value, nil
+	}
+
+	r := &valueRange{}
+	var err error
+
+	if min, max, ok := strings.Cut(str, ".."); ok {
+		if r.Min, err = parse(min); err != nil {
+			return nil, err
+		}
+		r.Max, err = parse(max)
+	} else {
+		switch {
+		case strings.HasPrefix(str, ">="):
+			r.Min, err = parse(str[2:])
+		case strings.HasPrefix(str, ">"):
+			r.Min, err = parse(str[1:])
+			r.minExclusive = true
+		case strings.HasPrefix(str, "<="):
+			r.Max, err = parse(str[2:])
+		case strings.HasPrefix(str, "<"):
+			r.Max, err = parse(str[1:])
+			r.maxExclusive = true
+		default:
+			r.Min, err = parse(strings.TrimPrefix(str, "=="))
+			r.Max = r.Min
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	} else if r.Min == nil && r.Max == nil {
+		return nil, fmt.Errorf("INVALID_VALUE_PREDICATE (%s)", str)
+	}
+
+	return r, nil
+}
+
+// msgMatcher describes a set of messages by kind, name, type (or service check status), tags
+// and values; names and tag matchers are globs
+type msgMatcher struct {
+	Kind   string      `yaml:"kind"`
+	Name   string      `yaml:"name"`
+	Type   string      `yaml:"type"`
+	Status string      `yaml:"status"`
+	Tags   []string    `yaml:"tags"`
+	Value  *valueRange `yaml:"value"`
+
+	kind       dogstatsd.MsgType
+	anyKind    bool
+	metricType *dogstatsd.MetricType
+	status     *dogstatsd.ServiceCheckStatus
+}
+
+var metricTypeNames = map[string]dogstatsd.MetricType{
+	"c":            dogstatsd.CounterMetricType,
+	"counter":      dogstatsd.CounterMetricType,
+	"g":            dogstatsd.GaugeMetricType,
+	"gauge":        dogstatsd.GaugeMetricType,
+	"s":            dogstatsd.SetMetricType,
+	"set":          dogstatsd.SetMetricType,
+	"ms":           dogstatsd.TimerMetricType,
+	"timer":        dogstatsd.TimerMetricType,
+	"h":            dogstatsd.HistogramMetricType,
+	"histogram":    dogstatsd.HistogramMetricType,
+	"d":            dogstatsd.DistributionMetricType,
+	"distribution": dogstatsd.DistributionMetricType,
+}
+
+var msgTypeNames = map[string]dogstatsd.MsgType{
+	"":              dogstatsd.MetricMsgType,
+	"metric":        dogstatsd.MetricMsgType,
+	"event":         dogstatsd.EventMsgType,
+	"service_check": dogstatsd.ServiceCheckMsgType,
+	"sc":            dogstatsd.ServiceCheckMsgType,
+}
+
+var serviceCheckStatusNames = map[string]dogstatsd.ServiceCheckStatus{
+	"0":        dogstatsd.OkServiceCheckStatusType,
+	"ok":       dogstatsd.OkServiceCheckStatusType,
+	"1":        dogstatsd.WarningServiceCheckStatusType,
+	"warning":  dogstatsd.WarningServiceCheckStatusType,
+	"2":        dogstatsd.CriticalServiceCheckStatusType,
+	"critical": dogstatsd.CriticalServiceCheckStatusType,
+	"3":        dogstatsd.UnknownServiceCheckStatusType,
+	"unknown":  dogstatsd.UnknownServiceCheckStatusType,
+}
+
+// compile checks the matcher's fields, resolving kind, type and status names
+func (m *msgMatcher) compile() error {
+	// "any" kind is narrowed down by a type, value or status, which only some kinds have
+	if k := strings.ToLower(m.Kind); k == "any" || k == "*" {
+		switch {
+		case m.Type != "" || m.Value != nil:
+			m.kind = dogstatsd.MetricMsgType
+		case m.Status != "":
+			m.kind = dogstatsd.ServiceCheckMsgType
+		default:
+			m.anyKind = true
+		}
+	} else {
+		kind, ok := msgTypeNames[k]
+		if !ok {
+			return fmt.Errorf("INVALID_KIND (%s)", m.Kind)
+		}
+		m.kind = kind
+	}
+
+	if m.Type != "" {
+		metricType, ok := metricTypeNames[strings.ToLower(m.Type)]
+		if !ok || m.kind != dogstatsd.MetricMsgType {
+			return fmt.Errorf("INVALID_TYPE (%s)", m.Type)
+		}
+		m.metricType = &metricType
+	}
+
+	if m.Status != "" {
+		status, ok := serviceCheckStatusNames[strings.ToLower(m.Status)]
+		if !ok || m.kind != dogstatsd.ServiceCheckMsgType {
+			return fmt.Errorf("INVALID_STATUS (%s)", m.Status)
+		}
+		m.status = &status
+	}
+
+	if m.Value != nil && m.kind != dogstatsd.MetricMsgType {
+		return errors.New("INVALID_VALUE (only metrics have values)")
+	}
+
+	for _, pattern := range append([]string{m.Name}, m.Tags...) {
+		if err := checkGlob(pattern); err != nil {
+			return fmt.Errorf("INVALID_PATTERN (%s)", pattern)
+		}
+	}
+
+	return nil
+}
+
+func (m *msgMatcher) matches(dMsg dogstatsd.Msg) bool {
+	if !m.anyKind && dMsg.Type() != m.kind {
+		return false
+	}
+
+	var name string
+	var tags []string
+
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		name, tags = msg.Name, msg.Tags
+		if m.metricType != nil && msg.MetricType != *m.metricType {
+			return false
+		}
+
+		if m.Value != nil {
+			matched := false
+			for _, value := range msg.Values {
+				matched = matched || m.Value.contains(value.Numeric)
+			}
+			if !matched {
+				return false
+			}
+		}
+	case dogstatsd.Event:
+		name, tags = msg.Title, msg.Tags
+	case dogstatsd.ServiceCheck:
+		name, tags = msg.Name, msg.Tags
+		if m.status != nil && msg.Status != *m.status {
+			return false
+		}
+	}
+
+	if m.Name != "" {
+		if !globMatch(m.Name, name) {
+			return false
+		}
+	}
+
+	// every tag matcher must match at least one of the message's tags
+	for _, pattern := range m.Tags {
+		matched := false
+		for _, tag := range tags {
+			if globMatch(pattern, tag) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *msgMatcher) String() string {
+	str := m.kind.String()
+	if m.anyKind {
+		str = "message"
+	}
+	if m.Name != "" {
+		str += " " + m.Name
+	}
+
+	if m.Type != "" {
+		str += fmt.Sprintf(" (type %s)", m.metricType.String())
+	}
+
+	if m.Status != "" {
+		str += fmt.Sprintf(" (status %s)", m.status.String())
+	}
+
+	if len(m.Tags) > 0 {
+		str += fmt.Sprintf(" [%s]", strings.Join(m.Tags, " "))
+	}
+
+	if m.Value != nil {
+		str += " value " + m.Value.String()
+	}
+
+	return str
+}
+
+type countRange struct {
+	Min *int64 `yaml:"min"`
+	Max *int64 `yaml:"max"`
+}
+
+type expectation struct {
+	msgMatcher `yaml:",inline"`
+
+	Count  *countRange `yaml:"count"`
+	Absent bool        `yaml:"absent"`
+
+	received int64
+}
+
+// check the number of matching messages received, returning a description of the failure
+func (e *expectation) check() (string, bool) {
+	if e.Absent && e.received > 0 {
+		return fmt.Sprintf("expected none, received %d", e.received), false
+	}
+
+	if e.Count.Min != nil && e.received < *e.Count.Min {
+		return fmt.Sprintf("expected at least %d, received %d", *e.Count.Min, e.received), false
+	}
+
+	if e.Count.Max != nil && e.received > *e.Count.Max {
+		return fmt.Sprintf("expected at most %d, received %d", *e.Count.Max, e.received), false
+	}
+
+	return fmt.Sprintf("received %d", e.received), true
+}
+
+// expectations checks that the messages described in an expectations file do (or don't)
+// arrive, and how many times
+type expectations struct {
+	Expectations []*expectation `yaml:"expectations"`
+
+	mu sync.Mutex
+}
+
+func loadExpectations(filename string) (*expectations, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseExpectations(f)
+}
+
+// parse YAML (or JSON) expectations
+func parseExpectations(r io.Reader) (*expectations, error) {
+	exp := &expectations{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true) // so that a misspelt field isn't silently an expectation of anything
+	if err := dec.Decode(exp); err != nil {
+		return nil, err
+	}
+
+	for i, e := range exp.Expectations {
+		if err := e.compile(); err != nil {
+			return nil, fmt.Errorf("expectation %d: %s", i+1, err.Error())
+		}
+
+		// without any counts, a message is expected to arrive at least once
+		if e.Count == nil {
+			one := int64(1)
+			e.Count = &countRange{Min: &one}
+			if e.Absent {
+				e.Count.Min = nil
+			}
+		}
+	}
+
+	return exp, nil
+}
+
+func (e *expectations) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, exp := range e.Expectations {
+		if exp.matches(dMsg) {
+			exp.received++
+		}
+	}
+
+	return nil
+}
+
+// report writes a line per expectation, returning whether they were all met
+func (e *expectations) report(w io.Writer) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lines := []string{}
+	failed := 0
+	for _, exp := range e.Expectations {
+		result, ok := exp.check()
+		status := "PASS"
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s %s: %s", status, exp.msgMatcher.String(), result))
+	}
+
+	fmt.Fprintf(w, "expectations: %d passed, %d failed\n", len(e.Expectations)-failed, failed)
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+
+	return failed == 0
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testExpectations = `
+expectations:
+  - name: api.requests
+    type: c
+    tags: ["env:dev", "endpoint:*"]
+  - name: api.latency
+    type: timer
+    value: {min: 0, max: 500}
+    count: {min: 2}
+  - name: debug.*
+    absent: true
+  - kind: event
+    name: Deploy*
+    count: {max: 1}
+  - kind: service_check
+    name: db
+    status: critical
+`
+
+func TestExpectations(t *testing.T) {
+	var tests = []struct {
+		name    string
+		msgs    []string
+		passed  bool
+		results []string
+	}{
+		{
+			"all met",
+			[]string{
+				"api.requests:1|c|#env:dev,endpoint:/api/users",
+				"api.latency:120|ms",
+				"api.latency:80|ms|#env:dev",
+				"_e{10,4}:Deployed 1|done",
+				"_sc|db|2",
+			},
+			true,
+			[]string{
+				"  PASS metric api.requests (type counter) [env:dev endpoint:*]: received 1",
+				"  PASS metric api.latency (type timer) value between 0 and 500: received 2",
+				"  PASS metric debug.*: received 0",
+				"  PASS event Deploy*: received 1",
+				"  PASS service_check db (status CRITICAL): received 1",
+			},
+		},
+		{
+			"none met",
+			[]string{
+				"api.requests:1|g|#env:dev,endpoint:users",
+				"api.requests:1|c|#env:prod,endpoint:users",
+				"api.latency:120|ms",
+				"api.latency:800|ms",
+				"debug.foo:1|c",
+				"_e{10,4}:Deployed 1|done",
+				"_e{10,4}:Deployed 2|done",
+				"_sc|db|0",
+			},
+			false,
+			[]string{
+				"  FAIL metric api.requests (type counter) [env:dev endpoint:*]: expected at least 1, received 0",
+				"  FAIL metric api.latency (type timer) value between 0 and 500: expected at least 2, received 1",
+				"  FAIL metric debug.*: expected none, received 1",
+				"  FAIL event Deploy*: expected at most 1, received 2",
+				"  FAIL service_check db (status CRITICAL): expected at least 1, received 0",
+			},
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := parseExpectations(strings.NewReader(testExpectations))
+			assert.NoError(err)
+
+			for _, msg := range tt.msgs {
+				assert.NoError(exp.handler([]byte(msg)))
+			}
+
+			var buf bytes.Buffer
+			assert.Equal(tt.passed, exp.report(&buf))
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Equal(tt.results, lines[1:])
+		})
+	}
+}
+
+func TestParseExpectationsErrors(t *testing.T) {
+	var tests = []struct {
+		yaml string
+		err  string
+	}{
+		{`{"expectations": [{"kind": "log"}]}`, "expectation 1: INVALID_KIND (log)"},
+		{`{"expectations": [{"name": "a", "type": "x"}]}`, "expectation 1: INVALID_TYPE (x)"},
+		{`{"expectations": [{"kind": "event", "type": "c"}]}`, "expectation 1: INVALID_TYPE (c)"},
+		{`{"expectations": [{"kind": "sc", "status": "bad"}]}`, "expectation 1: INVALID_STATUS (bad)"},
+		{`{"expectations": [{"name": "a["}]}`, "expectation 1: INVALID_PATTERN (a[)"},
+		{`{"expectations": [{"nmae": "a"}]}`, "yaml: unmarshal errors:\n  line 1: field nmae not found in type main.expectation"},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.yaml, func(t *testing.T) {
+			_, err := parseExpectations(strings.NewReader(tt.yaml))
+			assert.EqualError(err, tt.err)
+		})
+	}
+}
+
+func TestParseValueRange(t *testing.T) {
+	var tests = []struct {
+		str  string
+		desc string
+		in   []float64
+		out  []float64
+	}{
+		{">500", "above 500", []float64{500.5, 1000}, []float64{500, 0}},
+		{">=500", "at least 500", []float64{500, 1000}, []float64{499}},
+		{"<10", "below 10", []float64{-1, 9.9}, []float64{10}},
+		{"<=10", "at most 10", []float64{10}, []float64{10.1}},
+		{"==1", "1", []float64{1}, []float64{0, 2}},
+		{"42", "42", []float64{42}, []float64{41}},
+		{"0..100", "between 0 and 100", []float64{0, 100}, []float64{-1, 101}},
+		{"5..", "at least 5", []float64{5}, []float64{4}},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			r, err := parseValueRange(tt.str)
+			assert.NoError(err)
+			assert.Equal(tt.desc, r.String())
+
+			for _, value := range tt.in {
+				assert.True(r.contains(value), "%g", value)
+			}
+			for _, value := range tt.out {
+				assert.False(r.contains(value), "%g", value)
+			}
+		})
+	}
+
+	for _, str := range []string{"", ">", "..", "big", "1..x"} {
+		_, err := parseValueRange(str)
+		assert.EqualError(err, "INVALID_VALUE_PREDICATE ("+str+")")
+	}
+}
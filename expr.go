@@ -0,0 +1,853 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// the static type of an expression; every expression is type checked as it's compiled, so
+// mistakes like comparing a name to a number are reported at startup rather than never matching
+type exprType int
+
+const (
+	boolExprType exprType = iota
+	numberExprType
+	stringExprType
+)
+
+func (t exprType) String() string {
+	switch t {
+	case boolExprType:
+		return "bool"
+	case numberExprType:
+		return "number"
+	case stringExprType:
+		return "string"
+	}
+
+	return "unknown"
+}
+
+// exprError is a compile error, pointing at the column it was found at
+type exprError struct {
+	pos int
+	msg string
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.msg, e.pos+1)
+}
+
+func newExprError(pos int, format string, args ...interface{}) *exprError {
+	return &exprError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// describe an error along with the expression it was found in, underlining where it went wrong
+func describeExprError(src string, err error) string {
+	exprErr, ok := err.(*exprError)
+	if !ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s\n  %s\n  %s^", err.Error(), src, strings.Repeat(" ", exprErr.pos))
+}
+
+// the message an expression is evaluated against; a metric carrying several values is
+// evaluated once for each of them
+type exprEnv struct {
+	msg   dogstatsd.Msg
+	value int
+}
+
+func (env *exprEnv) tags() []string {
+	switch msg := env.msg.(type) {
+	case dogstatsd.Metric:
+		return msg.Tags
+	case dogstatsd.Event:
+		return msg.Tags
+	case dogstatsd.ServiceCheck:
+		return msg.Tags
+	}
+	return nil
+}
+
+// a compiled expression: exactly one of the functions is set, according to its type. Numbers
+// and strings may be missing (an event's value, or a tag a message doesn't have), in which case
+// they compare equal to nothing
+type exprNode struct {
+	typ exprType
+	pos int
+
+	boolFn func(*exprEnv) bool
+	numFn  func(*exprEnv) (float64, bool)
+	strFn  func(*exprEnv) (string, bool)
+
+	field   string  // the field this node reads, if that's all it does
+	literal *string // the string this node always returns, if it's a literal
+}
+
+// the fields of a message an expression may refer to
+var exprFields = map[string]*exprNode{
+	"kind": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		return env.msg.Type().String(), true
+	}},
+	"name": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		switch msg := env.msg.(type) {
+		case dogstatsd.Metric:
+			return msg.Name, true
+		case dogstatsd.Event:
+			return msg.Title, true
+		case dogstatsd.ServiceCheck:
+			return msg.Name, true
+		}
+		return "", false
+	}},
+	"type": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		metric, ok := env.msg.(dogstatsd.Metric)
+		return metric.MetricType.String(), ok
+	}},
+	"value": {typ: numberExprType, numFn: func(env *exprEnv) (float64, bool) {
+		metric, ok := env.msg.(dogstatsd.Metric)
+		if !ok || env.value >= len(metric.Values) {
+			return 0, false
+		}
+		return metric.Values[env.value].Numeric, true
+	}},
+	"sample_rate": {typ: numberExprType, numFn: func(env *exprEnv) (float64, bool) {
+		metric, ok := env.msg.(dogstatsd.Metric)
+		return metric.SampleRate, ok
+	}},
+	"container_id": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		metric, ok := env.msg.(dogstatsd.Metric)
+		return metric.ContainerId, ok && metric.ContainerId != ""
+	}},
+	"status": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		check, ok := env.msg.(dogstatsd.ServiceCheck)
+		return strings.ToLower(check.Status.String()), ok
+	}},
+	"text": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		switch msg := env.msg.(type) {
+		case dogstatsd.Event:
+			return msg.Text, true
+		case dogstatsd.ServiceCheck:
+			return msg.Message, msg.Message != ""
+		}
+		return "", false
+	}},
+	"hostname": {typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+		switch msg := env.msg.(type) {
+		case dogstatsd.Event:
+			return msg.Hostname, msg.Hostname != ""
+		case dogstatsd.ServiceCheck:
+			return msg.Hostname, msg.Hostname != ""
+		}
+		return "", false
+	}},
+}
+
+// fields whose values are names, so that string literals compared against them can be checked
+// and given in any of their forms, e.g. type == "ms" or type == "timer"
+var exprFieldValues = map[string]func(string) (string, bool){
+	"kind": func(str string) (string, bool) {
+		kind, ok := msgTypeNames[strings.ToLower(str)]
+		return kind.String(), ok && str != ""
+	},
+	"type": func(str string) (string, bool) {
+		metricType, ok := metricTypeNames[strings.ToLower(str)]
+		return metricType.String(), ok
+	},
+	"status": func(str string) (string, bool) {
+		status, ok := serviceCheckStatusNames[strings.ToLower(str)]
+		return strings.ToLower(status.String()), ok
+	},
+}
+
+// the functions an expression may call, with the types of their arguments
+var exprFuncs = map[string]struct {
+	args    []exprType
+	compile func(args []*exprNode) (*exprNode, error)
+}{
+	// the value of the first tag with this key ("" if it has none), or missing without one
+	"tag": {args: []exprType{stringExprType}, compile: func(args []*exprNode) (*exprNode, error) {
+		key := args[0]
+		return &exprNode{typ: stringExprType, strFn: func(env *exprEnv) (string, bool) {
+			k, ok := key.strFn(env)
+			if !ok {
+				return "", false
+			}
+			for _, tag := range env.tags() {
+				if tagKey, value, _ := strings.Cut(tag, ":"); tagKey == k {
+					return value, true
+				}
+			}
+			return "", false
+		}}, nil
+	}},
+	// whether any tag matches a key glob, or a key:value glob, like the -include-tag flag
+	"has_tag": {args: []exprType{stringExprType}, compile: func(args []*exprNode) (*exprNode, error) {
+		pattern := args[0]
+		if err := checkExprGlob(pattern); err != nil {
+			return nil, err
+		}
+		return &exprNode{typ: boolExprType, boolFn: func(env *exprEnv) bool {
+			p, ok := pattern.strFn(env)
+			if !ok {
+				return false
+			}
+			for _, tag := range env.tags() {
+				if tagPatternMatches(p, tag) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}},
+	// whether a string matches a glob
+	"glob": {args: []exprType{stringExprType, stringExprType}, compile: func(args []*exprNode) (*exprNode, error) {
+		str, pattern := args[0], args[1]
+		if err := checkExprGlob(pattern); err != nil {
+			return nil, err
+		}
+		return &exprNode{typ: boolExprType, boolFn: func(env *exprEnv) bool {
+			s, ok := str.strFn(env)
+			p, pOk := pattern.strFn(env)
+			if !ok || !pOk {
+				return false
+			}
+			return globMatch(p, s)
+		}}, nil
+	}},
+}
+
+func checkExprGlob(pattern *exprNode) error {
+	if pattern.literal != nil {
+		if err := checkGlob(*pattern.literal); err != nil {
+			return newExprError(pattern.pos, "INVALID_PATTERN (%s)", *pattern.literal)
+		}
+	}
+	return nil
+}
+
+// msgExpr is a compiled filter expression over parsed messages, such as
+//
+//	name =~ "^api\." && type == "timer" && tag("env") == "dev" && value > 500
+//
+// Comparisons (==, !=, <, <=, >, >=, and =~ and !~ against a regex) may be combined with &&,
+// || and !, or and, or and not, and grouped with parentheses
+type msgExpr struct {
+	src  string
+	root *exprNode
+}
+
+func compileMsgExpr(src string) (*msgExpr, error) {
+	if len(src) > exprMaxLength {
+		return nil, newExprError(exprMaxLength, "TOO_LONG (more than %d characters)", exprMaxLength)
+	}
+
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != eofExprToken {
+		return nil, newExprError(tok.pos, "UNEXPECTED_TOKEN (%s)", tok.text)
+	}
+	if root.typ != boolExprType {
+		return nil, newExprError(root.pos, "NOT_A_CONDITION (the expression is a %s, not a bool)", root.typ)
+	}
+
+	return &msgExpr{src: src, root: root}, nil
+}
+
+func (e *msgExpr) String() string {
+	return e.src
+}
+
+func (e *msgExpr) matches(dMsg dogstatsd.Msg) bool {
+	env := &exprEnv{msg: dMsg}
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok || len(metric.Values) <= 1 {
+		return e.root.boolFn(env)
+	}
+
+	for env.value = range metric.Values {
+		if e.root.boolFn(env) {
+			return true
+		}
+	}
+	return false
+}
+
+// msgHandler passes only the messages the expression matches on to fn; messages which can't be
+// parsed are passed on too, so they're still reported
+func (e *msgExpr) msgHandler(fn msgHandler) msgHandler {
+	return func(msg []byte) error {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil || e.matches(dMsg) {
+			return fn(msg)
+		}
+		return nil
+	}
+}
+
+type exprTokenKind int
+
+const (
+	eofExprToken exprTokenKind = iota
+	identExprToken
+	numberExprToken
+	stringExprToken
+	opExprToken
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string // the token as written, or a string's unquoted contents
+	pos  int
+	num  float64
+}
+
+// operators, longest first so that e.g. <= isn't read as <
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", ",", "-"}
+
+func isExprIdentByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	tokens := []exprToken{}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			// backslashes only escape quotes and themselves, leaving regexes like "^api\." alone
+			var str strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) && (src[j+1] == c || src[j+1] == '\\') {
+					j++
+				}
+				str.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, newExprError(i, "UNTERMINATED_STRING")
+			}
+			tokens = append(tokens, exprToken{kind: stringExprToken, text: str.String(), pos: i})
+			i = j + 1
+		case (c >= '0' && c <= '9') || c == '.':
+			j := i
+			for j < len(src) && (isExprIdentByte(src[j], false) || src[j] == '.' ||
+				((src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, newExprError(i, "INVALID_NUMBER (%s)", src[i:j])
+			}
+			tokens = append(tokens, exprToken{kind: numberExprToken, text: src[i:j], pos: i, num: num})
+			i = j
+		case isExprIdentByte(c, true):
+			j := i
+			for j < len(src) && isExprIdentByte(src[j], false) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: identExprToken, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range exprOps {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if doubled := string([]byte{c, c}); c == '=' || c == '&' || c == '|' {
+					return nil, newExprError(i, "UNEXPECTED_CHARACTER (%c), did you mean %s?", c, doubled)
+				}
+				return nil, newExprError(i, "UNEXPECTED_CHARACTER (%c)", c)
+			}
+			tokens = append(tokens, exprToken{kind: opExprToken, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, exprToken{kind: eofExprToken, text: "end of expression", pos: len(src)}), nil
+}
+
+// a recursive descent parser, compiling each node as it's parsed. From loosest to tightest:
+// ||, &&, !, comparisons, then fields, literals, function calls and parentheses
+type exprParser struct {
+	tokens []exprToken
+	i      int
+	depth  int // of parentheses, negations and calls, limited so deep nesting can't exhaust the stack
+}
+
+// expressions come from HTTP requests too, so are kept small
+const (
+	exprMaxDepth  = 64
+	exprMaxLength = 4096
+)
+
+// enter a nested expression starting at pos, which the caller must leave once parsed
+func (p *exprParser) enter(pos int) error {
+	if p.depth++; p.depth > exprMaxDepth {
+		return newExprError(pos, "TOO_DEEPLY_NESTED (more than %d levels)", exprMaxDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) leave() {
+	p.depth--
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.i]
+	if tok.kind != eofExprToken {
+		p.i++
+	}
+	return tok
+}
+
+// whether the next token is one of these operators (or keywords), consuming it if so
+func (p *exprParser) accept(ops ...string) (exprToken, bool) {
+	tok := p.peek()
+	if tok.kind != opExprToken && tok.kind != identExprToken {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return p.next(), true
+		}
+	}
+	return tok, false
+}
+
+func (p *exprParser) expect(op string) error {
+	if tok, ok := p.accept(op); !ok {
+		return newExprError(tok.pos, "EXPECTED (%s) but found (%s)", op, tok.text)
+	}
+	return nil
+}
+
+func requireExprType(node *exprNode, typ exprType, context string) error {
+	if node.typ != typ {
+		return newExprError(node.pos, "TYPE_MISMATCH (%s needs a %s, not a %s)", context, typ, node.typ)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.accept("||", "or")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := requireExprType(left, boolExprType, op.text); err != nil {
+			return nil, err
+		}
+		if err := requireExprType(right, boolExprType, op.text); err != nil {
+			return nil, err
+		}
+
+		l, r := left.boolFn, right.boolFn
+		left = &exprNode{typ: boolExprType, pos: left.pos, boolFn: func(env *exprEnv) bool {
+			return l(env) || r(env)
+		}}
+	}
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.accept("&&", "and")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := requireExprType(left, boolExprType, op.text); err != nil {
+			return nil, err
+		}
+		if err := requireExprType(right, boolExprType, op.text); err != nil {
+			return nil, err
+		}
+
+		l, r := left.boolFn, right.boolFn
+		left = &exprNode{typ: boolExprType, pos: left.pos, boolFn: func(env *exprEnv) bool {
+			return l(env) && r(env)
+		}}
+	}
+}
+
+func (p *exprParser) parseNot() (*exprNode, error) {
+	op, ok := p.accept("!", "not")
+	if !ok {
+		return p.parseComparison()
+	}
+
+	if err := p.enter(op.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if err := requireExprType(operand, boolExprType, op.text); err != nil {
+		return nil, err
+	}
+
+	fn := operand.boolFn
+	return &exprNode{typ: boolExprType, pos: op.pos, boolFn: func(env *exprEnv) bool {
+		return !fn(env)
+	}}, nil
+}
+
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "=~", "!~")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	if op.text == "=~" || op.text == "!~" {
+		return compileExprMatch(op, left, right)
+	}
+	return compileExprComparison(op, left, right)
+}
+
+// regexes are compiled up front, so must be string literals
+func compileExprMatch(op exprToken, left *exprNode, right *exprNode) (*exprNode, error) {
+	if err := requireExprType(left, stringExprType, op.text); err != nil {
+		return nil, err
+	}
+	if right.literal == nil {
+		return nil, newExprError(right.pos, "EXPECTED_REGEX (%s needs a quoted regex)", op.text)
+	}
+	re, err := regexp.Compile(*right.literal)
+	if err != nil {
+		return nil, newExprError(right.pos, "INVALID_REGEX (%s)", *right.literal)
+	}
+
+	fn, negate := left.strFn, op.text == "!~"
+	return &exprNode{typ: boolExprType, pos: left.pos, boolFn: func(env *exprEnv) bool {
+		// like !=, a missing value never matches, so !~ holds for it
+		str, ok := fn(env)
+		if !ok {
+			return negate
+		}
+		return re.MatchString(str) != negate
+	}}, nil
+}
+
+// resolve a string literal compared against a field of names to the name the field returns
+func resolveExprFieldValue(field *exprNode, literal *exprNode) (*exprNode, error) {
+	resolve, ok := exprFieldValues[field.field]
+	if !ok || literal.literal == nil {
+		return literal, nil
+	}
+
+	value, ok := resolve(*literal.literal)
+	if !ok {
+		return nil, newExprError(literal.pos, "INVALID_%s (%s)", strings.ToUpper(field.field), *literal.literal)
+	}
+	return newExprStringLiteral(value, literal.pos), nil
+}
+
+func compileExprComparison(op exprToken, left *exprNode, right *exprNode) (*exprNode, error) {
+	if left.typ != right.typ {
+		return nil, newExprError(op.pos, "TYPE_MISMATCH (cannot compare %s %s %s)", left.typ, op.text, right.typ)
+	}
+
+	var err error
+	if right, err = resolveExprFieldValue(left, right); err != nil {
+		return nil, err
+	}
+	if left, err = resolveExprFieldValue(right, left); err != nil {
+		return nil, err
+	}
+
+	// compare returns the sign of left - right; missing values are only ever unequal
+	var compare func(env *exprEnv) (int, bool)
+	switch left.typ {
+	case boolExprType:
+		if op.text != "==" && op.text != "!=" {
+			return nil, newExprError(op.pos, "TYPE_MISMATCH (cannot order bools with %s)", op.text)
+		}
+		l, r := left.boolFn, right.boolFn
+		compare = func(env *exprEnv) (int, bool) {
+			if l(env) == r(env) {
+				return 0, true
+			}
+			return 1, true
+		}
+	case numberExprType:
+		l, r := left.numFn, right.numFn
+		compare = func(env *exprEnv) (int, bool) {
+			a, aOk := l(env)
+			b, bOk := r(env)
+			switch {
+			case !aOk || !bOk:
+				return 0, false
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case stringExprType:
+		l, r := left.strFn, right.strFn
+		compare = func(env *exprEnv) (int, bool) {
+			a, aOk := l(env)
+			b, bOk := r(env)
+			if !aOk || !bOk {
+				return 0, false
+			}
+			return strings.Compare(a, b), true
+		}
+	}
+
+	var holds func(int) bool
+	switch op.text {
+	case "==":
+		holds = func(c int) bool { return c == 0 }
+	case "!=":
+		holds = func(c int) bool { return c != 0 }
+	case "<":
+		holds = func(c int) bool { return c < 0 }
+	case "<=":
+		holds = func(c int) bool { return c <= 0 }
+	case ">":
+		holds = func(c int) bool { return c > 0 }
+	case ">=":
+		holds = func(c int) bool { return c >= 0 }
+	}
+
+	notEqual := op.text == "!="
+	return &exprNode{typ: boolExprType, pos: left.pos, boolFn: func(env *exprEnv) bool {
+		c, ok := compare(env)
+		if !ok {
+			return notEqual
+		}
+		return holds(c)
+	}}, nil
+}
+
+func newExprStringLiteral(str string, pos int) *exprNode {
+	return &exprNode{typ: stringExprType, pos: pos, literal: &str, strFn: func(*exprEnv) (string, bool) {
+		return str, true
+	}}
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case numberExprToken:
+		num := tok.num
+		return &exprNode{typ: numberExprType, pos: tok.pos, numFn: func(*exprEnv) (float64, bool) {
+			return num, true
+		}}, nil
+	case stringExprToken:
+		return newExprStringLiteral(tok.text, tok.pos), nil
+	case opExprToken:
+		switch tok.text {
+		case "(":
+			if err := p.enter(tok.pos); err != nil {
+				return nil, err
+			}
+			defer p.leave()
+
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "-":
+			if num := p.peek(); num.kind == numberExprToken {
+				p.next()
+				value := -num.num
+				return &exprNode{typ: numberExprType, pos: tok.pos, numFn: func(*exprEnv) (float64, bool) {
+					return value, true
+				}}, nil
+			}
+		}
+	case identExprToken:
+		switch tok.text {
+		case "true", "false":
+			value := tok.text == "true"
+			return &exprNode{typ: boolExprType, pos: tok.pos, boolFn: func(*exprEnv) bool {
+				return value
+			}}, nil
+		}
+
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok)
+		}
+
+		field, ok := exprFields[tok.text]
+		if !ok {
+			return nil, newExprError(tok.pos, "UNKNOWN_FIELD (%s)%s", tok.text, exprSuggestion(tok.text, exprFieldNames()))
+		}
+		node := *field
+		node.pos, node.field = tok.pos, tok.text
+		return &node, nil
+	}
+
+	return nil, newExprError(tok.pos, "UNEXPECTED_TOKEN (%s)", tok.text)
+}
+
+// parse a function call's arguments, its name and opening parenthesis already consumed
+func (p *exprParser) parseCall(name exprToken) (*exprNode, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, newExprError(name.pos, "UNKNOWN_FUNCTION (%s)%s", name.text, exprSuggestion(name.text, exprFuncNames()))
+	}
+
+	if err := p.enter(name.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	args := []*exprNode{}
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) != len(fn.args) {
+		return nil, newExprError(name.pos, "WRONG_ARGUMENT_COUNT (%s takes %d, not %d)", name.text, len(fn.args), len(args))
+	}
+	for i, arg := range args {
+		if err := requireExprType(arg, fn.args[i], name.text); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := fn.compile(args)
+	if err != nil {
+		return nil, err
+	}
+	node.pos = name.pos
+	return node, nil
+}
+
+func exprFieldNames() []string {
+	names := make([]string, 0, len(exprFields))
+	for name := range exprFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func exprFuncNames() []string {
+	names := make([]string, 0, len(exprFuncs))
+	for name := range exprFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// suggest the closest of the names to a misspelled one, or list them all if none is close
+func exprSuggestion(name string, names []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range names {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	if best != "" {
+		return fmt.Sprintf(", did you mean %s?", best)
+	}
+	return fmt.Sprintf(", expected one of %s", strings.Join(names, ", "))
+}
+
+// the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgExpr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		msg     string
+		matches bool
+	}{
+		{expr: `name =~ "^api\." && type == "timer" && tag("env") == "dev" && value > 500`, msg: "api.latency:501|ms|#env:dev", matches: true},
+		{expr: `name =~ "^api\." && type == "timer" && tag("env") == "dev" && value > 500`, msg: "api.latency:500|ms|#env:dev"},
+		{expr: `name =~ "^api\." && type == "timer" && tag("env") == "dev" && value > 500`, msg: "api.latency:501|h|#env:dev"},
+		{expr: `name =~ "^api\." && type == "timer" && tag("env") == "dev" && value > 500`, msg: "apix:501|ms|#env:dev"},
+		{expr: `type == "ms"`, msg: "a:1|ms", matches: true},
+		{expr: `type != "c"`, msg: "_e{1,1}:a|b", matches: true},
+		{expr: `kind == "event" and not has_tag("env:prod")`, msg: "_e{1,1}:a|b|#env:dev", matches: true},
+		{expr: `kind == "event" and not has_tag("env:prod")`, msg: "_e{1,1}:a|b|#env:prod"},
+		{expr: `kind == "sc" && status == "critical"`, msg: "_sc|db.up|2", matches: true},
+		{expr: `status == "ok"`, msg: "_sc|db.up|2"},
+		{expr: `has_tag("debug")`, msg: "a:1|c|#debug:true", matches: true},
+		{expr: `tag("debug") == ""`, msg: "a:1|c|#debug", matches: true},
+		{expr: `tag("env") != "dev"`, msg: "a:1|c", matches: true},
+		{expr: `tag("env") == "dev"`, msg: "a:1|c"},
+		{expr: `tag("env") !~ "^dev"`, msg: "a:1|c", matches: true},
+		{expr: `tag("env") =~ "^dev"`, msg: "a:1|c"},
+		{expr: `text !~ "disk"`, msg: "a:1|c", matches: true},
+		{expr: `value > 5`, msg: "a:1:2:10|d", matches: true},
+		{expr: `value > 5 && value < 8`, msg: "a:1:10|d"},
+		{expr: `value >= -1`, msg: "a:-1|g", matches: true},
+		{expr: `value < 1e3`, msg: "_e{1,1}:a|b"},
+		{expr: `sample_rate < 1 || glob(name, "web.*")`, msg: "web.get:1|c", matches: true},
+		{expr: `sample_rate < 1 || glob(name, "web.*")`, msg: "db.get:1|c|@0.5", matches: true},
+		{expr: `(name == 'a' || name == 'b') && !(value == 1)`, msg: "b:2|g", matches: true},
+		{expr: `(name !~ "^a") == true`, msg: "b:2|g", matches: true},
+		{expr: `text =~ "disk"`, msg: "_sc|db.up|1|m:disk full", matches: true},
+		{expr: `hostname == "web-1"`, msg: "_e{1,1}:a|b|h:web-1", matches: true},
+		{expr: `container_id == "abc"`, msg: "a:1|c|c:abc", matches: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr+" "+test.msg, func(t *testing.T) {
+			expr, err := compileMsgExpr(test.expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			dMsg, err := dogstatsd.Parse([]byte(test.msg))
+			assert.NoError(t, err)
+			assert.Equal(t, test.matches, expr.matches(dMsg))
+		})
+	}
+}
+
+func TestCompileMsgExprErrors(t *testing.T) {
+	tests := map[string]string{
+		`nme == "a"`:              "UNKNOWN_FIELD (nme), did you mean name? at column 1",
+		`name == 1`:               "TYPE_MISMATCH (cannot compare string == number) at column 6",
+		`value > 5 &&`:            "UNEXPECTED_TOKEN (end of expression) at column 13",
+		`name = "a"`:              "UNEXPECTED_CHARACTER (=), did you mean ==? at column 6",
+		`name == "a`:              "UNTERMINATED_STRING at column 9",
+		`name`:                    "NOT_A_CONDITION (the expression is a string, not a bool) at column 1",
+		`type == "timers"`:        "INVALID_TYPE (timers) at column 9",
+		`name =~ "("`:             "INVALID_REGEX (() at column 9",
+		`name =~ tag("re")`:       "EXPECTED_REGEX (=~ needs a quoted regex) at column 9",
+		`tags("env") == "dev"`:    "UNKNOWN_FUNCTION (tags), did you mean tag? at column 1",
+		`tag("a", "b") == "c"`:    "WRONG_ARGUMENT_COUNT (tag takes 1, not 2) at column 1",
+		`has_tag(1)`:              "TYPE_MISMATCH (has_tag needs a string, not a number) at column 9",
+		`value > 5 && name`:       "TYPE_MISMATCH (&& needs a bool, not a string) at column 14",
+		`(value > 5`:              "EXPECTED ()) but found (end of expression) at column 11",
+		`value > 5ms`:             "INVALID_NUMBER (5ms) at column 9",
+		`true < false`:            "TYPE_MISMATCH (cannot order bools with <) at column 6",
+		`zzzzzzzz == 1`:           "UNKNOWN_FIELD (zzzzzzzz), expected one of container_id, hostname, kind, name, sample_rate, status, text, type, value at column 1",
+		`value > 1 value`:         "UNEXPECTED_TOKEN (value) at column 11",
+		`glob(name, "[") || true`: "INVALID_PATTERN ([) at column 12",
+	}
+
+	for src, expected := range tests {
+		_, err := compileMsgExpr(src)
+		assert.EqualError(t, err, expected, src)
+	}
+}
+
+func TestCompileMsgExprLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := compileMsgExpr(strings.Repeat("(", 64) + "true" + strings.Repeat(")", 64))
+	assert.NoError(err)
+	_, err = compileMsgExpr(strings.Repeat("!", 64) + "true")
+	assert.NoError(err)
+
+	_, err = compileMsgExpr(strings.Repeat("(", 65) + "true" + strings.Repeat(")", 65))
+	assert.EqualError(err, "TOO_DEEPLY_NESTED (more than 64 levels) at column 65")
+	_, err = compileMsgExpr(strings.Repeat("not ", 65) + "true")
+	assert.EqualError(err, "TOO_DEEPLY_NESTED (more than 64 levels) at column 257")
+	_, err = compileMsgExpr(strings.Repeat("has_tag(", 65))
+	assert.EqualError(err, "TOO_DEEPLY_NESTED (more than 64 levels) at column 513")
+
+	// far too deep to parse recursively, and far too long
+	_, err = compileMsgExpr(strings.Repeat("(", 1<<20))
+	assert.EqualError(err, "TOO_LONG (more than 4096 characters) at column 4097")
+	_, err = compileMsgExpr(strings.Repeat("(", 4000))
+	assert.EqualError(err, "TOO_DEEPLY_NESTED (more than 64 levels) at column 65")
+}
+
+func TestDescribeExprError(t *testing.T) {
+	_, err := compileMsgExpr(`name == 1`)
+	assert.Equal(t, "TYPE_MISMATCH (cannot compare string == number) at column 6\n  name == 1\n       ^", describeExprError(`name == 1`, err))
+}
+
+func TestMsgExprMsgHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	expr, err := compileMsgExpr(`value > 1`)
+	assert.NoError(err)
+
+	received := []string{}
+	handler := expr.msgHandler(func(msg []byte) error {
+		received = append(received, string(msg))
+		return nil
+	})
+	for _, msg := range []string{"a:1|c", "b:2|c", "not a datagram"} {
+		handler([]byte(msg))
+	}
+	assert.Equal([]string{"b:2|c", "not a datagram"}, received)
+}
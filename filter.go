@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"gopkg.in/yaml.v3"
+)
+
+// a YAML list of strings which may also be given as a single, comma-separated string
+type yamlStrings []string
+
+func (s *yamlStrings) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = splitList(value.Value)
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// split a comma-separated list, ignoring whitespace and empty entries
+func splitList(str string) []string {
+	list := []string{}
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// a name glob, or a regex if wrapped in slashes
+type namePattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func (p namePattern) matches(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	return globMatch(p.glob, name)
+}
+
+// an IP, CIDR block, or otherwise a glob over the address (for unix sockets)
+type sourcePattern struct {
+	ipNet *net.IPNet
+	glob  string
+}
+
+func (p sourcePattern) matches(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+
+	if p.ipNet != nil {
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.UDPAddr:
+			ip = a.IP
+		case *net.TCPAddr:
+			ip = a.IP
+		}
+		return ip != nil && p.ipNet.Contains(ip)
+	}
+
+	return globMatch(p.glob, addr.String())
+}
+
+// filterRule matches messages by kind, name, metric type and source, each of which matches if
+// any of its values do, and by tags, all of which must be present. Fields left empty match
+// anything. Since only metrics have types, a rule with types can't also list other kinds; they'd
+// never match
+type filterRule struct {
+	Kinds   yamlStrings `yaml:"kind"`
+	Names   yamlStrings `yaml:"name"`   // globs, or /regexes/
+	Tags    yamlStrings `yaml:"tag"`    // a key glob matches any value, key:value globs match whole tags
+	Types   yamlStrings `yaml:"type"`   // c, g, ms and so on; only metrics have types
+	Sources yamlStrings `yaml:"source"` // IPs, CIDR blocks or (for unix sockets) address globs
+
+	kinds   map[dogstatsd.MsgType]bool
+	names   []namePattern
+	types   map[dogstatsd.MetricType]bool
+	sources []sourcePattern
+}
+
+func (r *filterRule) empty() bool {
+	return len(r.Kinds)+len(r.Names)+len(r.Tags)+len(r.Types)+len(r.Sources) == 0
+}
+
+func (r *filterRule) compile() error {
+	// an empty rule would match everything
+	if r.empty() {
+		return errors.New("EMPTY_RULE")
+	}
+	r.names, r.sources = nil, nil
+
+	r.kinds = map[dogstatsd.MsgType]bool{}
+	for _, kind := range r.Kinds {
+		k, ok := msgTypeNames[strings.ToLower(kind)]
+		if !ok || kind == "" {
+			return fmt.Errorf("INVALID_KIND (%s)", kind)
+		}
+		r.kinds[k] = true
+	}
+
+	for _, name := range r.Names {
+		if len(name) > 1 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+			re, err := regexp.Compile(name[1 : len(name)-1])
+			if err != nil {
+				return fmt.Errorf("INVALID_REGEX (%s)", name)
+			}
+			r.names = append(r.names, namePattern{re: re})
+			continue
+		}
+
+		if err := checkGlob(name); err != nil {
+			return fmt.Errorf("INVALID_PATTERN (%s)", name)
+		}
+		r.names = append(r.names, namePattern{glob: name})
+	}
+
+	for _, tag := range r.Tags {
+		if err := checkGlob(tag); err != nil {
+			return fmt.Errorf("INVALID_PATTERN (%s)", tag)
+		}
+	}
+
+	r.types = map[dogstatsd.MetricType]bool{}
+	for _, metricType := range r.Types {
+		t, ok := metricTypeNames[strings.ToLower(metricType)]
+		if !ok {
+			return fmt.Errorf("INVALID_TYPE (%s)", metricType)
+		}
+		r.types[t] = true
+	}
+
+	if len(r.types) > 0 {
+		for _, kind := range r.Kinds {
+			if msgTypeNames[strings.ToLower(kind)] != dogstatsd.MetricMsgType {
+				return fmt.Errorf("TYPE_WITHOUT_METRICS (%s has no type)", kind)
+			}
+		}
+	}
+
+	for _, source := range r.Sources {
+		if _, ipNet, err := net.ParseCIDR(source); err == nil {
+			r.sources = append(r.sources, sourcePattern{ipNet: ipNet})
+		} else if ip := net.ParseIP(source); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			r.sources = append(r.sources, sourcePattern{ipNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+		} else if err := checkGlob(source); err == nil {
+			r.sources = append(r.sources, sourcePattern{glob: source})
+		} else {
+			return fmt.Errorf("INVALID_SOURCE (%s)", source)
+		}
+	}
+
+	return nil
+}
+
+// whether a tag matches a pattern: key globs match the tag's key, key:value globs the whole tag
+func tagPatternMatches(pattern string, tag string) bool {
+	if !strings.Contains(pattern, ":") {
+		tag, _, _ = strings.Cut(tag, ":")
+	}
+	return globMatch(pattern, tag)
+}
+
+func (r *filterRule) matches(dMsg dogstatsd.Msg, addr net.Addr) bool {
+	if len(r.kinds) > 0 && !r.kinds[dMsg.Type()] {
+		return false
+	}
+
+	var name string
+	var tags []string
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		name, tags = msg.Name, msg.Tags
+		if len(r.types) > 0 && !r.types[msg.MetricType] {
+			return false
+		}
+	case dogstatsd.Event:
+		name, tags = msg.Title, msg.Tags
+	case dogstatsd.ServiceCheck:
+		name, tags = msg.Name, msg.Tags
+	}
+
+	if len(r.types) > 0 && dMsg.Type() != dogstatsd.MetricMsgType {
+		return false
+	}
+
+	if len(r.names) > 0 {
+		matched := false
+		for _, p := range r.names {
+			matched = matched || p.matches(name)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, pattern := range r.Tags {
+		matched := false
+		for _, tag := range tags {
+			matched = matched || tagPatternMatches(pattern, tag)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.sources) > 0 {
+		matched := false
+		for _, p := range r.sources {
+			matched = matched || p.matches(addr)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// msgFilter drops messages before they reach any handler: with include rules, only messages
+// matching at least one are kept, and messages matching any exclude rule are always dropped.
+// Messages which can't be parsed are kept, so they're still reported
+type msgFilter struct {
+	Include []*filterRule `yaml:"include"`
+	Exclude []*filterRule `yaml:"exclude"`
+}
+
+func loadMsgFilter(filename string) (*msgFilter, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMsgFilter(f)
+}
+
+// parse YAML (or JSON) include and exclude rules
+func parseMsgFilter(r io.Reader) (*msgFilter, error) {
+	filter := &msgFilter{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true) // so that a misspelt field isn't silently ignored, broadening its rule
+	if err := dec.Decode(filter); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if err := filter.compile(); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (f *msgFilter) compile() error {
+	for i, rule := range f.Include {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("include rule %d: %s", i+1, err.Error())
+		}
+	}
+	for i, rule := range f.Exclude {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("exclude rule %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (f *msgFilter) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+func (f *msgFilter) allows(dMsg dogstatsd.Msg, addr net.Addr) bool {
+	for _, rule := range f.Exclude {
+		if rule.matches(dMsg, addr) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, rule := range f.Include {
+		if rule.matches(dMsg, addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the messages of a packet which the filter allows, or nil if there are none
+func (f *msgFilter) filter(packet []byte, addr net.Addr) []byte {
+	msgs := dogstatsd.SplitPacket(packet)
+	kept := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil || f.allows(dMsg, addr) {
+			kept = append(kept, msg)
+		}
+	}
+
+	switch len(kept) {
+	case 0:
+		return nil
+	case len(msgs):
+		return packet
+	}
+	return bytes.Join(kept, []byte("\n"))
+}
+
+// packetHandler passes only the messages the filter allows on to fn
+func (f *msgFilter) packetHandler(fn dogstatsd.PacketHandler) dogstatsd.PacketHandler {
+	return func(packet []byte, addr net.Addr) error {
+		if out := f.filter(packet, addr); out != nil {
+			return fn(out, addr)
+		}
+		return nil
+	}
+}
+
+// include and exclude flags, each exclude flag adding a rule of its own and the include flags
+// together adding one
+type filterFlags struct {
+	file                           string
+	includeKinds, excludeKinds     string
+	includeTypes, excludeTypes     string
+	includeNames, excludeNames     stringsFlag
+	includeTags, excludeTags       stringsFlag
+	includeSources, excludeSources stringsFlag
+}
+
+func (f *filterFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.file, "filter", "", "YAML or JSON file of include and exclude rules, applied before anything else")
+	flags.StringVar(&f.includeKinds, "include-kind", "", "only keep messages of these comma-separated kinds: metric|event|service_check")
+	flags.StringVar(&f.excludeKinds, "exclude-kind", "", "drop messages of these comma-separated kinds")
+	flags.StringVar(&f.includeTypes, "include-type", "", "only keep metrics of these comma-separated types, e.g. c,g,ms")
+	flags.StringVar(&f.excludeTypes, "exclude-type", "", "drop metrics of these comma-separated types")
+	flags.Var(&f.includeNames, "include-name", "only keep messages whose name (or event title) matches this glob or /regex/ (repeatable)")
+	flags.Var(&f.excludeNames, "exclude-name", "drop messages whose name (or event title) matches this glob or /regex/ (repeatable)")
+	flags.Var(&f.includeTags, "include-tag", "only keep messages with this tag key or key:value glob (repeatable; all must match)")
+	flags.Var(&f.excludeTags, "exclude-tag", "drop messages with this tag key or key:value glob (repeatable)")
+	flags.Var(&f.includeSources, "include-source", "only keep messages sent from this IP or CIDR block (repeatable)")
+	flags.Var(&f.excludeSources, "exclude-source", "drop messages sent from this IP or CIDR block (repeatable)")
+}
+
+// build a filter from the file and flags given, or nil if there are none
+func (f *filterFlags) msgFilter() (*msgFilter, error) {
+	filter := &msgFilter{}
+	if f.file != "" {
+		var err error
+		if filter, err = loadMsgFilter(f.file); err != nil {
+			return nil, err
+		}
+	}
+
+	include := &filterRule{
+		Kinds:   splitList(f.includeKinds),
+		Names:   yamlStrings(f.includeNames),
+		Tags:    yamlStrings(f.includeTags),
+		Types:   splitList(f.includeTypes),
+		Sources: yamlStrings(f.includeSources),
+	}
+	if !include.empty() {
+		filter.Include = append(filter.Include, include)
+	}
+
+	if kinds := splitList(f.excludeKinds); len(kinds) > 0 {
+		filter.Exclude = append(filter.Exclude, &filterRule{Kinds: kinds})
+	}
+	if types := splitList(f.excludeTypes); len(types) > 0 {
+		filter.Exclude = append(filter.Exclude, &filterRule{Types: types})
+	}
+	for _, name := range f.excludeNames {
+		filter.Exclude = append(filter.Exclude, &filterRule{Names: yamlStrings{name}})
+	}
+	for _, tag := range f.excludeTags {
+		filter.Exclude = append(filter.Exclude, &filterRule{Tags: yamlStrings{tag}})
+	}
+	for _, source := range f.excludeSources {
+		filter.Exclude = append(filter.Exclude, &filterRule{Sources: yamlStrings{source}})
+	}
+
+	if filter.empty() {
+		return nil, nil
+	}
+	if err := filter.compile(); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
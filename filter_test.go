@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFilter = `
+include:
+  - kind: metric
+    name: [api.*, /^web\.(get|post)$/]
+  - kind: event, service_check
+    tag: env:prod
+exclude:
+  - type: s
+  - tag: [debug]
+  - source: [10.1.0.0/16, "::1"]
+`
+
+func TestMsgFilter(t *testing.T) {
+	filter, err := parseMsgFilter(strings.NewReader(testFilter))
+	assert.NoError(t, err)
+
+	local := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	tests := []struct {
+		msg     string
+		addr    net.Addr
+		allowed bool
+	}{
+		{msg: "api.requests:1|c", addr: local, allowed: true},
+		{msg: "web.get:1|ms", addr: local, allowed: true},
+		{msg: "web.gets:1|ms", addr: local},
+		{msg: "db.queries:1|c", addr: local},
+		{msg: "api.users:1|s", addr: local},
+		{msg: "api.requests:1|c|#debug", addr: local},
+		{msg: "api.requests:1|c|#debug:true", addr: local},
+		{msg: "api.requests:1|c|#debugging", addr: local, allowed: true},
+		{msg: "api.requests:1|c", addr: &net.UDPAddr{IP: net.ParseIP("10.1.2.3")}},
+		{msg: "api.requests:1|c", addr: &net.UDPAddr{IP: net.ParseIP("10.2.2.3")}, allowed: true},
+		{msg: "api.requests:1|c", addr: &net.UDPAddr{IP: net.ParseIP("::1")}},
+		{msg: "api.requests:1|c", addr: nil, allowed: true},
+		{msg: "_e{5,4}:title|text|#env:prod", addr: local, allowed: true},
+		{msg: "_e{5,4}:title|text|#env:dev", addr: local},
+		{msg: "_sc|api.up|0|#env:prod,team:web", addr: local, allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			assert.Equal(t, test.allowed, filter.filter([]byte(test.msg), test.addr) != nil)
+		})
+	}
+}
+
+func TestMsgFilterPackets(t *testing.T) {
+	assert := assert.New(t)
+
+	filter := &msgFilter{Exclude: []*filterRule{{Names: yamlStrings{"noisy.*"}}}}
+	assert.NoError(filter.compile())
+
+	assert.Equal("a:1|c\nnot a datagram", string(filter.filter([]byte("a:1|c\nnoisy.metric:1|c\nnot a datagram"), nil)))
+	assert.Nil(filter.filter([]byte("noisy.metric:1|c\nnoisy.other:2|g"), nil))
+
+	received := []string{}
+	handler := filter.packetHandler(func(packet []byte, _ net.Addr) error {
+		received = append(received, string(packet))
+		return nil
+	})
+	handler([]byte("noisy.metric:1|c"), nil)
+	handler([]byte("a:1|c\nb:2|g"), nil)
+	assert.Equal([]string{"a:1|c\nb:2|g"}, received)
+}
+
+func TestFilterFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	flags := filterFlags{}
+	filter, err := flags.msgFilter()
+	assert.NoError(err)
+	assert.Nil(filter)
+
+	flags = filterFlags{
+		includeKinds:   "metric",
+		includeTypes:   "c,g",
+		includeTags:    stringsFlag{"env:dev", "team"},
+		excludeNames:   stringsFlag{"/debug/", "tmp.*"},
+		excludeSources: stringsFlag{"10.0.0.1"},
+	}
+	filter, err = flags.msgFilter()
+	assert.NoError(err)
+	assert.Len(filter.Include, 1)
+	assert.Len(filter.Exclude, 3)
+
+	assert.NotNil(filter.filter([]byte("a:1|c|#env:dev,team:web"), nil))
+	assert.Nil(filter.filter([]byte("a:1|ms|#env:dev,team:web"), nil))
+	assert.Nil(filter.filter([]byte("a:1|c|#env:dev"), nil))
+	assert.Nil(filter.filter([]byte("a.debug.b:1|c|#env:dev,team:web"), nil))
+	assert.Nil(filter.filter([]byte("tmp.b:1|c|#env:dev,team:web"), nil))
+	assert.Nil(filter.filter([]byte("a:1|c|#env:dev,team:web"), &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}))
+
+	// types only match metrics, so can't be combined with other kinds
+	flags = filterFlags{includeKinds: "metric,event", includeTypes: "c"}
+	_, err = flags.msgFilter()
+	assert.EqualError(err, "include rule 1: TYPE_WITHOUT_METRICS (event has no type)")
+
+	// but apply to metrics alone when no kind is given
+	flags = filterFlags{excludeTypes: "s"}
+	filter, err = flags.msgFilter()
+	assert.NoError(err)
+	assert.Nil(filter.filter([]byte("a:1|s"), nil))
+	assert.NotNil(filter.filter([]byte("a:1|c"), nil))
+	assert.NotNil(filter.filter([]byte("_e{5,4}:title|text"), nil))
+}
+
+func TestParseMsgFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		"include: [{kind: metrics}]":                  "include rule 1: INVALID_KIND (metrics)",
+		"include: [{type: counts}]":                   "include rule 1: INVALID_TYPE (counts)",
+		"include: [{kind: [metric, event], type: c}]": "include rule 1: TYPE_WITHOUT_METRICS (event has no type)",
+		"exclude: [{tag: a}, {name: '/(/'}]":          "exclude rule 2: INVALID_REGEX (/(/)",
+		"exclude: [{}]":                               "exclude rule 1: EMPTY_RULE",
+		"exclude: [{tag: '['}]":                       "exclude rule 1: INVALID_PATTERN ([)",
+		"exclude: [{source: '10.[0'}]":                "exclude rule 1: INVALID_SOURCE (10.[0)",
+		"exclude: [{nmae: a}]":                        "yaml: unmarshal errors:\n  line 1: field nmae not found in type main.filterRule",
+	}
+
+	for config, expected := range tests {
+		_, err := parseMsgFilter(strings.NewReader(config))
+		assert.EqualError(t, err, expected, config)
+	}
+}
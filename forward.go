@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// how many packets may wait to be forwarded to each target before further packets are dropped
+const forwardBufferSize = 10000
+
+type forwardTarget struct {
+	addr string
+	conn net.Conn
+	ch   chan []byte
+
+	forwarded int64
+	errors    int64
+	dropped   int64
+}
+
+func (t *forwardTarget) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for packet := range t.ch {
+		if _, err := t.conn.Write(packet); err != nil {
+			// only the first error is logged; the rest are counted
+			if atomic.AddInt64(&t.errors, 1) == 1 {
+				log.Printf("forward error (%s): %s", t.addr, err.Error())
+			}
+			continue
+		}
+
+		atomic.AddInt64(&t.forwarded, 1)
+	}
+}
+
+// forwarder relays received packets to upstream agents, optionally only the messages in each
+// packet which match a filter
+type forwarder struct {
+	targets []*forwardTarget
+	matcher *msgMatcher // nil forwards everything
+	wg      sync.WaitGroup
+}
+
+// create a forwarder for UDP (host:port) and unix datagram socket (unix:///path) targets
+func newForwarder(addrs []string, matcher *msgMatcher) (*forwarder, error) {
+	f := &forwarder{matcher: matcher}
+
+	for _, addr := range addrs {
+		conn, stream, err := dialTarget(addr)
+		if err != nil {
+			f.stop()
+			return nil, err
+		}
+		if stream {
+			conn.Close()
+			f.stop()
+			return nil, fmt.Errorf("INVALID_FORWARD_TARGET (%s: only UDP and unix datagram sockets are supported)", addr)
+		}
+
+		t := &forwardTarget{
+			addr: addr,
+			conn: conn,
+			ch:   make(chan []byte, forwardBufferSize),
+		}
+		f.targets = append(f.targets, t)
+
+		f.wg.Add(1)
+		go t.run(&f.wg)
+	}
+
+	return f, nil
+}
+
+// the part of a packet to forward: all of it without a filter, otherwise just the messages
+// which match it (or nil if none do)
+func (f *forwarder) filter(packet []byte) []byte {
+	if f.matcher == nil {
+		return packet
+	}
+
+	msgs := [][]byte{}
+	for _, msg := range dogstatsd.SplitPacket(packet) {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err == nil && f.matcher.matches(dMsg) {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	return bytes.Join(msgs, []byte("\n"))
+}
+
+// packetHandler forwards each packet before passing it on to fn
+func (f *forwarder) packetHandler(fn dogstatsd.PacketHandler) dogstatsd.PacketHandler {
+	return func(packet []byte, addr net.Addr) error {
+		if out := f.filter(packet); out != nil {
+			for _, t := range f.targets {
+				select {
+				case t.ch <- out:
+				default:
+					atomic.AddInt64(&t.dropped, 1)
+				}
+			}
+		}
+
+		return fn(packet, addr)
+	}
+}
+
+// wait for queued packets to be forwarded, then disconnect
+func (f *forwarder) stop() {
+	for _, t := range f.targets {
+		close(t.ch)
+	}
+	f.wg.Wait()
+
+	for _, t := range f.targets {
+		t.conn.Close()
+	}
+}
+
+// close stops the forwarder and logs what was sent to each target
+func (f *forwarder) close() {
+	f.stop()
+
+	for _, t := range f.targets {
+		log.Printf("forwarded %d packets to %s (%d errors, %d dropped)", t.forwarded, t.addr, t.errors, t.dropped)
+	}
+}
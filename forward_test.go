@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwarder(t *testing.T) {
+	upstreams := []*dogstatsdtest.Server{dogstatsdtest.NewServer(t), dogstatsdtest.NewServer(t)}
+
+	fwd, err := newForwarder([]string{upstreams[0].Addr(), "udp://" + upstreams[1].Addr()}, nil)
+	assert.NoError(t, err)
+
+	received := 0
+	handler := fwd.packetHandler(func(msg []byte, _ net.Addr) error {
+		received++
+		return nil
+	})
+
+	handler([]byte("page.views:1|c|#env:dev\npage.views:2|c|#env:prod"), nil)
+	handler([]byte("not a metric"), nil)
+	fwd.close()
+
+	assert.Equal(t, 2, received)
+	for _, upstream := range upstreams {
+		upstream.AssertCounterSum(t, "page.views", 3)
+		assert.Eventually(t, func() bool { return len(upstream.ParseErrors()) == 1 }, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, int64(2), fwd.targets[0].forwarded)
+}
+
+func TestForwarderFilter(t *testing.T) {
+	upstream := dogstatsdtest.NewServer(t)
+
+	matcher := &msgMatcher{Kind: "metric", Tags: []string{"env:prod"}}
+	assert.NoError(t, matcher.compile())
+
+	fwd, err := newForwarder([]string{upstream.Addr()}, matcher)
+	assert.NoError(t, err)
+
+	handler := fwd.packetHandler(func(msg []byte, _ net.Addr) error { return nil })
+	handler([]byte("page.views:1|c|#env:dev\npage.views:2|c|#env:prod\nfuel.level:1|g|#env:prod"), nil)
+	handler([]byte("page.views:4|c|#env:dev"), nil)
+	handler([]byte("_sc|db|0|#env:prod"), nil)
+	fwd.close()
+
+	upstream.AssertCounterSum(t, "page.views", 2)
+	upstream.WaitForMetric(t, "fuel.level")
+	assert.Equal(t, int64(1), fwd.targets[0].forwarded)
+	assert.Len(t, upstream.Messages(), 2)
+}
+
+func TestForwarderErrors(t *testing.T) {
+	// stream targets are refused even when they can be dialled
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcp.Close()
+
+	target := "tcp://" + tcp.Addr().String()
+	_, err = newForwarder([]string{target}, nil)
+	assert.EqualError(t, err, "INVALID_FORWARD_TARGET ("+target+": only UDP and unix datagram sockets are supported)")
+
+	// a unix socket which nothing reads from any more refuses writes
+	sock := filepath.Join(t.TempDir(), "dsd.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	assert.NoError(t, err)
+
+	fwd, err := newForwarder([]string{"unix://" + sock}, nil)
+	assert.NoError(t, err)
+	listener.Close()
+
+	handler := fwd.packetHandler(func(msg []byte, _ net.Addr) error { return nil })
+	handler([]byte("page.views:1|c"), nil)
+	handler([]byte("page.views:1|c"), nil)
+	fwd.close()
+
+	assert.Equal(t, int64(0), atomic.LoadInt64(&fwd.targets[0].forwarded))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&fwd.targets[0].errors))
+}
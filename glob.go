@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// globs use path.Match's syntax (*, ?, [a-z], [^a-z] and \ escapes), except that / is an
+// ordinary character, so that url:* matches url:/api/users. They're translated to regexes, which
+// are cached since the same few patterns are matched against every message
+var errBadGlob = errors.New("syntax error in pattern")
+
+const globCacheSize = 1024
+
+var (
+	globCacheMu sync.Mutex
+	globCache   = map[string]*regexp.Regexp{}
+)
+
+// write a character to a regex, escaped if it might mean something
+func writeGlobRune(b *strings.Builder, c rune) {
+	if c < utf8.RuneSelf && !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+		b.WriteByte('\\')
+	}
+	b.WriteRune(c)
+}
+
+// translate a glob to an anchored regex
+func translateGlob(pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i++; i == len(runes) {
+				return "", errBadGlob
+			}
+			writeGlobRune(&b, runes[i])
+		case '[':
+			b.WriteByte('[')
+			if i+1 < len(runes) && runes[i+1] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+
+			ranges := 0
+			for {
+				if i++; i == len(runes) {
+					return "", errBadGlob
+				}
+				if runes[i] == ']' && ranges > 0 {
+					break
+				}
+
+				// a character, or a range of them, either of which may be escaped
+				lo, ok := globClassRune(runes, &i)
+				if !ok {
+					return "", errBadGlob
+				}
+				writeGlobRune(&b, lo)
+				if i+1 < len(runes) && runes[i+1] == '-' {
+					i += 2
+					hi, ok := globClassRune(runes, &i)
+					if !ok || hi < lo {
+						return "", errBadGlob
+					}
+					b.WriteByte('-')
+					writeGlobRune(&b, hi)
+				}
+				ranges++
+			}
+			b.WriteByte(']')
+		default:
+			writeGlobRune(&b, c)
+		}
+	}
+
+	b.WriteByte('$')
+	return b.String(), nil
+}
+
+// the (possibly escaped) character at runes[*i] within a class
+func globClassRune(runes []rune, i *int) (rune, bool) {
+	if *i >= len(runes) {
+		return 0, false
+	}
+
+	switch runes[*i] {
+	case '\\':
+		if *i++; *i == len(runes) {
+			return 0, false
+		}
+	case '-', ']':
+		return 0, false
+	}
+	return runes[*i], true
+}
+
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	globCacheMu.Lock()
+	re, ok := globCache[pattern]
+	globCacheMu.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	expr, err := translateGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	re = regexp.MustCompile(expr)
+
+	// patterns from HTTP API queries are unbounded, so only so many are kept
+	globCacheMu.Lock()
+	if len(globCache) < globCacheSize {
+		globCache[pattern] = re
+	}
+	globCacheMu.Unlock()
+
+	return re, nil
+}
+
+// whether a pattern is a valid glob
+func checkGlob(pattern string) error {
+	_, err := compileGlob(pattern)
+	return err
+}
+
+// whether str matches a glob; invalid globs match nothing
+func globMatch(pattern string, str string) bool {
+	re, err := compileGlob(pattern)
+	return err == nil && re.MatchString(str)
+}
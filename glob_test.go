@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		matched bool
+	}{
+		{"url:*", "url:/api/users", true},
+		{"/api/*", "/api/users/42", true},
+		{"api.*", "api.requests", true},
+		{"api.*", "apixrequests", false},
+		{"api.?", "api.x", true},
+		{"api.?", "api.xy", false},
+		{"env:[dp]*", "env:prod", true},
+		{"env:[^dp]*", "env:prod", false},
+		{"v[0-9]", "v7", true},
+		{"v[0-9]", "va", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"(a|b)+$", "(a|b)+$", true},
+		{"", "", true},
+		{"*", "line\nbreak", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matched, globMatch(test.pattern, test.str), "%s %s", test.pattern, test.str)
+	}
+}
+
+func TestCheckGlob(t *testing.T) {
+	for _, pattern := range []string{"[", "a[", "[]", "[^]", "[z-a]", `a\`, "[a-]"} {
+		assert.Equal(t, errBadGlob, checkGlob(pattern), pattern)
+		assert.False(t, globMatch(pattern, pattern), pattern)
+	}
+	assert.NoError(t, checkGlob(`[\]]`))
+	assert.True(t, globMatch(`[\]]`, "]"))
+}
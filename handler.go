@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"strings"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
 )
 
 type dogstatsdJsonMetric struct {
@@ -18,36 +20,94 @@ type dogstatsdJsonMetric struct {
 	ContainerId string    `json:"container_id"`
 }
 
+type dogstatsdJsonEvent struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+
+	Timestamp      int64    `json:"timestamp"`
+	Hostname       string   `json:"hostname"`
+	AggregationKey string   `json:"aggregation_key"`
+	Priority       string   `json:"priority"`
+	SourceType     string   `json:"source_type"`
+	AlertType      string   `json:"alert_type"`
+	Tags           []string `json:"tags"`
+}
+
+type dogstatsdJsonServiceCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+
+	Timestamp int64    `json:"timestamp"`
+	Hostname  string   `json:"hostname"`
+	Tags      []string `json:"tags"`
+	Message   string   `json:"message"`
+}
+
+func newDogstatsdJsonMetric(metric dogstatsd.Metric) dogstatsdJsonMetric {
+	floatValues := make([]float64, 0)
+	for _, value := range metric.Values {
+		floatValues = append(floatValues, value.Numeric)
+	}
+
+	return dogstatsdJsonMetric{
+		Name:        metric.Name,
+		Type:        metric.MetricType.String(),
+		Values:      floatValues,
+		SampleRate:  metric.SampleRate,
+		Tags:        metric.Tags,
+		ContainerId: metric.ContainerId,
+	}
+}
+
+// convert any parsed message into its JSON representation
+func newDogstatsdJsonMsg(dMsg dogstatsd.Msg) interface{} {
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		return newDogstatsdJsonMetric(msg)
+	case dogstatsd.Event:
+		return dogstatsdJsonEvent{
+			Title:          msg.Title,
+			Text:           msg.Text,
+			Timestamp:      msg.Timestamp.Unix(),
+			Hostname:       msg.Hostname,
+			AggregationKey: msg.AggregationKey,
+			Priority:       msg.Priority.String(),
+			SourceType:     msg.SourceType,
+			AlertType:      msg.AlertType.String(),
+			Tags:           msg.Tags,
+		}
+	case dogstatsd.ServiceCheck:
+		return dogstatsdJsonServiceCheck{
+			Name:      msg.Name,
+			Status:    msg.Status.String(),
+			Timestamp: msg.Timestamp.Unix(),
+			Hostname:  msg.Hostname,
+			Tags:      msg.Tags,
+			Message:   msg.Message,
+		}
+	}
+
+	return nil
+}
+
 func newJsonDogstatsdMsgHandler() msgHandler {
 	return func(msg []byte) error {
-		dMsg, err := parseDogstatsdMsg(msg)
+		dMsg, err := dogstatsd.Parse(msg)
 		if err != nil {
 			log.Println(err.Error())
 		}
 
-		if dMsg.Type() != metricMsgType {
+		if dMsg.Type() != dogstatsd.MetricMsgType {
 			log.Println("Unable to serialize non metric messages to JSON yet")
 			return nil
 		}
 
-		metric, ok := dMsg.(dogstatsdMetric)
+		metric, ok := dMsg.(dogstatsd.Metric)
 		if !ok {
 			log.Fatalf("Programming error: invalid Type() = type matching")
 		}
 
-		floatValues := make([]float64, 0)
-		for _, value := range metric.values {
-			floatValues = append(floatValues, value.numeric)
-		}
-
-		jsonMsg := dogstatsdJsonMetric{
-			Name:        metric.name,
-			Type:        metric.metricType.String(),
-			Values:      floatValues,
-			SampleRate:  metric.sampleRate,
-			Tags:        metric.tags,
-			ContainerId: metric.containerId,
-		}
+		jsonMsg := newDogstatsdJsonMetric(metric)
 
 		enc := json.NewEncoder(os.Stdout)
 		if err := enc.Encode(&jsonMsg); err != nil {
@@ -61,22 +121,22 @@ func newJsonDogstatsdMsgHandler() msgHandler {
 
 func newHumanDogstatsdMsgHandler() msgHandler {
 	return func(msg []byte) error {
-		dMsg, err := parseDogstatsdMsg(msg)
+		dMsg, err := dogstatsd.Parse(msg)
 		if err != nil {
 			log.Println(err.Error())
 			return nil
 		}
 
-		metric, _ := dMsg.(dogstatsdMetric)
-		if dMsg.Type() != metricMsgType {
+		metric, _ := dMsg.(dogstatsd.Metric)
+		if dMsg.Type() != dogstatsd.MetricMsgType {
 			fmt.Println(string(dMsg.Data()))
 			return nil
 		}
 
 		values := make([]string, 0)
-		for _, value := range metric.values {
-			strValue := fmt.Sprintf("%.2f", value.numeric)
-			if metric.metricType == timerMetricType {
+		for _, value := range metric.Values {
+			strValue := fmt.Sprintf("%.2f", value.Numeric)
+			if metric.MetricType == dogstatsd.TimerMetricType {
 				strValue += "ms"
 			}
 
@@ -85,10 +145,10 @@ func newHumanDogstatsdMsgHandler() msgHandler {
 
 		str := fmt.Sprintf(
 			"metric:%s|%s|%s %s",
-			metric.metricType.String(),
-			metric.name,
+			metric.MetricType.String(),
+			metric.Name,
 			strings.Join(values, ","),
-			strings.Join(metric.tags, " "),
+			strings.Join(metric.Tags, " "),
 		)
 
 		fmt.Println(str)
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// how long GET /wait waits without a timeout parameter
+const httpApiDefaultWait = 30 * time.Second
+
+type storedMsg struct {
+	id         int64
+	receivedAt time.Time
+	msg        dogstatsd.Msg
+}
+
+type httpApiMsg struct {
+	Id         int64       `json:"id"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Kind       string      `json:"kind"`
+	Data       string      `json:"data"`
+	Message    interface{} `json:"message"`
+}
+
+type httpApiStats struct {
+	Start         time.Time `json:"start"`
+	Messages      int64     `json:"messages"`
+	ParseErrors   int64     `json:"parse_errors"`
+	Metrics       int64     `json:"metrics"`
+	Events        int64     `json:"events"`
+	ServiceChecks int64     `json:"service_checks"`
+	Stored        int       `json:"stored"`
+}
+
+// httpApi keeps the most recently received messages in memory and exposes them, along with
+// counts of everything received, over HTTP for test harnesses
+type httpApi struct {
+	capacity int
+
+	mu      sync.Mutex
+	msgs    []storedMsg
+	nextId  int64
+	stats   httpApiStats
+	updated chan struct{} // closed whenever a message arrives
+}
+
+func newHttpApi(capacity int) *httpApi {
+	return &httpApi{
+		capacity: capacity,
+		nextId:   1,
+		stats:    httpApiStats{Start: time.Now()},
+		updated:  make(chan struct{}),
+	}
+}
+
+func (a *httpApi) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stats.Messages++
+	if err != nil {
+		a.stats.ParseErrors++
+		return nil
+	}
+
+	switch dMsg.Type() {
+	case dogstatsd.MetricMsgType:
+		a.stats.Metrics++
+	case dogstatsd.EventMsgType:
+		a.stats.Events++
+	case dogstatsd.ServiceCheckMsgType:
+		a.stats.ServiceChecks++
+	}
+
+	a.msgs = append(a.msgs, storedMsg{id: a.nextId, receivedAt: time.Now(), msg: dMsg})
+	a.nextId++
+	if len(a.msgs) > a.capacity {
+		a.msgs = a.msgs[len(a.msgs)-a.capacity:]
+	}
+
+	close(a.updated)
+	a.updated = make(chan struct{})
+	return nil
+}
+
+func (a *httpApi) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", a.handleMessages)
+	mux.HandleFunc("/reset", a.handleReset)
+	mux.HandleFunc("/wait", a.handleWait)
+	mux.HandleFunc("/stats", a.handleStats)
+	return mux
+}
+
+// a filter over stored messages, built from query parameters
+type httpApiFilter struct {
+	matcher *msgMatcher
+	where   *msgExpr
+	since   time.Time
+}
+
+func (f *httpApiFilter) matches(stored storedMsg) bool {
+	return !stored.receivedAt.Before(f.since) && f.matcher.matches(stored.msg) &&
+		(f.where == nil || f.where.matches(stored.msg))
+}
+
+// parse kind, name, type, status, (repeated) tag, value, where and since query parameters; names
+// and tags are globs, value is a predicate like >500 or 0..10, where is a filter expression and
+// since is an RFC3339 time or unix timestamp
+func parseHttpApiFilter(r *http.Request) (*httpApiFilter, error) {
+	query := r.URL.Query()
+
+	matcher := &msgMatcher{
+		Kind:   query.Get("kind"),
+		Name:   query.Get("name"),
+		Type:   query.Get("type"),
+		Status: query.Get("status"),
+		Tags:   query["tag"],
+	}
+	if matcher.Kind == "" {
+		matcher.Kind = "any"
+	}
+
+	if value := query.Get("value"); value != "" {
+		var err error
+		if matcher.Value, err = parseValueRange(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := matcher.compile(); err != nil {
+		return nil, err
+	}
+
+	filter := &httpApiFilter{matcher: matcher}
+	if where := query.Get("where"); where != "" {
+		if len(where) > exprMaxLength {
+			return nil, fmt.Errorf("WHERE_TOO_LONG (%d characters, the maximum is %d)", len(where), exprMaxLength)
+		}
+
+		var err error
+		if filter.where, err = compileMsgExpr(where); err != nil {
+			return nil, err
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		if ts, err := time.Parse(time.RFC3339Nano, since); err == nil {
+			filter.since = ts
+		} else if unix, err := strconv.ParseFloat(since, 64); err == nil {
+			filter.since = time.Unix(0, int64(unix*float64(time.Second)))
+		} else {
+			return nil, fmt.Errorf("INVALID_SINCE (%s)", since)
+		}
+	}
+
+	return filter, nil
+}
+
+func newHttpApiMsg(stored storedMsg) httpApiMsg {
+	return httpApiMsg{
+		Id:         stored.id,
+		ReceivedAt: stored.receivedAt,
+		Kind:       stored.msg.Type().String(),
+		Data:       string(stored.msg.Data()),
+		Message:    newDogstatsdJsonMsg(stored.msg),
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *httpApi) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("METHOD_NOT_ALLOWED (%s)", r.Method))
+		return
+	}
+
+	filter, err := parseHttpApiFilter(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.mu.Lock()
+	msgs := []httpApiMsg{}
+	for _, stored := range a.msgs {
+		if filter.matches(stored) {
+			msgs = append(msgs, newHttpApiMsg(stored))
+		}
+	}
+	a.mu.Unlock()
+
+	writeJson(w, http.StatusOK, msgs)
+}
+
+func (a *httpApi) handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("METHOD_NOT_ALLOWED (%s)", r.Method))
+		return
+	}
+
+	a.mu.Lock()
+	a.msgs = nil
+	a.stats = httpApiStats{Start: time.Now()}
+	a.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// long-poll until a stored message matches the filter, responding with the first match or
+// 504 if the timeout passes first
+func (a *httpApi) handleWait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("METHOD_NOT_ALLOWED (%s)", r.Method))
+		return
+	}
+
+	filter, err := parseHttpApiFilter(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	wait := httpApiDefaultWait
+	if timeout := r.URL.Query().Get("timeout"); timeout != "" {
+		if wait, err = time.ParseDuration(timeout); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("INVALID_TIMEOUT (%s)", timeout))
+			return
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	checked := int64(0)
+	for {
+		a.mu.Lock()
+		for _, stored := range a.msgs {
+			if stored.id > checked && filter.matches(stored) {
+				a.mu.Unlock()
+				writeJson(w, http.StatusOK, newHttpApiMsg(stored))
+				return
+			}
+		}
+		if len(a.msgs) > 0 {
+			checked = a.msgs[len(a.msgs)-1].id
+		}
+		updated := a.updated
+		a.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			writeJsonError(w, http.StatusGatewayTimeout, fmt.Errorf("TIMEOUT (%s)", wait))
+			return
+		}
+	}
+}
+
+func (a *httpApi) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("METHOD_NOT_ALLOWED (%s)", r.Method))
+		return
+	}
+
+	a.mu.Lock()
+	stats := a.stats
+	stats.Stored = len(a.msgs)
+	a.mu.Unlock()
+
+	writeJson(w, http.StatusOK, stats)
+}
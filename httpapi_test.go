@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getJson(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	return resp.StatusCode
+}
+
+func TestHttpApiMessages(t *testing.T) {
+	api := newHttpApi(3)
+	srv := httptest.NewServer(api.mux())
+	defer srv.Close()
+
+	for _, msg := range []string{
+		"dropped.from.buffer:1|c",
+		"page.views:1|c|#env:dev,route:home",
+		"fuel.level:0.5|g|#env:prod",
+		"_sc|db|2|#env:dev",
+		"not a metric",
+	} {
+		api.handler([]byte(msg))
+	}
+
+	var tests = []struct {
+		query  string
+		status int
+		ids    []int64
+	}{
+		{"", http.StatusOK, []int64{2, 3, 4}},
+		{"?kind=metric", http.StatusOK, []int64{2, 3}},
+		{"?kind=service_check&status=critical", http.StatusOK, []int64{4}},
+		{"?name=page.*", http.StatusOK, []int64{2}},
+		{"?tag=env:dev", http.StatusOK, []int64{2, 4}},
+		{"?tag=env:*&type=g", http.StatusOK, []int64{3}},
+		{"?value=<1", http.StatusOK, []int64{3}},
+		{"?since=" + time.Now().Add(time.Minute).Format(time.RFC3339), http.StatusOK, []int64{}},
+		{"?since=0", http.StatusOK, []int64{2, 3, 4}},
+		{"?where=" + url.QueryEscape(`tag("env") == "dev" && kind != "sc"`), http.StatusOK, []int64{2}},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			msgs := []httpApiMsg{}
+			assert.Equal(tt.status, getJson(t, srv.URL+"/messages"+tt.query, &msgs))
+
+			ids := []int64{}
+			for _, msg := range msgs {
+				ids = append(ids, msg.Id)
+			}
+			assert.Equal(tt.ids, ids)
+		})
+	}
+
+	errResp := map[string]string{}
+	assert.Equal(http.StatusBadRequest, getJson(t, srv.URL+"/messages?kind=log", &errResp))
+	assert.Equal("INVALID_KIND (log)", errResp["error"])
+	assert.Equal(http.StatusBadRequest, getJson(t, srv.URL+"/messages?since=yesterday", &errResp))
+	assert.Equal("INVALID_SINCE (yesterday)", errResp["error"])
+	assert.Equal(http.StatusBadRequest, getJson(t, srv.URL+"/messages?where=value", &errResp))
+	assert.Equal("NOT_A_CONDITION (the expression is a number, not a bool) at column 1", errResp["error"])
+	assert.Equal(http.StatusBadRequest, getJson(t, srv.URL+"/messages?where="+strings.Repeat("(", 1<<20), &errResp))
+	assert.Equal("WHERE_TOO_LONG (1048576 characters, the maximum is 4096)", errResp["error"])
+	assert.Equal(http.StatusBadRequest, getJson(t, srv.URL+"/messages?where="+strings.Repeat("(", 1000), &errResp))
+	assert.Equal("TOO_DEEPLY_NESTED (more than 64 levels) at column 65", errResp["error"])
+
+	stats := httpApiStats{}
+	assert.Equal(http.StatusOK, getJson(t, srv.URL+"/stats", &stats))
+	assert.Equal(int64(5), stats.Messages)
+	assert.Equal(int64(1), stats.ParseErrors)
+	assert.Equal(int64(3), stats.Metrics)
+	assert.Equal(int64(1), stats.ServiceChecks)
+	assert.Equal(3, stats.Stored)
+
+	resp, err := http.Post(srv.URL+"/stats", "", nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(srv.URL+"/reset", "", nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	msgs := []httpApiMsg{}
+	getJson(t, srv.URL+"/messages", &msgs)
+	assert.Empty(msgs)
+}
+
+func TestHttpApiWait(t *testing.T) {
+	assert := assert.New(t)
+
+	api := newHttpApi(10)
+	srv := httptest.NewServer(api.mux())
+	defer srv.Close()
+
+	api.handler([]byte("page.views:1|c"))
+
+	// already received
+	msg := httpApiMsg{}
+	assert.Equal(http.StatusOK, getJson(t, srv.URL+"/wait?name=page.views&timeout=1s", &msg))
+	assert.Equal(int64(1), msg.Id)
+
+	// arrives while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		api.handler([]byte("page.views:1|c|#env:dev"))
+	}()
+	assert.Equal(http.StatusOK, getJson(t, srv.URL+"/wait?tag=env:dev&timeout=1s", &msg))
+	assert.Equal(int64(2), msg.Id)
+	assert.Equal("metric", msg.Kind)
+
+	errResp := map[string]string{}
+	assert.Equal(http.StatusGatewayTimeout, getJson(t, srv.URL+"/wait?name=other&timeout=50ms", &errResp))
+	assert.Equal("TIMEOUT (50ms)", errResp["error"])
+}
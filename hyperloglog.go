@@ -0,0 +1,73 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLog estimates the number of distinct strings added to it using 2^precision bytes
+// of memory, with a standard error of about 1.04/sqrt(2^precision)
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *hyperLogLog) add(value string) {
+	hash := hyperLogLogHash(value)
+
+	// the top bits pick a register, the rest count leading zeros
+	idx := hash >> (64 - h.precision)
+	rest := hash<<h.precision | 1<<(h.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// use linear counting while most registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+
+	return estimate
+}
+
+// fnv is fast but poorly distributed in its high bits, so finish it off with the
+// splitmix64 mixer
+func hyperLogLogHash(value string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	hash := f.Sum64()
+
+	hash ^= hash >> 30
+	hash *= 0xbf58476d1ce4e5b9
+	hash ^= hash >> 27
+	hash *= 0x94d049bb133111eb
+	hash ^= hash >> 31
+
+	return hash
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	var tests = []struct {
+		distinct  int
+		tolerance float64
+	}{
+		{0, 0},
+		{1, 0.01},
+		{10, 0.5},
+		{100, 5},
+		{1000, 60},
+		{10000, 600},
+		{100000, 6000},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.distinct), func(t *testing.T) {
+			hll := newHyperLogLog(cardinalityPrecision)
+
+			// add everything twice, duplicates must not be counted
+			for i := 0; i < 2*tt.distinct; i++ {
+				hll.add(fmt.Sprintf("value-%d", i%tt.distinct))
+			}
+
+			assert.InDelta(float64(tt.distinct), hll.estimate(), tt.tolerance)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// intakeClient posts gzipped payloads to a Datadog-compatible (or other HTTP) intake, retrying
+// with exponential backoff on network errors, 429s and 5xxs
+type intakeClient struct {
+	client  *http.Client
+	apiKey  string
+	retries int
+	backoff time.Duration // doubled on each retry
+}
+
+func newIntakeClient(apiKey string) *intakeClient {
+	return &intakeClient{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		apiKey:  apiKey,
+		retries: 3,
+		backoff: time.Second,
+	}
+}
+
+func (c *intakeClient) postJson(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return c.postBody(url, "application/json", body)
+}
+
+func (c *intakeClient) postBody(url string, contentType string, payload []byte) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(payload); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", "gzip")
+	return c.postEncoded(url, header, body.Bytes())
+}
+
+// post an already encoded body, with headers describing it
+func (c *intakeClient) postEncoded(url string, header http.Header, body []byte) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.post(url, header, body)
+		if err == nil || !retry || attempt >= c.retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// make a single attempt, returning whether a failure is worth retrying
+func (c *intakeClient) post(url string, header http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.apiKey != "" {
+		req.Header.Set("DD-API-KEY", c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("INTAKE_ERROR (%s: %s %s)", url, resp.Status, strings.TrimSpace(string(excerpt)))
+}
+
+// join a base URL and an endpoint path
+func intakeUrl(base string, path string) string {
+	return strings.TrimRight(base, "/") + path
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// limits from https://docs.datadoghq.com/metrics/custom_metrics/#naming-custom-metrics and
+// https://docs.datadoghq.com/getting_started/tagging/#define-tags
+const (
+	lintMaxNameLength = 200
+	lintMaxTagLength  = 200
+)
+
+var (
+	lintNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.]*$`)
+	lintTagPattern  = regexp.MustCompile(`^[A-Za-z0-9_\-:./]*$`)
+
+	lintReservedTagKeys = map[string]struct{}{
+		"host":    {},
+		"device":  {},
+		"source":  {},
+		"service": {},
+	}
+)
+
+func lintMetricName(name string) []string {
+	violations := []string{}
+
+	if name == "" {
+		return append(violations, "name is empty")
+	}
+
+	if c := name[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+		violations = append(violations, fmt.Sprintf("name %q must start with a letter", name))
+	}
+
+	if len(name) > lintMaxNameLength {
+		violations = append(violations, fmt.Sprintf("name is %d characters long, the maximum is %d", len(name), lintMaxNameLength))
+	}
+
+	if !lintNamePattern.MatchString(name) {
+		violations = append(violations, fmt.Sprintf("name %q may only contain ASCII alphanumerics, underscores and periods", name))
+	}
+
+	return violations
+}
+
+func lintTags(tags []string) []string {
+	violations := []string{}
+	seen := map[string]struct{}{}
+
+	for _, tag := range tags {
+		if tag == "" {
+			violations = append(violations, "empty tag")
+			continue
+		}
+
+		if c := tag[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			violations = append(violations, fmt.Sprintf("tag %q must start with a letter", tag))
+		}
+
+		if len(tag) > lintMaxTagLength {
+			violations = append(violations, fmt.Sprintf("tag %q is %d characters long, the maximum is %d", tag[:20]+"...", len(tag), lintMaxTagLength))
+		}
+
+		if !lintTagPattern.MatchString(tag) {
+			violations = append(violations, fmt.Sprintf("tag %q may only contain alphanumerics, underscores, minuses, colons, periods and slashes", tag))
+		}
+
+		if strings.HasSuffix(tag, ":") {
+			violations = append(violations, fmt.Sprintf("tag %q has an empty value", tag))
+		}
+
+		lower := strings.ToLower(tag)
+		if lower != tag {
+			violations = append(violations, fmt.Sprintf("tag %q will be converted to lowercase", tag))
+		}
+
+		key, _, _ := strings.Cut(lower, ":")
+		if _, ok := lintReservedTagKeys[key]; ok {
+			violations = append(violations, fmt.Sprintf("tag %q uses the reserved key %q", tag, key))
+		}
+
+		if _, ok := seen[lower]; ok {
+			violations = append(violations, fmt.Sprintf("tag %q is duplicated", tag))
+		}
+		seen[lower] = struct{}{}
+	}
+
+	return violations
+}
+
+// lint a parsed message against Datadog's naming and tagging rules, returning the name of the
+// message and any violations found
+func lintDogstatsdMsg(dMsg dogstatsd.Msg) (string, []string) {
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		return msg.Name, append(lintMetricName(msg.Name), lintTags(msg.Tags)...)
+	case dogstatsd.ServiceCheck:
+		return msg.Name, append(lintMetricName(msg.Name), lintTags(msg.Tags)...)
+	case dogstatsd.Event:
+		return msg.Title, lintTags(msg.Tags)
+	}
+
+	return "", nil
+}
+
+// linter reports every message which breaks Datadog's naming and tagging rules
+type linter struct {
+	mu         sync.Mutex
+	violations int
+}
+
+func newLinter() *linter {
+	return &linter{}
+}
+
+func (l *linter) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	name, violations := lintDogstatsdMsg(dMsg)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.violations += len(violations)
+	l.mu.Unlock()
+
+	log.Printf("LINT %s %s: %s (packet: %s)", dMsg.Type().String(), name, strings.Join(violations, "; "), string(msg))
+	return nil
+}
+
+func (l *linter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.violations
+}
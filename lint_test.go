@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintDogstatsdMsg(t *testing.T) {
+	var tests = []struct {
+		rawMsg     string
+		name       string
+		violations []string
+	}{
+		{
+			"page.views:1|c|#env:dev,region:us-east-1",
+			"page.views",
+			[]string{},
+		},
+		{
+			"1page.views:1|c",
+			"1page.views",
+			[]string{`name "1page.views" must start with a letter`},
+		},
+		{
+			"page-views:1|c",
+			"page-views",
+			[]string{`name "page-views" may only contain ASCII alphanumerics, underscores and periods`},
+		},
+		{
+			"a" + strings.Repeat("b", 200) + ":1|g",
+			"a" + strings.Repeat("b", 200),
+			[]string{"name is 201 characters long, the maximum is 200"},
+		},
+		{
+			"page.views:1|c|#Env:dev,host:web1,env:dev,env:dev,9lives,bad tag",
+			"page.views",
+			[]string{
+				`tag "Env:dev" will be converted to lowercase`,
+				`tag "host:web1" uses the reserved key "host"`,
+				`tag "env:dev" is duplicated`,
+				`tag "env:dev" is duplicated`,
+				`tag "9lives" must start with a letter`,
+				`tag "bad tag" may only contain alphanumerics, underscores, minuses, colons, periods and slashes`,
+			},
+		},
+		{
+			"_sc|db.check|0|#service:db",
+			"db.check",
+			[]string{`tag "service:db" uses the reserved key "service"`},
+		},
+		{
+			"_e{5,5}:Error|Error|#env:",
+			"Error",
+			[]string{`tag "env:" has an empty value`},
+		},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.rawMsg, func(t *testing.T) {
+			msg, err := dogstatsd.Parse([]byte(tt.rawMsg))
+			assert.NoError(err)
+
+			name, violations := lintDogstatsdMsg(msg)
+			assert.Equal(tt.name, name)
+			assert.Equal(tt.violations, violations)
+		})
+	}
+}
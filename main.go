@@ -1,17 +1,57 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
 )
 
 type msgHandler func([]byte) error
 
+// fan a message out to several handlers, returning the first error encountered
+func newMultiMsgHandler(fns ...msgHandler) msgHandler {
+	return func(msg []byte) error {
+		var firstErr error
+		for _, fn := range fns {
+			if err := fn(msg); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		return firstErr
+	}
+}
+
+// a flag which may be given more than once, collecting every value
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// the number of goroutines processing messages, and how many messages may wait for one
+// before further messages are dropped
+const (
+	handlerPoolSize   = 1000
+	handlerBufferSize = 10000
+)
+
 type asyncMsgHandler interface {
 	handler([]byte) error
 	stop()
@@ -45,7 +85,7 @@ func newAsyncMsgHandler(fn msgHandler, poolSize int, bufferSize int) asyncMsgHan
 }
 
 // submit to the pool
-func (a handler) handler(msg []byte) error {
+func (a *handler) handler(msg []byte) error {
 	select {
 	case a.msgCh <- msg:
 		return nil
@@ -60,45 +100,397 @@ func (a *handler) stop() {
 	a.wg.Wait()
 }
 
+func defaultHostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// subcommands, run as dogstatsd-local <command> [flags]; each returns an exit code
+var commands = map[string]func(args []string) int{
+	"bench":    runBench,
+	"map":      runMap,
+	"replay":   runReplay,
+	"send":     runSend,
+	"wait-for": runWaitFor,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	host := flag.String("host", "0.0.0.0", "bind address")
 	port := flag.Int("port", 8125, "listen port")
-	format := flag.String("format", "stdout", "output format: json|std|raw")
+	format := flag.String("format", "stdout", "output format: json|human|raw|tui")
+	where := flag.String("where", "", "only output messages matching this expression, e.g. 'name =~ \"^api\\.\" && tag(\"env\") == \"dev\" && value > 500'")
+	summaryDest := flag.String("summary", "", "on shutdown, write a session summary to this file (.json, .md or text), or - for stderr")
+	catalogEnabled := flag.Bool("catalog", false, "track every metric name seen and warn about conflicting types or sample rates")
+	catalogDest := flag.String("catalog-dump", "", "on shutdown, dump the metric catalog to this file (.json or text), or - for stderr; implies -catalog")
+	cardinalityThreshold := flag.Int("cardinality-threshold", 0, "warn when a tag key on a metric exceeds this many distinct values, and about tag values which look unbounded (0 disables)")
+	lintEnabled := flag.Bool("lint", false, "report messages which break Datadog's naming and tagging rules")
+	lintStrict := flag.Bool("lint-strict", false, "exit with a non-zero status on shutdown if any lint violations were found; implies -lint")
+	expectFile := flag.String("expect", "", "YAML or JSON file of messages expected to arrive; exits non-zero on shutdown if any expectation is not met")
+	expectTimeout := flag.Duration("expect-timeout", 0, "with -expect, shut down and report after this long (0 waits for SIGINT)")
+	recordFile := flag.String("record", "", "record every datagram received, with its receive time and source, to this capture file for the replay subcommand")
+	snapshotFile := flag.String("snapshot", "", "on shutdown, compare the normalized stream of received messages against this golden file, exiting non-zero with a diff if they differ")
+	snapshotUpdate := flag.Bool("update", false, "with -snapshot, rewrite the golden file instead of comparing against it")
+	httpAddr := flag.String("http", "", "serve the HTTP control API (/messages, /reset, /wait, /stats) on this address, e.g. 127.0.0.1:8126")
+	httpBuffer := flag.Int("http-buffer", 10000, "with -http, the number of most recent messages to keep")
+	mappingFile := flag.String("mapping", "", "YAML or JSON file of dogstatsd_mapper_profiles rewriting metric names (and extracting tags) before output or aggregation")
+	promEnabled := flag.Bool("prometheus", false, "with -http, also expose received metrics at /metrics in the Prometheus text and OpenMetrics formats")
+	promBuckets := flag.String("prometheus-buckets", "", "with -prometheus, expose timers, histograms and distributions as histograms with these comma-separated bucket upper bounds instead of summaries")
+	promExpiry := flag.Duration("prometheus-expiry", 5*time.Minute, "with -prometheus, stop exposing series not updated for this long (0 keeps them forever)")
+	var filters filterFlags
+	filters.register(flag.CommandLine)
+	var transforms transformFlags
+	transforms.register(flag.CommandLine)
+	var redaction redactFlags
+	redaction.register(flag.CommandLine)
+	var forwardAddrs, forwardTags stringsFlag
+	flag.Var(&forwardAddrs, "forward", "also relay every packet received to this upstream agent: host:port or unix:///path/to.sock (repeatable)")
+	forwardKind := flag.String("forward-kind", "", "with -forward, only relay messages of this kind: metric|event|service_check")
+	forwardName := flag.String("forward-name", "", "with -forward, only relay messages whose name (or event title) matches this glob")
+	flag.Var(&forwardTags, "forward-tag", "with -forward, only relay messages with a tag matching this glob (repeatable)")
+	seriesUrl := flag.String("series-url", "", "aggregate metrics and submit them on each flush to the /api/v2/series endpoint under this base URL, e.g. https://api.datadoghq.com")
+	otlpUrl := flag.String("otlp-url", "", "aggregate metrics and export them on each flush over OTLP/HTTP to the /v1/metrics endpoint under this base URL, e.g. http://localhost:4318")
+	otlpFormat := flag.String("otlp-format", "protobuf", "with -otlp-url, the OTLP encoding: protobuf|json")
+	otlpTemporality := flag.String("otlp-temporality", "delta", "with -otlp-url, whether sums and histograms reset each flush or keep adding up: delta|cumulative")
+	remoteWriteUrl := flag.String("remote-write-url", "", "aggregate metrics and push them on each flush with the Prometheus remote-write protocol to this URL, e.g. http://localhost:9090/api/v1/write")
+	remoteWriteBuckets := flag.String("remote-write-buckets", "0,5,10,25,50,75,100,250,500,750,1000,2500,5000,7500,10000", "with -remote-write-url, comma-separated histogram bucket upper bounds for timers, histograms and distributions")
+	apiKey := flag.String("api-key", os.Getenv("DD_API_KEY"), "API key sent with submissions to Datadog-compatible intakes (default $DD_API_KEY)")
+	eventsUrl := flag.String("events-url", "", "submit events and service checks on each flush to the /api/v1/events and /api/v1/check_run endpoints under this base URL")
+	flushInterval := flag.Duration("flush-interval", 10*time.Second, "how often aggregated metrics, events and service checks are flushed")
+	hostname := flag.String("hostname", defaultHostname(), "hostname to report submissions from")
 	flag.Parse()
 
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+
+	var whereExpr *msgExpr
+	if *where != "" {
+		var err error
+		if whereExpr, err = compileMsgExpr(*where); err != nil {
+			log.Fatalf("invalid -where: %s", describeExprError(*where, err))
+		}
+	}
+
 	var handler msgHandler
+	var ui *tui
 
-	if *format == "json" {
+	switch *format {
+	case "json":
 		handler = newJsonDogstatsdMsgHandler()
-	} else if *format == "human" {
+	case "human":
 		handler = newHumanDogstatsdMsgHandler()
-	} else {
+	case "tui", "top":
+		ui = newTui(os.Stdin, os.Stdout)
+		ui.where = whereExpr
+		handler = ui.handler
+		log.SetOutput(ui)
+		go func() {
+			quit := func() {
+				select {
+				case sigCh <- os.Interrupt:
+				default:
+				}
+			}
+			if err := ui.run(quit); err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatalf(err.Error())
+			}
+		}()
+	default:
 		handler = newRawDogstatsdMsgHandler()
 	}
 
-	asyncHandler := newAsyncMsgHandler(handler, 1000, 10000)
+	// the dashboard filters its rows itself, so that the expression can be changed from it
+	if whereExpr != nil && ui == nil {
+		handler = whereExpr.msgHandler(handler)
+	}
+
+	var sum *summary
+	if *summaryDest != "" {
+		sum = newSummary()
+		handler = newMultiMsgHandler(handler, sum.handler)
+	}
+
+	var cat *catalog
+	if *catalogEnabled || *catalogDest != "" {
+		cat = newCatalog()
+		handler = newMultiMsgHandler(handler, cat.handler)
+	}
+
+	if *cardinalityThreshold > 0 {
+		handler = newMultiMsgHandler(handler, newCardinalityDetector(*cardinalityThreshold).handler)
+	}
+
+	var lint *linter
+	if *lintEnabled || *lintStrict {
+		lint = newLinter()
+		handler = newMultiMsgHandler(handler, lint.handler)
+	}
+
+	var exp *expectations
+	if *expectFile != "" {
+		var err error
+		if exp, err = loadExpectations(*expectFile); err != nil {
+			log.Fatalf("unable to load expectations: %s", err.Error())
+		}
+		handler = newMultiMsgHandler(handler, exp.handler)
+
+		if *expectTimeout > 0 {
+			time.AfterFunc(*expectTimeout, func() {
+				select {
+				case sigCh <- os.Interrupt:
+				default:
+				}
+			})
+		}
+	}
+
+	var snap *snapshotter
+	if *snapshotFile != "" {
+		snap = newSnapshotter()
+		handler = newMultiMsgHandler(handler, snap.handler)
+	}
+
+	var sinks []seriesSink
+	if *seriesUrl != "" {
+		sinks = append(sinks, newSeriesApiSink(*seriesUrl, *hostname, newIntakeClient(*apiKey)))
+	}
+	if *otlpUrl != "" {
+		otlp, err := newOtlpSink(*otlpUrl, *otlpFormat, *otlpTemporality, *hostname, newIntakeClient(""))
+		if err != nil {
+			log.Fatalf("invalid OTLP options: %s", err.Error())
+		}
+		sinks = append(sinks, otlp)
+	}
+	if *remoteWriteUrl != "" {
+		buckets, err := parsePromBuckets(*remoteWriteBuckets)
+		if err != nil {
+			log.Fatalf("invalid -remote-write-buckets: %s", err.Error())
+		}
+		sinks = append(sinks, newRemoteWriteSink(*remoteWriteUrl, buckets, newIntakeClient("")))
+	}
+
+	var flush *flusher
+	if len(sinks) > 0 {
+		agg := newAggregator(*flushInterval)
+		handler = newMultiMsgHandler(handler, agg.handler)
+		flush = newFlusher(agg, sinks...)
+	}
+
+	var events *eventsApiSink
+	if *eventsUrl != "" {
+		events = newEventsApiSink(*eventsUrl, *hostname, newIntakeClient(*apiKey), *flushInterval)
+		handler = newMultiMsgHandler(handler, events.handler)
+	}
+
+	if *promEnabled && *httpAddr == "" {
+		log.Fatalf("-prometheus requires -http")
+	}
+	if *httpBuffer < 1 {
+		log.Fatalf("invalid -http-buffer: %d, at least 1 message must be kept", *httpBuffer)
+	}
+
+	var httpSrv *http.Server
+	if *httpAddr != "" {
+		api := newHttpApi(*httpBuffer)
+		handler = newMultiMsgHandler(handler, api.handler)
+
+		mux := api.mux()
+		if *promEnabled {
+			buckets, err := parsePromBuckets(*promBuckets)
+			if err != nil {
+				log.Fatalf("invalid -prometheus-buckets: %s", err.Error())
+			}
+			prom := newPromRegistry(buckets, *promExpiry)
+			handler = newMultiMsgHandler(handler, prom.handler)
+			mux.HandleFunc("/metrics", prom.handleMetrics)
+		}
+
+		httpSrv = &http.Server{Addr: *httpAddr, Handler: mux}
+		go func() {
+			log.Println("serving HTTP API at", *httpAddr)
+			if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf(err.Error())
+			}
+		}()
+	}
+
+	// transforms see messages after mapping, so they can rename the tags it extracts
+	transformer, err := transforms.msgTransformer()
+	if err != nil {
+		log.Fatalf("invalid transform: %s", err.Error())
+	}
+	if transformer != nil {
+		handler = transformer.msgHandler(handler)
+	}
+
+	if *mappingFile != "" {
+		mapper, err := loadMetricMapper(*mappingFile)
+		if err != nil {
+			log.Fatalf("unable to load mapping: %s", err.Error())
+		}
+		handler = mapper.msgHandler(handler)
+	}
+
+	asyncHandler := newAsyncMsgHandler(handler, handlerPoolSize, handlerBufferSize)
+	submit := asyncHandler.handler
+	if sum != nil {
+		submit = sum.dropHandler(submit)
+	}
 
 	var wg sync.WaitGroup
 
 	// create a new server and listen on a background goroutine
 	addr := fmt.Sprintf("%s:%d", *host, *port)
 	log.Println("listening over UDP at ", addr)
-	srv := newServer(addr, asyncHandler.handler)
+	var srvHandler dogstatsd.PacketHandler = func(packet []byte, _ net.Addr) error {
+		var firstErr error
+		for _, msg := range dogstatsd.SplitPacket(packet) {
+			if err := submit(msg); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		return firstErr
+	}
+
+	var fwd *forwarder
+	if len(forwardAddrs) > 0 {
+		// without any filter flags, packets are relayed untouched
+		var matcher *msgMatcher
+		if *forwardKind != "" || *forwardName != "" || len(forwardTags) > 0 {
+			matcher = &msgMatcher{Kind: *forwardKind, Name: *forwardName, Tags: forwardTags}
+			if matcher.Kind == "" {
+				matcher.Kind = "any"
+			}
+			if err := matcher.compile(); err != nil {
+				log.Fatalf("invalid forward filter: %s", err.Error())
+			}
+		}
+
+		var err error
+		if fwd, err = newForwarder(forwardAddrs, matcher); err != nil {
+			log.Fatalf("unable to forward: %s", err.Error())
+		}
+		srvHandler = fwd.packetHandler(srvHandler)
+	}
+
+	var rec *recorder
+	if *recordFile != "" {
+		var err error
+		if rec, err = newRecorder(*recordFile); err != nil {
+			log.Fatalf("unable to record: %s", err.Error())
+		}
+		srvHandler = rec.packetHandler(srvHandler)
+	}
+
+	// redaction comes before everything but filtering, so nothing is recorded or forwarded
+	// unredacted
+	red, err := redaction.redactor()
+	if err != nil {
+		log.Fatalf("invalid redaction rules: %s", err.Error())
+	}
+	if red != nil {
+		srvHandler = red.packetHandler(srvHandler)
+	}
+
+	// filtering comes before everything else, including recording
+	filter, err := filters.msgFilter()
+	if err != nil {
+		log.Fatalf("invalid filter: %s", err.Error())
+	}
+	if filter != nil {
+		srvHandler = filter.packetHandler(srvHandler)
+	}
+
+	srv := dogstatsd.NewServer(addr, srvHandler)
 	wg.Add(1)
-	go func(srv server) {
+	go func(srv dogstatsd.Server) {
 		defer wg.Done()
-		if err := srv.listen(); err != nil {
+		if err := srv.Listen(); err != nil {
 			log.Fatalf(err.Error())
 		}
 	}(srv)
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
 	<-sigCh
+	if ui != nil {
+		ui.stop()
+		log.SetOutput(os.Stderr)
+	}
 
-	if err := srv.stop(); err != nil {
+	if err := srv.Stop(); err != nil {
 		log.Println(err.Error())
 	}
+
+	if httpSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		httpSrv.Shutdown(ctx)
+		cancel()
+	}
 	wg.Wait()
 	asyncHandler.stop()
+
+	if fwd != nil {
+		fwd.close()
+	}
+
+	if flush != nil {
+		flush.stop()
+	}
+
+	if events != nil {
+		events.stop()
+	}
+
+	if rec != nil {
+		if err := rec.close(); err != nil {
+			log.Println("record error:", err.Error())
+		}
+	}
+
+	if sum != nil {
+		if err := sum.write(*summaryDest); err != nil {
+			log.Println("summary error:", err.Error())
+		}
+	}
+
+	if cat != nil && *catalogDest != "" {
+		if err := cat.dump(*catalogDest); err != nil {
+			log.Println("catalog error:", err.Error())
+		}
+	}
+
+	if red != nil {
+		if total, counts := red.summary(); total > 0 {
+			log.Printf("redacted %d values (%s)", total, counts)
+		}
+	}
+
+	exitCode := 0
+	if lint != nil && *lintStrict && lint.count() > 0 {
+		log.Printf("found %d lint violations", lint.count())
+		exitCode = 1
+	}
+
+	if exp != nil && !exp.report(os.Stderr) {
+		exitCode = 1
+	}
+
+	if snap != nil {
+		diff, err := snap.compare(*snapshotFile, *snapshotUpdate)
+		if err != nil {
+			log.Println("snapshot error:", err.Error())
+			exitCode = 1
+		} else if diff != "" {
+			fmt.Fprint(os.Stderr, diff)
+			exitCode = 1
+		}
+	}
+
+	os.Exit(exitCode)
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"gopkg.in/yaml.v3"
+)
+
+// wildcard patterns may only contain name characters and *, which matches one dot-separated segment
+var wildcardMappingPattern = regexp.MustCompile(`^[a-zA-Z0-9\-_*.]+$`)
+
+type metricMapping struct {
+	Match     string            `yaml:"match"`
+	MatchType string            `yaml:"match_type"` // wildcard (the default) or regex
+	Name      string            `yaml:"name"`
+	Tags      map[string]string `yaml:"tags"`
+
+	re      *regexp.Regexp
+	tagKeys []string // sorted, so mapped tags are added in a stable order
+}
+
+func (m *metricMapping) compile() error {
+	if m.Match == "" {
+		return fmt.Errorf("MISSING_MATCH")
+	}
+	if m.Name == "" {
+		return fmt.Errorf("MISSING_NAME")
+	}
+
+	var err error
+	switch m.MatchType {
+	case "", "wildcard":
+		if !wildcardMappingPattern.MatchString(m.Match) {
+			return fmt.Errorf("INVALID_WILDCARD_MATCH (%s)", m.Match)
+		}
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(m.Match), `\*`, `([^.]*)`)
+		m.re, err = regexp.Compile("^" + pattern + "$")
+	case "regex":
+		m.re, err = regexp.Compile("^(?:" + m.Match + ")$")
+	default:
+		return fmt.Errorf("INVALID_MATCH_TYPE (%s)", m.MatchType)
+	}
+	if err != nil {
+		return fmt.Errorf("INVALID_MATCH (%s)", m.Match)
+	}
+
+	for key := range m.Tags {
+		m.tagKeys = append(m.tagKeys, key)
+	}
+	sort.Strings(m.tagKeys)
+
+	return nil
+}
+
+type mapperProfile struct {
+	Name     string           `yaml:"name"`
+	Prefix   string           `yaml:"prefix"` // only names starting with this are checked against the mappings
+	Mappings []*metricMapping `yaml:"mappings"`
+}
+
+// metricMapper rewrites metric names, extracting dot-separated segments into tags, following
+// the datadog agent's dogstatsd_mapper_profiles: profiles are checked in order, and the first
+// mapping whose pattern matches the whole name wins
+type metricMapper struct {
+	Profiles []*mapperProfile `yaml:"dogstatsd_mapper_profiles"`
+}
+
+func loadMetricMapper(filename string) (*metricMapper, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMetricMapper(f)
+}
+
+// parse YAML (or JSON) mapper profiles, which may be embedded in a datadog.yaml
+func parseMetricMapper(r io.Reader) (*metricMapper, error) {
+	mapper := &metricMapper{}
+	if err := yaml.NewDecoder(r).Decode(mapper); err != nil {
+		return nil, err
+	}
+
+	for i, profile := range mapper.Profiles {
+		name := profile.Name
+		if name == "" {
+			name = fmt.Sprint(i + 1)
+		}
+
+		for j, mapping := range profile.Mappings {
+			if err := mapping.compile(); err != nil {
+				return nil, fmt.Errorf("profile %s mapping %d: %s", name, j+1, err.Error())
+			}
+		}
+	}
+
+	return mapper, nil
+}
+
+// mapName returns the mapped name and the tags to add, or ok = false if no mapping matches
+func (m *metricMapper) mapName(name string) (mapped string, tags []string, ok bool) {
+	for _, profile := range m.Profiles {
+		if !strings.HasPrefix(name, profile.Prefix) {
+			continue
+		}
+
+		for _, mapping := range profile.Mappings {
+			match := mapping.re.FindStringSubmatchIndex(name)
+			if match == nil {
+				continue
+			}
+
+			mapped = string(mapping.re.ExpandString(nil, mapping.Name, name, match))
+			for _, key := range mapping.tagKeys {
+				value := string(mapping.re.ExpandString(nil, mapping.Tags[key], name, match))
+				tags = append(tags, key+":"+value)
+			}
+			return mapped, tags, true
+		}
+	}
+
+	return name, nil, false
+}
+
+// mapMetric returns the metric renamed and with extracted tags added, if any mapping matches
+func (m *metricMapper) mapMetric(metric dogstatsd.Metric) (dogstatsd.Metric, bool) {
+	name, tags, ok := m.mapName(metric.Name)
+	if !ok {
+		return metric, false
+	}
+
+	metric.Name = name
+	metric.Tags = append(append([]string{}, metric.Tags...), tags...)
+	return metric, true
+}
+
+// msgHandler maps each metric before passing it on to fn. Messages which aren't mapped, or
+// whose mapping can't be encoded, are passed on unchanged
+func (m *metricMapper) msgHandler(fn msgHandler) msgHandler {
+	return func(msg []byte) error {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil {
+			return fn(msg)
+		}
+
+		metric, ok := dMsg.(dogstatsd.Metric)
+		if !ok {
+			return fn(msg)
+		}
+
+		if metric, ok = m.mapMetric(metric); ok {
+			if mapped, err := dogstatsd.Encode(metric); err == nil {
+				return fn(mapped)
+			}
+		}
+
+		return fn(msg)
+	}
+}
+
+// the part of a datagram (or bare metric name) to map, for the map subcommand
+func mapperInput(line string) (dogstatsd.Metric, error) {
+	if !strings.Contains(line, "|") {
+		return dogstatsd.Metric{Name: line}, nil
+	}
+
+	dMsg, err := dogstatsd.Parse([]byte(line))
+	if err != nil {
+		return dogstatsd.Metric{}, err
+	}
+
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok {
+		return dogstatsd.Metric{}, fmt.Errorf("NOT_A_METRIC (%s)", line)
+	}
+
+	return metric, nil
+}
+
+func runMap(args []string) int {
+	flags := flag.NewFlagSet("map", flag.ExitOnError)
+	mappingFile := flags.String("mapping", "", "YAML or JSON file of dogstatsd_mapper_profiles (required)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s map -mapping file [name or datagram ...]\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Shows how each metric name (or datagram) would be mapped, without listening\n")
+		fmt.Fprintf(flags.Output(), "(read from stdin, one per line, if there are none).\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *mappingFile == "" {
+		flags.Usage()
+		return 2
+	}
+
+	mapper, err := loadMetricMapper(*mappingFile)
+	if err != nil {
+		log.Printf("unable to load mapping: %s", err.Error())
+		return 1
+	}
+
+	lines := flags.Args()
+	if len(lines) == 0 {
+		if lines, err = readDatagrams(os.Stdin); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+	}
+
+	status := 0
+	for _, line := range lines {
+		metric, err := mapperInput(line)
+		if err != nil {
+			fmt.Printf("%s: %s\n", line, err.Error())
+			status = 1
+			continue
+		}
+
+		mapped, ok := mapper.mapMetric(metric)
+		switch {
+		case !ok:
+			fmt.Printf("%s (unmapped)\n", metric.Name)
+		case len(mapped.Tags) > 0:
+			fmt.Printf("%s -> %s #%s\n", metric.Name, mapped.Name, strings.Join(mapped.Tags, ","))
+		default:
+			fmt.Printf("%s -> %s\n", metric.Name, mapped.Name)
+		}
+	}
+
+	return status
+}
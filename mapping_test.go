@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMapping = `
+api_key: ignored
+dogstatsd_mapper_profiles:
+  - name: api
+    prefix: api.
+    mappings:
+      - match: api.*.*.*.latency
+        name: api.latency
+        tags:
+          endpoint: $1
+          method: $2
+          status: $3
+      - match: 'api\.(\w+)\.errors'
+        match_type: regex
+        name: api.errors
+        tags:
+          endpoint: ${1}
+  - name: jobs
+    prefix: jobs.
+    mappings:
+      - match: jobs.*.duration
+        name: jobs.${1}_duration
+`
+
+func TestMetricMapper(t *testing.T) {
+	mapper, err := parseMetricMapper(strings.NewReader(testMapping))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		mapped string
+		tags   []string
+		ok     bool
+	}{
+		{name: "api.users.get.200.latency", mapped: "api.latency", tags: []string{"endpoint:users", "method:get", "status:200"}, ok: true},
+		{name: "api.users.errors", mapped: "api.errors", tags: []string{"endpoint:users"}, ok: true},
+		{name: "jobs.email.duration", mapped: "jobs.email_duration", ok: true},
+		{name: "api.users.get.latency", mapped: "api.users.get.latency"},
+		{name: "api.users.get.200.latency.p99", mapped: "api.users.get.200.latency.p99"},
+		{name: "api.a.b.errors", mapped: "api.a.b.errors"},
+		{name: "other.jobs.email.duration", mapped: "other.jobs.email.duration"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mapped, tags, ok := mapper.mapName(test.name)
+			assert.Equal(test.mapped, mapped)
+			assert.Equal(test.tags, tags)
+			assert.Equal(test.ok, ok)
+		})
+	}
+}
+
+func TestParseMetricMapperErrors(t *testing.T) {
+	tests := map[string]string{
+		`{name: x}`:                 "profile p mapping 1: MISSING_MATCH",
+		`{match: x}`:                "profile p mapping 1: MISSING_NAME",
+		`{match: 'a.(b)', name: x}`: "profile p mapping 1: INVALID_WILDCARD_MATCH (a.(b))",
+		`{match: '(', match_type: regex, name: x}`: "profile p mapping 1: INVALID_MATCH (()",
+		`{match: x, match_type: glob, name: x}`:    "profile p mapping 1: INVALID_MATCH_TYPE (glob)",
+	}
+
+	for mapping, expected := range tests {
+		_, err := parseMetricMapper(strings.NewReader("dogstatsd_mapper_profiles: [{name: p, mappings: [" + mapping + "]}]"))
+		assert.EqualError(t, err, expected, mapping)
+	}
+}
+
+func TestMetricMapperMsgHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	mapper, err := parseMetricMapper(strings.NewReader(testMapping))
+	assert.NoError(err)
+
+	received := []string{}
+	handler := mapper.msgHandler(func(msg []byte) error {
+		received = append(received, string(msg))
+		return nil
+	})
+
+	handler([]byte("api.users.get.200.latency:12|ms|@0.5|#env:dev"))
+	handler([]byte("unmapped.metric:1|c"))
+	handler([]byte("_sc|api.users.errors|0"))
+	handler([]byte("not a datagram"))
+
+	assert.Equal([]string{
+		"api.latency:12|ms|@0.5|#env:dev,endpoint:users,method:get,status:200",
+		"unmapped.metric:1|c",
+		"_sc|api.users.errors|0",
+		"not a datagram",
+	}, received)
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+const otlpMetricsPath = "/v1/metrics"
+
+// explicit bucket bounds for timers and histograms, the OpenTelemetry SDK defaults
+var otlpHistogramBounds = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+// exponential histograms for distributions start at the finest scale, and are downscaled
+// until each range of positive and negative buckets fits in this many
+const (
+	otlpMaxExpScale   = 20
+	otlpMaxExpBuckets = 160
+)
+
+type otlpTemporality int
+
+const (
+	deltaOtlpTemporality      otlpTemporality = 1
+	cumulativeOtlpTemporality otlpTemporality = 2
+)
+
+// OTLP/JSON encodes 64-bit integers as strings
+type otlpUint64 uint64
+
+func (v otlpUint64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatUint(uint64(v), 10))
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano otlpUint64     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      otlpUint64     `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano otlpUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      otlpUint64     `json:"timeUnixNano"`
+	Count             otlpUint64     `json:"count"`
+	Sum               *float64       `json:"sum,omitempty"`
+	BucketCounts      []otlpUint64   `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+	Min               *float64       `json:"min,omitempty"`
+	Max               *float64       `json:"max,omitempty"`
+}
+
+type otlpExpBuckets struct {
+	Offset       int32        `json:"offset"`
+	BucketCounts []otlpUint64 `json:"bucketCounts"`
+}
+
+type otlpExpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano otlpUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      otlpUint64     `json:"timeUnixNano"`
+	Count             otlpUint64     `json:"count"`
+	Sum               *float64       `json:"sum,omitempty"`
+	Scale             int32          `json:"scale"`
+	ZeroCount         otlpUint64     `json:"zeroCount"`
+	Positive          otlpExpBuckets `json:"positive"`
+	Negative          otlpExpBuckets `json:"negative"`
+	Min               *float64       `json:"min,omitempty"`
+	Max               *float64       `json:"max,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality otlpTemporality       `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality otlpTemporality          `json:"aggregationTemporality"`
+}
+
+type otlpExpHistogram struct {
+	DataPoints             []otlpExpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality otlpTemporality             `json:"aggregationTemporality"`
+}
+
+type otlpMetric struct {
+	Name                 string            `json:"name"`
+	Gauge                *otlpGauge        `json:"gauge,omitempty"`
+	Sum                  *otlpSum          `json:"sum,omitempty"`
+	Histogram            *otlpHistogram    `json:"histogram,omitempty"`
+	ExponentialHistogram *otlpExpHistogram `json:"exponentialHistogram,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+// ExportMetricsServiceRequest
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+// the protobuf encoding of each message, by field number from opentelemetry/proto/metrics/v1
+
+func (kv otlpKeyValue) marshalProto(b *protoBuffer) {
+	b.string(1, kv.Key)
+	b.message(2, func(b *protoBuffer) { b.string(1, kv.Value.StringValue) })
+}
+
+func marshalOtlpAttributes(b *protoBuffer, field int, attrs []otlpKeyValue) {
+	for _, kv := range attrs {
+		b.message(field, kv.marshalProto)
+	}
+}
+
+func (p otlpNumberDataPoint) marshalProto(b *protoBuffer) {
+	b.fixed64(2, uint64(p.StartTimeUnixNano))
+	b.fixed64(3, uint64(p.TimeUnixNano))
+	// as_double is part of a oneof, so it's written even when zero
+	b.optionalDouble(4, &p.AsDouble)
+	marshalOtlpAttributes(b, 7, p.Attributes)
+}
+
+func otlpUint64s(vs []otlpUint64) []uint64 {
+	out := make([]uint64, len(vs))
+	for i, v := range vs {
+		out[i] = uint64(v)
+	}
+	return out
+}
+
+func (p otlpHistogramDataPoint) marshalProto(b *protoBuffer) {
+	b.fixed64(2, uint64(p.StartTimeUnixNano))
+	b.fixed64(3, uint64(p.TimeUnixNano))
+	b.fixed64(4, uint64(p.Count))
+	b.optionalDouble(5, p.Sum)
+	b.packedFixed64(6, otlpUint64s(p.BucketCounts))
+	b.packedDouble(7, p.ExplicitBounds)
+	marshalOtlpAttributes(b, 9, p.Attributes)
+	b.optionalDouble(11, p.Min)
+	b.optionalDouble(12, p.Max)
+}
+
+func (bs otlpExpBuckets) marshalProto(b *protoBuffer) {
+	b.svarint(1, int64(bs.Offset))
+	b.packedUvarint(2, otlpUint64s(bs.BucketCounts))
+}
+
+func (p otlpExpHistogramDataPoint) marshalProto(b *protoBuffer) {
+	marshalOtlpAttributes(b, 1, p.Attributes)
+	b.fixed64(2, uint64(p.StartTimeUnixNano))
+	b.fixed64(3, uint64(p.TimeUnixNano))
+	b.fixed64(4, uint64(p.Count))
+	b.optionalDouble(5, p.Sum)
+	b.svarint(6, int64(p.Scale))
+	b.fixed64(7, uint64(p.ZeroCount))
+	b.message(8, p.Positive.marshalProto)
+	b.message(9, p.Negative.marshalProto)
+	b.optionalDouble(12, p.Min)
+	b.optionalDouble(13, p.Max)
+}
+
+func (m otlpMetric) marshalProto(b *protoBuffer) {
+	b.string(1, m.Name)
+
+	switch {
+	case m.Gauge != nil:
+		b.message(5, func(b *protoBuffer) {
+			for _, p := range m.Gauge.DataPoints {
+				b.message(1, p.marshalProto)
+			}
+		})
+	case m.Sum != nil:
+		b.message(7, func(b *protoBuffer) {
+			for _, p := range m.Sum.DataPoints {
+				b.message(1, p.marshalProto)
+			}
+			b.varint(2, int64(m.Sum.AggregationTemporality))
+			b.bool(3, m.Sum.IsMonotonic)
+		})
+	case m.Histogram != nil:
+		b.message(9, func(b *protoBuffer) {
+			for _, p := range m.Histogram.DataPoints {
+				b.message(1, p.marshalProto)
+			}
+			b.varint(2, int64(m.Histogram.AggregationTemporality))
+		})
+	case m.ExponentialHistogram != nil:
+		b.message(10, func(b *protoBuffer) {
+			for _, p := range m.ExponentialHistogram.DataPoints {
+				b.message(1, p.marshalProto)
+			}
+			b.varint(2, int64(m.ExponentialHistogram.AggregationTemporality))
+		})
+	}
+}
+
+func (r otlpMetricsRequest) marshalProto() []byte {
+	b := &protoBuffer{}
+	for _, rm := range r.ResourceMetrics {
+		b.message(1, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) { marshalOtlpAttributes(b, 1, rm.Resource.Attributes) })
+			for _, sm := range rm.ScopeMetrics {
+				b.message(2, func(b *protoBuffer) {
+					b.message(1, func(b *protoBuffer) { b.string(1, sm.Scope.Name) })
+					for _, m := range sm.Metrics {
+						b.message(2, m.marshalProto)
+					}
+				})
+			}
+		})
+	}
+	return b.buf
+}
+
+// convert dogstatsd tags to attributes. Valueless tags have empty values, and repeated keys
+// have their values joined with commas, as attribute keys must be unique
+func otlpAttributes(tags []string) []otlpKeyValue {
+	values := map[string][]string{}
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		values[key] = append(values[key], value)
+	}
+
+	attrs := make([]otlpKeyValue, 0, len(values))
+	for key, vs := range values {
+		attrs = append(attrs, otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: strings.Join(vs, ",")}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+
+	return attrs
+}
+
+// the running totals of a histogram, weighted by sample rate
+type otlpSamples struct {
+	sum, min, max float64
+	count         float64
+}
+
+// the running state of an explicit bucket histogram: counts are weighted by sample rate, and
+// only rounded when exported
+type otlpHistogramState struct {
+	otlpSamples
+	buckets []float64
+}
+
+func newOtlpHistogramState() *otlpHistogramState {
+	return &otlpHistogramState{
+		otlpSamples: otlpSamples{min: math.Inf(1), max: math.Inf(-1)},
+		buckets:     make([]float64, len(otlpHistogramBounds)+1),
+	}
+}
+
+func (s *otlpSamples) add(value float64, weight float64) {
+	s.sum += value * weight
+	s.count += weight
+	s.min = math.Min(s.min, value)
+	s.max = math.Max(s.max, value)
+}
+
+func (h *otlpHistogramState) add(value float64, weight float64) {
+	h.otlpSamples.add(value, weight)
+	// bucket i counts values in (bounds[i-1], bounds[i]]
+	h.buckets[sort.SearchFloat64s(otlpHistogramBounds, value)] += weight
+}
+
+// round weighted counts, returning them along with their total
+func roundOtlpCounts(weighted []float64) ([]otlpUint64, otlpUint64) {
+	counts := make([]otlpUint64, len(weighted))
+	total := otlpUint64(0)
+	for i, w := range weighted {
+		counts[i] = otlpUint64(math.Round(w))
+		total += counts[i]
+	}
+	return counts, total
+}
+
+func (h *otlpHistogramState) dataPoint() otlpHistogramDataPoint {
+	counts, total := roundOtlpCounts(h.buckets)
+	sum, min, max := h.sum, h.min, h.max
+
+	return otlpHistogramDataPoint{
+		Count:          total,
+		Sum:            &sum,
+		BucketCounts:   counts,
+		ExplicitBounds: otlpHistogramBounds,
+		Min:            &min,
+		Max:            &max,
+	}
+}
+
+// the running state of a base-2 exponential histogram, whose bucket i covers
+// (2^(i/2^scale), 2^((i+1)/2^scale)]
+type otlpExpHistogramState struct {
+	otlpSamples
+	scale    int32
+	zero     float64
+	positive map[int32]float64
+	negative map[int32]float64
+}
+
+func newOtlpExpHistogramState() *otlpExpHistogramState {
+	return &otlpExpHistogramState{
+		otlpSamples: otlpSamples{min: math.Inf(1), max: math.Inf(-1)},
+		scale:       otlpMaxExpScale,
+		positive:    map[int32]float64{},
+		negative:    map[int32]float64{},
+	}
+}
+
+// the index of the bucket holding a positive value at a scale
+func otlpExpIndex(value float64, scale int32) int32 {
+	// exact powers of two sit at the upper bound of a bucket, which logarithms can get wrong
+	if frac, exp := math.Frexp(value); frac == 0.5 {
+		exp--
+		if scale >= 0 {
+			return int32(exp<<scale) - 1
+		}
+		return int32(-((-exp) >> -scale)) - 1
+	}
+
+	return int32(math.Ceil(math.Log2(value)*math.Ldexp(1, int(scale)))) - 1
+}
+
+// the number of buckets from the lowest to the highest index; at the finest scale, the indexes of
+// the smallest and largest floats are too far apart for the difference to fit an int32
+func otlpExpSpan(buckets map[int32]float64) int64 {
+	if len(buckets) == 0 {
+		return 0
+	}
+
+	lo, hi := int32(math.MaxInt32), int32(math.MinInt32)
+	for i := range buckets {
+		if i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+	}
+	return int64(hi) - int64(lo) + 1
+}
+
+func (h *otlpExpHistogramState) add(value float64, weight float64) {
+	h.otlpSamples.add(value, weight)
+
+	switch {
+	case value > 0:
+		h.positive[otlpExpIndex(value, h.scale)] += weight
+	case value < 0:
+		h.negative[otlpExpIndex(-value, h.scale)] += weight
+	default:
+		h.zero += weight
+	}
+
+	// halve the resolution, merging pairs of buckets, until everything fits
+	for otlpExpSpan(h.positive) > otlpMaxExpBuckets || otlpExpSpan(h.negative) > otlpMaxExpBuckets {
+		h.scale--
+		for _, buckets := range []*map[int32]float64{&h.positive, &h.negative} {
+			merged := map[int32]float64{}
+			for i, w := range *buckets {
+				merged[i>>1] += w
+			}
+			*buckets = merged
+		}
+	}
+}
+
+func otlpExpBucketsOf(buckets map[int32]float64) (otlpExpBuckets, otlpUint64) {
+	if len(buckets) == 0 {
+		return otlpExpBuckets{BucketCounts: []otlpUint64{}}, 0
+	}
+
+	lo := int32(math.MaxInt32)
+	for i := range buckets {
+		if i < lo {
+			lo = i
+		}
+	}
+
+	weighted := make([]float64, otlpExpSpan(buckets))
+	for i, w := range buckets {
+		weighted[i-lo] = w
+	}
+
+	counts, total := roundOtlpCounts(weighted)
+	return otlpExpBuckets{Offset: lo, BucketCounts: counts}, total
+}
+
+func (h *otlpExpHistogramState) dataPoint() otlpExpHistogramDataPoint {
+	positive, positiveTotal := otlpExpBucketsOf(h.positive)
+	negative, negativeTotal := otlpExpBucketsOf(h.negative)
+	zero := otlpUint64(math.Round(h.zero))
+	sum, min, max := h.sum, h.min, h.max
+
+	return otlpExpHistogramDataPoint{
+		Count:     positiveTotal + negativeTotal + zero,
+		Sum:       &sum,
+		Scale:     h.scale,
+		ZeroCount: zero,
+		Positive:  positive,
+		Negative:  negative,
+		Min:       &min,
+		Max:       &max,
+	}
+}
+
+// otlpSink exports aggregated series to an OpenTelemetry collector over OTLP/HTTP: counters as
+// monotonic sums, gauges and sets as gauges, timers and histograms as explicit bucket
+// histograms and distributions as exponential histograms. With cumulative temporality, sums and
+// histograms keep adding up from when the sink was created rather than resetting every flush
+type otlpSink struct {
+	url         string
+	json        bool // protobuf otherwise
+	hostname    string
+	temporality otlpTemporality
+	client      *intakeClient
+	start       time.Time
+
+	mu         sync.Mutex
+	sums       map[string]float64
+	histograms map[string]*otlpHistogramState
+	expHists   map[string]*otlpExpHistogramState
+	decreased  map[string]bool // counters which have gone down, and so aren't monotonic sums
+}
+
+func newOtlpSink(base string, format string, temporality string, hostname string, client *intakeClient) (*otlpSink, error) {
+	s := &otlpSink{
+		url:        intakeUrl(base, otlpMetricsPath),
+		hostname:   hostname,
+		client:     client,
+		start:      time.Now(),
+		sums:       map[string]float64{},
+		decreased:  map[string]bool{},
+		histograms: map[string]*otlpHistogramState{},
+		expHists:   map[string]*otlpExpHistogramState{},
+	}
+
+	switch format {
+	case "protobuf":
+	case "json":
+		s.json = true
+	default:
+		return nil, fmt.Errorf("INVALID_OTLP_FORMAT (%s)", format)
+	}
+
+	switch temporality {
+	case "delta":
+		s.temporality = deltaOtlpTemporality
+	case "cumulative":
+		s.temporality = cumulativeOtlpTemporality
+	default:
+		return nil, fmt.Errorf("INVALID_OTLP_TEMPORALITY (%s)", temporality)
+	}
+
+	return s, nil
+}
+
+func (s *otlpSink) String() string {
+	return s.url
+}
+
+// the data points of one metric name, in the order first seen
+type otlpMetricBuilder struct {
+	names   []string
+	metrics map[string]*otlpMetric
+}
+
+func (b *otlpMetricBuilder) get(name string, init func(*otlpMetric)) *otlpMetric {
+	m, ok := b.metrics[name]
+	if !ok {
+		m = &otlpMetric{Name: name}
+		init(m)
+		b.metrics[name] = m
+		b.names = append(b.names, name)
+	}
+	return m
+}
+
+func (s *otlpSink) request(series []aggregatedSeries) otlpMetricsRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &otlpMetricBuilder{metrics: map[string]*otlpMetric{}}
+
+	for _, ser := range series {
+		attrs := otlpAttributes(ser.tags)
+		key := ser.name + "|" + strings.Join(ser.tags, ",")
+		now := otlpUint64(ser.timestamp.UnixNano())
+		start := otlpUint64(ser.timestamp.Add(-ser.interval).UnixNano())
+		if s.temporality == cumulativeOtlpTemporality {
+			start = otlpUint64(s.start.UnixNano())
+		}
+
+		switch {
+		case ser.kind == histogramSeriesKind:
+			if ser.metricType == dogstatsd.DistributionMetricType {
+				state, ok := s.expHists[key]
+				if !ok || s.temporality == deltaOtlpTemporality {
+					state = newOtlpExpHistogramState()
+					s.expHists[key] = state
+				}
+				for i, sample := range ser.samples.values {
+					state.add(sample, ser.samples.weights[i])
+				}
+
+				p := state.dataPoint()
+				p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano = attrs, start, now
+				m := b.get(ser.name, func(m *otlpMetric) {
+					m.ExponentialHistogram = &otlpExpHistogram{AggregationTemporality: s.temporality}
+				})
+				m.ExponentialHistogram.DataPoints = append(m.ExponentialHistogram.DataPoints, p)
+			} else {
+				state, ok := s.histograms[key]
+				if !ok || s.temporality == deltaOtlpTemporality {
+					state = newOtlpHistogramState()
+					s.histograms[key] = state
+				}
+				for i, sample := range ser.samples.values {
+					state.add(sample, ser.samples.weights[i])
+				}
+
+				p := state.dataPoint()
+				p.Attributes, p.StartTimeUnixNano, p.TimeUnixNano = attrs, start, now
+				m := b.get(ser.name, func(m *otlpMetric) {
+					m.Histogram = &otlpHistogram{AggregationTemporality: s.temporality}
+				})
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+			}
+		case ser.metricType != dogstatsd.CounterMetricType && ser.metricType != dogstatsd.GaugeMetricType && ser.metricType != dogstatsd.SetMetricType:
+			// .count, .avg and the like, which the histograms already cover
+		case ser.kind == gaugeSeriesKind:
+			m := b.get(ser.name, func(m *otlpMetric) { m.Gauge = &otlpGauge{} })
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: now,
+				AsDouble:     ser.value,
+			})
+		default:
+			// counters are aggregated as rates, so scale them back up to a count for the interval
+			value := ser.value
+			if ser.kind == rateSeriesKind {
+				value *= ser.interval.Seconds()
+			}
+			if value < 0 {
+				s.decreased[ser.name] = true
+			}
+			if s.temporality == cumulativeOtlpTemporality {
+				s.sums[key] += value
+				value = s.sums[key]
+			}
+
+			m := b.get(ser.name, func(m *otlpMetric) {
+				m.Sum = &otlpSum{AggregationTemporality: s.temporality}
+			})
+			// once any point of a counter has gone down, it's sent as a non-monotonic sum from
+			// then on, so that its type doesn't flip between exports
+			m.Sum.IsMonotonic = !s.decreased[ser.name]
+			m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				AsDouble:          value,
+			})
+		}
+	}
+
+	metrics := make([]otlpMetric, 0, len(b.names))
+	for _, name := range b.names {
+		metrics = append(metrics, *b.metrics[name])
+	}
+
+	resource := otlpResource{Attributes: []otlpKeyValue{
+		{Key: "service.name", Value: otlpAnyValue{StringValue: "dogstatsd-local"}},
+	}}
+	if s.hostname != "" {
+		resource.Attributes = append(resource.Attributes, otlpKeyValue{Key: "host.name", Value: otlpAnyValue{StringValue: s.hostname}})
+	}
+
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     resource,
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "dogstatsd-local"}, Metrics: metrics}},
+	}}}
+}
+
+func (s *otlpSink) submit(series []aggregatedSeries) error {
+	req := s.request(series)
+	if len(req.ResourceMetrics[0].ScopeMetrics[0].Metrics) == 0 {
+		return nil
+	}
+
+	if s.json {
+		return s.client.postJson(s.url, req)
+	}
+	return s.client.postBody(s.url, "application/x-protobuf", req.marshalProto())
+}
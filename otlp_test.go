@@ -0,0 +1,234 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOtlpExpIndex(t *testing.T) {
+	tests := []struct {
+		value float64
+		scale int32
+		index int32
+	}{
+		{value: 1, scale: 0, index: -1},
+		{value: 1.5, scale: 0, index: 0},
+		{value: 2, scale: 0, index: 0},
+		{value: 3, scale: 0, index: 1},
+		{value: 0.25, scale: 0, index: -3},
+		{value: 2, scale: 1, index: 1},
+		{value: 1.5, scale: 1, index: 1},
+		{value: 1.4, scale: 1, index: 0},
+		{value: 4, scale: -1, index: 0},
+		{value: 5, scale: -1, index: 1},
+		{value: 0.5, scale: -1, index: -1},
+		{value: 1024, scale: 20, index: 10<<20 - 1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.index, otlpExpIndex(test.value, test.scale), "%v at scale %d", test.value, test.scale)
+	}
+}
+
+func TestOtlpExpHistogramState(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newOtlpExpHistogramState()
+	for v := 1.0; v <= 1e6; v *= 1.1 {
+		h.add(v, 1)
+		h.add(-v, 2)
+	}
+	h.add(0, 1)
+
+	p := h.dataPoint()
+	assert.LessOrEqual(len(p.Positive.BucketCounts), otlpMaxExpBuckets)
+	assert.LessOrEqual(len(p.Negative.BucketCounts), otlpMaxExpBuckets)
+	assert.Equal(otlpUint64(1), p.ZeroCount)
+	assert.Equal(otlpUint64(145*3+1), p.Count)
+	assert.InDelta(-1e6, *p.Min, 1e5)
+
+	// every value falls in its bucket
+	base := math.Pow(2, math.Pow(2, -float64(p.Scale)))
+	for v := 1.0; v <= 1e6; v *= 1.1 {
+		i := otlpExpIndex(v, p.Scale) - p.Positive.Offset
+		assert.True(i >= 0 && int(i) < len(p.Positive.BucketCounts))
+		assert.True(math.Pow(base, float64(i+p.Positive.Offset)) < v*(1+1e-9) && v <= math.Pow(base, float64(i+p.Positive.Offset+1))*(1+1e-9))
+	}
+}
+
+func TestOtlpExpHistogramStateExtremes(t *testing.T) {
+	assert := assert.New(t)
+
+	// the smallest and largest floats are downscaled into range rather than overflowing
+	h := newOtlpExpHistogramState()
+	for _, v := range []float64{5e-324, 1e308, -5e-324, -math.MaxFloat64, 1} {
+		h.add(v, 1)
+	}
+
+	p := h.dataPoint()
+	assert.LessOrEqual(len(p.Positive.BucketCounts), otlpMaxExpBuckets)
+	assert.LessOrEqual(len(p.Negative.BucketCounts), otlpMaxExpBuckets)
+	assert.Equal(otlpUint64(5), p.Count)
+	for _, v := range []float64{5e-324, 1e308, 1} {
+		i := otlpExpIndex(v, p.Scale) - p.Positive.Offset
+		assert.True(i >= 0 && int(i) < len(p.Positive.BucketCounts), "%v", v)
+	}
+}
+
+func testOtlpSeries() []aggregatedSeries {
+	agg := newAggregator(10 * time.Second)
+	for _, msg := range []string{
+		"page.views:10|c|#env:dev,env:prod,urgent",
+		"page.views:5|c|@0.5",
+		"fuel.level:0.5|g",
+		"api.latency:1:20:300|ms",
+		"api.latency:40|ms|@0.5",
+		"payload.size:0:2:-4|d",
+	} {
+		agg.handler([]byte(msg))
+	}
+	return agg.flush(time.Unix(100, 0))
+}
+
+func TestOtlpSinkJson(t *testing.T) {
+	intake := newTestIntake(t)
+	sink, err := newOtlpSink(intake.URL, "json", "delta", "host1", newTestIntakeClient())
+	assert.NoError(t, err)
+	assert.NoError(t, sink.submit(testOtlpSeries()))
+
+	assert.Len(t, intake.requests, 1)
+	assert.Equal(t, otlpMetricsPath, intake.requests[0].URL.Path)
+	assert.JSONEq(t, `{"resourceMetrics": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "dogstatsd-local"}},
+			{"key": "host.name", "value": {"stringValue": "host1"}}
+		]},
+		"scopeMetrics": [{"scope": {"name": "dogstatsd-local"}, "metrics": [
+			{"name": "api.latency", "histogram": {"aggregationTemporality": 1, "dataPoints": [{
+				"startTimeUnixNano": "90000000000", "timeUnixNano": "100000000000",
+				"count": "5", "sum": 401, "min": 1, "max": 300,
+				"bucketCounts": ["0", "1", "0", "1", "2", "0", "0", "0", "1", "0", "0", "0", "0", "0", "0", "0"],
+				"explicitBounds": [0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000]
+			}]}},
+			{"name": "fuel.level", "gauge": {"dataPoints": [{"timeUnixNano": "100000000000", "asDouble": 0.5}]}},
+			{"name": "page.views", "sum": {"aggregationTemporality": 1, "isMonotonic": true, "dataPoints": [
+				{"startTimeUnixNano": "90000000000", "timeUnixNano": "100000000000", "asDouble": 10},
+				{"startTimeUnixNano": "90000000000", "timeUnixNano": "100000000000", "asDouble": 10, "attributes": [
+					{"key": "env", "value": {"stringValue": "dev,prod"}},
+					{"key": "urgent", "value": {"stringValue": ""}}
+				]}
+			]}},
+			{"name": "payload.size", "exponentialHistogram": {"aggregationTemporality": 1, "dataPoints": [{
+				"startTimeUnixNano": "90000000000", "timeUnixNano": "100000000000",
+				"count": "3", "sum": -2, "min": -4, "max": 2, "scale": 20, "zeroCount": "1",
+				"positive": {"offset": 1048575, "bucketCounts": ["1"]},
+				"negative": {"offset": 2097151, "bucketCounts": ["1"]}
+			}]}}
+		]}]
+	}]}`, string(intake.bodies[0]))
+}
+
+func TestOtlpSinkCumulative(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := newOtlpSink("http://localhost", "json", "cumulative", "", newTestIntakeClient())
+	assert.NoError(err)
+	sink.start = time.Unix(50, 0)
+
+	sink.request(testOtlpSeries())
+	req := sink.request(testOtlpSeries())
+
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal("api.latency", metrics[0].Name)
+	assert.Equal(cumulativeOtlpTemporality, metrics[0].Histogram.AggregationTemporality)
+	assert.Equal(otlpUint64(10), metrics[0].Histogram.DataPoints[0].Count)
+	assert.Equal(otlpUint64(50e9), metrics[0].Histogram.DataPoints[0].StartTimeUnixNano)
+
+	assert.Equal("page.views", metrics[2].Name)
+	assert.Equal(20.0, metrics[2].Sum.DataPoints[0].AsDouble)
+	assert.Equal(otlpUint64(50e9), metrics[2].Sum.DataPoints[0].StartTimeUnixNano)
+
+	assert.Equal(otlpUint64(6), metrics[3].ExponentialHistogram.DataPoints[0].Count)
+
+	// gauges aren't accumulated
+	assert.Equal(0.5, metrics[1].Gauge.DataPoints[0].AsDouble)
+}
+
+func TestOtlpSinkNegativeCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := newOtlpSink("http://localhost", "json", "cumulative", "", newTestIntakeClient())
+	assert.NoError(err)
+
+	request := func(msgs ...string) otlpSum {
+		agg := newAggregator(10 * time.Second)
+		for _, msg := range msgs {
+			agg.handler([]byte(msg))
+		}
+		return *sink.request(agg.flush(time.Unix(100, 0))).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum
+	}
+
+	assert.True(request("page.views:5|c").IsMonotonic)
+
+	// a counter which goes down is no longer monotonic, even in a later export
+	sum := request("page.views:1|c|#env:dev", "page.views:-2|c")
+	assert.False(sum.IsMonotonic)
+	assert.Equal(3.0, sum.DataPoints[0].AsDouble)
+	assert.False(request("page.views:1|c").IsMonotonic)
+
+	// other counters are unaffected
+	assert.True(request("page.errors:1|c").IsMonotonic)
+}
+
+func TestOtlpSinkProtobuf(t *testing.T) {
+	assert := assert.New(t)
+
+	intake := newTestIntake(t, http.StatusServiceUnavailable)
+	sink, err := newOtlpSink(intake.URL, "protobuf", "delta", "host1", newTestIntakeClient())
+	assert.NoError(err)
+	assert.NoError(sink.submit(testOtlpSeries()))
+
+	assert.Len(intake.requests, 2)
+	assert.Equal("application/x-protobuf", intake.requests[1].Header.Get("Content-Type"))
+
+	resourceMetrics := protoFields(decodeProto(t, intake.bodies[1]), 1)
+	assert.Len(resourceMetrics, 1)
+	rm := decodeProto(t, resourceMetrics[0].bytes)
+
+	resource := decodeProto(t, protoFields(rm, 1)[0].bytes)
+	hostAttr := decodeProto(t, protoFields(resource, 1)[1].bytes)
+	assert.Equal("host.name", string(protoFields(hostAttr, 1)[0].bytes))
+
+	sm := decodeProto(t, protoFields(rm, 2)[0].bytes)
+	names := []string{}
+	kinds := []int{}
+	for _, f := range protoFields(sm, 2) {
+		metric := decodeProto(t, f.bytes)
+		names = append(names, string(protoFields(metric, 1)[0].bytes))
+		kinds = append(kinds, metric[1].num)
+	}
+	assert.Equal([]string{"api.latency", "fuel.level", "page.views", "payload.size"}, names)
+	assert.Equal([]int{9, 5, 7, 10}, kinds)
+
+	// page.views is a monotonic delta sum of two points
+	sum := decodeProto(t, protoFields(decodeProto(t, protoFields(sm, 2)[2].bytes), 7)[0].bytes)
+	assert.Len(protoFields(sum, 1), 2)
+	assert.Equal(uint64(deltaOtlpTemporality), protoFields(sum, 2)[0].value)
+	assert.Equal(uint64(1), protoFields(sum, 3)[0].value)
+
+	point := decodeProto(t, protoFields(sum, 1)[0].bytes)
+	assert.Equal(uint64(90e9), protoFields(point, 2)[0].value)
+	assert.Equal(uint64(100e9), protoFields(point, 3)[0].value)
+	assert.Equal(10.0, math.Float64frombits(protoFields(point, 4)[0].value))
+}
+
+func TestNewOtlpSinkErrors(t *testing.T) {
+	_, err := newOtlpSink("http://localhost", "xml", "delta", "", newTestIntakeClient())
+	assert.EqualError(t, err, "INVALID_OTLP_FORMAT (xml)")
+	_, err = newOtlpSink("http://localhost", "json", "sometimes", "", newTestIntakeClient())
+	assert.EqualError(t, err, "INVALID_OTLP_TEMPORALITY (sometimes)")
+}
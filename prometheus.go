@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// how many of the most recent samples summary quantiles are calculated over
+const promSummaryWindow = 500
+
+var promQuantiles = []float64{0.5, 0.9, 0.99}
+
+type promKind int
+
+const (
+	counterPromKind promKind = iota
+	gaugePromKind
+	summaryPromKind
+	histogramPromKind
+)
+
+func (k promKind) String() string {
+	switch k {
+	case counterPromKind:
+		return "counter"
+	case gaugePromKind:
+		return "gauge"
+	case summaryPromKind:
+		return "summary"
+	case histogramPromKind:
+		return "histogram"
+	}
+
+	return "unknown"
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+// the cumulative state of one metric name and label set
+type promSeries struct {
+	labels  []promLabel
+	updated time.Time
+
+	value   float64             // counters and gauges
+	set     map[string]struct{} // sets, exposed as a gauge of unique values seen
+	sum     float64             // summaries and histograms
+	count   float64             // summaries and histograms, scaled up by sample rate
+	samples []float64           // summaries: the most recent samples, oldest first
+	buckets []float64           // histograms: counts per upper bound, not yet cumulative
+}
+
+type promFamily struct {
+	name   string
+	kind   promKind
+	help   string
+	series map[string]*promSeries
+}
+
+// promRegistry accumulates received metrics into Prometheus series: counters as counters,
+// gauges and sets as gauges, and timers, histograms and distributions as summaries or, with
+// buckets, histograms. Series not updated within the expiry are dropped
+type promRegistry struct {
+	buckets []float64 // histogram upper bounds; nil exposes summaries
+	expiry  time.Duration
+
+	mu        sync.Mutex
+	families  map[string]*promFamily
+	conflicts map[string]bool // names already reported as received with conflicting types
+	negatives map[string]bool // counters already reported as receiving negative increments
+}
+
+func newPromRegistry(buckets []float64, expiry time.Duration) *promRegistry {
+	return &promRegistry{
+		buckets:   buckets,
+		expiry:    expiry,
+		families:  map[string]*promFamily{},
+		conflicts: map[string]bool{},
+		negatives: map[string]bool{},
+	}
+}
+
+// parse a comma-separated list of increasing histogram bucket upper bounds
+func parsePromBuckets(str string) ([]float64, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	buckets := []float64{}
+	for _, part := range strings.Split(str, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return nil, fmt.Errorf("INVALID_BUCKET (%s)", part)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("INVALID_BUCKET_ORDER (%s)", str)
+		}
+		buckets = append(buckets, bound)
+	}
+
+	return buckets, nil
+}
+
+// replace anything but [a-zA-Z0-9_] (plus : in metric names) with _, never starting with a digit
+func promSanitize(str string, colons bool) string {
+	var b strings.Builder
+	for i, c := range str {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', colons && c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// convert dogstatsd tags to sorted labels. Valueless tags become "true", repeated keys have
+// their values joined with commas, and names reserved by Prometheus are prefixed with tag_
+func promLabels(tags []string) []promLabel {
+	values := map[string][]string{}
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			value = "true"
+		}
+
+		name := promSanitize(key, false)
+		if strings.HasPrefix(name, "__") || name == "le" || name == "quantile" {
+			name = "tag_" + name
+		}
+		values[name] = append(values[name], value)
+	}
+
+	labels := make([]promLabel, 0, len(values))
+	for name, vs := range values {
+		labels = append(labels, promLabel{name: name, value: strings.Join(vs, ",")})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	return labels
+}
+
+func (r *promRegistry) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	if metric, ok := dMsg.(dogstatsd.Metric); ok {
+		r.observe(metric, time.Now())
+	}
+	return nil
+}
+
+func (r *promRegistry) observe(metric dogstatsd.Metric, now time.Time) {
+	kind := gaugePromKind
+	name := promSanitize(metric.Name, true)
+	switch metric.MetricType {
+	case dogstatsd.CounterMetricType:
+		kind = counterPromKind
+		name = strings.TrimSuffix(name, "_total")
+	case dogstatsd.TimerMetricType, dogstatsd.HistogramMetricType, dogstatsd.DistributionMetricType:
+		kind = summaryPromKind
+		if r.buckets != nil {
+			kind = histogramPromKind
+		}
+	}
+
+	sampleRate := metric.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[name]
+	if !ok {
+		family = &promFamily{
+			name:   name,
+			kind:   kind,
+			help:   fmt.Sprintf("dogstatsd %s %s", metric.MetricType.String(), metric.Name),
+			series: map[string]*promSeries{},
+		}
+		r.families[name] = family
+	} else if family.kind != kind {
+		if !r.conflicts[name] {
+			r.conflicts[name] = true
+			log.Printf("prometheus: ignoring %s %s, already exposed as a %s", metric.MetricType.String(), metric.Name, family.kind.String())
+		}
+		return
+	}
+
+	labels := promLabels(metric.Tags)
+	key := ""
+	for _, label := range labels {
+		key += label.name + "=" + label.value + "\x00"
+	}
+
+	series, ok := family.series[key]
+	if !ok {
+		series = &promSeries{labels: labels, set: map[string]struct{}{}}
+		if kind == histogramPromKind {
+			series.buckets = make([]float64, len(r.buckets))
+		}
+		family.series[key] = series
+	}
+	series.updated = now
+
+	for _, value := range metric.Values {
+		switch {
+		case metric.MetricType == dogstatsd.SetMetricType:
+			series.set[value.Raw] = struct{}{}
+			series.value = float64(len(series.set))
+		case kind == counterPromKind && value.Numeric < 0:
+			// prometheus reads a counter going down as a reset, so negative increments are
+			// ignored, as statsd_exporter does
+			if !r.negatives[name] {
+				r.negatives[name] = true
+				log.Printf("prometheus: ignoring negative increments of counter %s (%s)", metric.Name, value.Raw)
+			}
+		case kind == counterPromKind:
+			series.value += value.Numeric / sampleRate
+		case kind == gaugePromKind:
+			series.value = value.Numeric
+		default:
+			series.sum += value.Numeric / sampleRate
+			series.count += 1 / sampleRate
+
+			if kind == summaryPromKind {
+				series.samples = append(series.samples, value.Numeric)
+				if len(series.samples) > promSummaryWindow {
+					series.samples = series.samples[len(series.samples)-promSummaryWindow:]
+				}
+			} else {
+				for i, bound := range r.buckets {
+					if value.Numeric <= bound {
+						series.buckets[i] += 1 / sampleRate
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// drop series not updated within the expiry, and families left empty
+func (r *promRegistry) expire(now time.Time) {
+	if r.expiry <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, family := range r.families {
+		for key, series := range family.series {
+			if now.Sub(series.updated) > r.expiry {
+				delete(family.series, key)
+			}
+		}
+		if len(family.series) == 0 {
+			delete(r.families, name)
+		}
+	}
+}
+
+func formatPromFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func writePromSample(w io.Writer, name string, labels []promLabel, extra *promLabel, value float64) {
+	if extra != nil {
+		labels = append(append([]promLabel{}, labels...), *extra)
+	}
+
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, label := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, label.name, promLabelEscaper.Replace(label.value))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatPromFloat(value))
+}
+
+// write every series in the Prometheus text format, or OpenMetrics if openMetrics is set
+func (r *promRegistry) write(w io.Writer, openMetrics bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := r.families[name]
+
+		// the text format names counter families after their samples, OpenMetrics doesn't
+		typeName := family.name
+		if family.kind == counterPromKind && !openMetrics {
+			typeName += "_total"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", typeName, promHelpEscaper.Replace(family.help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", typeName, family.kind.String())
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+
+			switch family.kind {
+			case counterPromKind:
+				writePromSample(w, family.name+"_total", series.labels, nil, series.value)
+			case gaugePromKind:
+				writePromSample(w, family.name, series.labels, nil, series.value)
+			case summaryPromKind:
+				if len(series.samples) > 0 {
+					sorted := append([]float64{}, series.samples...)
+					sort.Float64s(sorted)
+					for _, q := range promQuantiles {
+						label := promLabel{name: "quantile", value: formatPromFloat(q)}
+						writePromSample(w, family.name, series.labels, &label, samplePercentile(sorted, q))
+					}
+				}
+				writePromSample(w, family.name+"_sum", series.labels, nil, series.sum)
+				writePromSample(w, family.name+"_count", series.labels, nil, series.count)
+			case histogramPromKind:
+				cumulative := 0.0
+				for i, bound := range r.buckets {
+					cumulative += series.buckets[i]
+					label := promLabel{name: "le", value: formatPromFloat(bound)}
+					writePromSample(w, family.name+"_bucket", series.labels, &label, cumulative)
+				}
+				label := promLabel{name: "le", value: "+Inf"}
+				writePromSample(w, family.name+"_bucket", series.labels, &label, series.count)
+				writePromSample(w, family.name+"_sum", series.labels, nil, series.sum)
+				writePromSample(w, family.name+"_count", series.labels, nil, series.count)
+			}
+		}
+	}
+
+	if openMetrics {
+		io.WriteString(w, "# EOF\n")
+	}
+}
+
+// GET /metrics: OpenMetrics if the scraper accepts it, otherwise the Prometheus text format
+func (r *promRegistry) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("METHOD_NOT_ALLOWED (%s)", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.expire(time.Now())
+
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+
+	bw := bufio.NewWriter(w)
+	r.write(bw, openMetrics)
+	bw.Flush()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func observeProm(t *testing.T, r *promRegistry, now time.Time, msgs ...string) {
+	for _, msg := range msgs {
+		dMsg, err := dogstatsd.Parse([]byte(msg))
+		assert.NoError(t, err)
+		r.observe(dMsg.(dogstatsd.Metric), now)
+	}
+}
+
+func promText(r *promRegistry, openMetrics bool) string {
+	var b strings.Builder
+	r.write(&b, openMetrics)
+	return b.String()
+}
+
+func TestParsePromBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	buckets, err := parsePromBuckets("")
+	assert.NoError(err)
+	assert.Nil(buckets)
+
+	buckets, err = parsePromBuckets("0.1, 1,10")
+	assert.NoError(err)
+	assert.Equal([]float64{0.1, 1, 10}, buckets)
+
+	_, err = parsePromBuckets("1,x")
+	assert.EqualError(err, "INVALID_BUCKET (x)")
+	_, err = parsePromBuckets("1,1")
+	assert.EqualError(err, "INVALID_BUCKET_ORDER (1,1)")
+}
+
+func TestPromLabels(t *testing.T) {
+	assert.Equal(t, []promLabel{
+		{name: "_1st", value: "a"},
+		{name: "env", value: "dev,prod"},
+		{name: "service_name", value: "web:1"},
+		{name: "tag___name__", value: "x"},
+		{name: "tag_le", value: "5"},
+		{name: "urgent", value: "true"},
+	}, promLabels([]string{"env:dev", "service.name:web:1", "urgent", "env:prod", "1st:a", "le:5", "__name__:x"}))
+}
+
+func TestPromRegistry(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(100, 0)
+
+	r := newPromRegistry(nil, time.Minute)
+	observeProm(t, r, now,
+		"page.views:1|c|#env:dev",
+		"page.views:2|c|@0.5|#env:dev",
+		"requests_total:1|c",
+		"fuel.level:0.5|g",
+		"fuel.level:0.25|g",
+		"users.uniques:1:2:1|s",
+		"request.time:10:20:30:40|ms|#path:\"/\"",
+		"page.views:5|g",
+	)
+
+	assert.Equal(`# HELP fuel_level dogstatsd gauge fuel.level
+# TYPE fuel_level gauge
+fuel_level 0.25
+# HELP page_views_total dogstatsd counter page.views
+# TYPE page_views_total counter
+page_views_total{env="dev"} 5
+# HELP request_time dogstatsd timer request.time
+# TYPE request_time summary
+request_time{path="\"/\"",quantile="0.5"} 20
+request_time{path="\"/\"",quantile="0.9"} 40
+request_time{path="\"/\"",quantile="0.99"} 40
+request_time_sum{path="\"/\""} 100
+request_time_count{path="\"/\""} 4
+# HELP requests_total dogstatsd counter requests_total
+# TYPE requests_total counter
+requests_total 1
+# HELP users_uniques dogstatsd set users.uniques
+# TYPE users_uniques gauge
+users_uniques 2
+`, promText(r, false))
+
+	assert.True(strings.HasPrefix(promText(r, true), "# HELP fuel_level"))
+	assert.Contains(promText(r, true), "# TYPE page_views counter\npage_views_total{env=\"dev\"} 5\n")
+	assert.True(strings.HasSuffix(promText(r, true), "# EOF\n"))
+
+	// stale series expire
+	observeProm(t, r, now.Add(time.Minute), "fuel.level:1|g")
+	r.expire(now.Add(90 * time.Second))
+	assert.Equal("# HELP fuel_level dogstatsd gauge fuel.level\n# TYPE fuel_level gauge\nfuel_level 1\n", promText(r, false))
+}
+
+func TestPromRegistryNegativeCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	r := newPromRegistry(nil, time.Minute)
+	observeProm(t, r, time.Unix(100, 0), "page.views:3|c", "page.views:-1|c", "page.views:-2:1|c")
+
+	// counters never go down, and the first negative increment is reported
+	assert.Contains(promText(r, false), "page_views_total 4\n")
+	assert.Equal(1, strings.Count(logged.String(), "ignoring negative increments of counter page.views (-1)"))
+	assert.Equal(1, strings.Count(logged.String(), "\n"))
+}
+
+func TestPromRegistryHistograms(t *testing.T) {
+	r := newPromRegistry([]float64{10, 100}, 0)
+	observeProm(t, r, time.Unix(100, 0), "request.time:5:50:500|h", "request.time:5|h|@0.5")
+	r.expire(time.Now())
+
+	assert.Equal(t, `# HELP request_time dogstatsd histogram request.time
+# TYPE request_time histogram
+request_time_bucket{le="10"} 3
+request_time_bucket{le="100"} 4
+request_time_bucket{le="+Inf"} 5
+request_time_sum 565
+request_time_count 5
+`, promText(r, false))
+}
+
+func TestPromRegistryHandleMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newPromRegistry(nil, time.Minute)
+	r.handler([]byte("page.views:1|c"))
+
+	rec := httptest.NewRecorder()
+	r.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(rec.Body.String(), "page_views_total 1\n")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	rec = httptest.NewRecorder()
+	r.handleMetrics(rec, req)
+	assert.Equal("application/openmetrics-text; version=1.0.0; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(rec.Body.String(), "# EOF\n")
+
+	rec = httptest.NewRecorder()
+	r.handleMetrics(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
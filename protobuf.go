@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// protobuf wire types
+const (
+	varintWireType  = 0
+	fixed64WireType = 1
+	bytesWireType   = 2
+)
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// protoBuffer encodes protobuf messages by hand, which is all the (small, fixed) export formats
+// here need. As in proto3, scalar fields with zero values are omitted
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) tag(field int, wireType int) {
+	b.buf = appendUvarint(b.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *protoBuffer) uvarint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, varintWireType)
+	b.buf = appendUvarint(b.buf, v)
+}
+
+func (b *protoBuffer) varint(field int, v int64) {
+	b.uvarint(field, uint64(v))
+}
+
+// zigzag encoded, for sint32 and sint64 fields
+func (b *protoBuffer) svarint(field int, v int64) {
+	b.uvarint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uvarint(field, 1)
+	}
+}
+
+func (b *protoBuffer) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, fixed64WireType)
+	b.buf = appendFixed64(b.buf, v)
+}
+
+func (b *protoBuffer) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, fixed64WireType)
+	b.buf = appendFixed64(b.buf, math.Float64bits(v))
+}
+
+// a proto3 optional double, present even when zero
+func (b *protoBuffer) optionalDouble(field int, v *float64) {
+	if v == nil {
+		return
+	}
+	b.tag(field, fixed64WireType)
+	b.buf = appendFixed64(b.buf, math.Float64bits(*v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, bytesWireType)
+	b.buf = appendUvarint(b.buf, uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+// packed repeated fixed64 and double fields
+func (b *protoBuffer) packedFixed64(field int, vs []uint64) {
+	packed := make([]byte, 0, 8*len(vs))
+	for _, v := range vs {
+		packed = appendFixed64(packed, v)
+	}
+	b.bytes(field, packed)
+}
+
+func (b *protoBuffer) packedDouble(field int, vs []float64) {
+	packed := make([]byte, 0, 8*len(vs))
+	for _, v := range vs {
+		packed = appendFixed64(packed, math.Float64bits(v))
+	}
+	b.bytes(field, packed)
+}
+
+// packed repeated uint64 fields
+func (b *protoBuffer) packedUvarint(field int, vs []uint64) {
+	packed := []byte{}
+	for _, v := range vs {
+		packed = appendUvarint(packed, v)
+	}
+	b.bytes(field, packed)
+}
+
+// an embedded message, which is written even if empty
+func (b *protoBuffer) message(field int, fn func(*protoBuffer)) {
+	inner := &protoBuffer{}
+	fn(inner)
+
+	b.tag(field, bytesWireType)
+	b.buf = appendUvarint(b.buf, uint64(len(inner.buf)))
+	b.buf = append(b.buf, inner.buf...)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a decoded protobuf field: value holds varints and fixed64s, bytes holds everything else
+type protoField struct {
+	num      int
+	wireType int
+	value    uint64
+	bytes    []byte
+}
+
+// decode the fields of a protobuf message, without a schema
+func decodeProto(t *testing.T, buf []byte) []protoField {
+	fields := []protoField{}
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if !assert.Greater(t, n, 0, "invalid key") {
+			return fields
+		}
+		buf = buf[n:]
+
+		f := protoField{num: int(key >> 3), wireType: int(key & 7)}
+		switch f.wireType {
+		case varintWireType:
+			f.value, n = binary.Uvarint(buf)
+			if !assert.Greater(t, n, 0, "invalid varint") {
+				return fields
+			}
+			buf = buf[n:]
+		case fixed64WireType:
+			if !assert.GreaterOrEqual(t, len(buf), 8, "short fixed64") {
+				return fields
+			}
+			f.value = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+		case bytesWireType:
+			length, n := binary.Uvarint(buf)
+			if !assert.Greater(t, n, 0, "invalid length") || !assert.GreaterOrEqual(t, uint64(len(buf)-n), length, "short bytes") {
+				return fields
+			}
+			f.bytes = buf[n : n+int(length)]
+			buf = buf[n+int(length):]
+		default:
+			assert.Fail(t, "unexpected wire type", "%d", f.wireType)
+			return fields
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// the fields with a number
+func protoFields(fields []protoField, num int) []protoField {
+	matching := []protoField{}
+	for _, f := range fields {
+		if f.num == num {
+			matching = append(matching, f)
+		}
+	}
+	return matching
+}
+
+func TestProtoBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &protoBuffer{}
+	b.uvarint(1, 150)
+	b.string(2, "testing")
+	b.svarint(3, -2)
+	b.double(4, 1.5)
+	b.message(5, func(b *protoBuffer) { b.bool(1, true) })
+	b.packedUvarint(6, []uint64{3, 270})
+
+	// zero values are omitted, except for embedded messages and optional fields
+	b.uvarint(7, 0)
+	b.string(7, "")
+	b.double(7, 0)
+	b.packedDouble(7, nil)
+	b.message(8, func(b *protoBuffer) {})
+	zero := 0.0
+	b.optionalDouble(9, &zero)
+
+	assert.Equal("089601"+"120774657374696e67"+"1803"+"21000000000000f83f"+"2a020801"+"3203038e02"+"4200"+"490000000000000000", hex.EncodeToString(b.buf))
+
+	fields := decodeProto(t, b.buf)
+	assert.Len(fields, 8)
+	assert.Equal(uint64(150), protoFields(fields, 1)[0].value)
+	assert.Equal("testing", string(protoFields(fields, 2)[0].bytes))
+	assert.Equal(1.5, math.Float64frombits(protoFields(fields, 4)[0].value))
+	assert.Equal(uint64(1), decodeProto(t, protoFields(fields, 5)[0].bytes)[0].value)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// a single datagram in a capture file; captures are JSON lines, one packet per line. Data is
+// base64 encoded, so that packets which aren't valid UTF-8 are replayed exactly as received
+type capturedPacket struct {
+	Ts   time.Time `json:"ts"`
+	Src  string    `json:"src"`
+	Data []byte    `json:"data"`
+}
+
+// recorder writes every datagram received to a capture file
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newRecorder(filename string) (*recorder, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	return &recorder{
+		f:   f,
+		w:   w,
+		enc: json.NewEncoder(w),
+	}, nil
+}
+
+// packetHandler records each packet before passing it on to fn
+func (r *recorder) packetHandler(fn dogstatsd.PacketHandler) dogstatsd.PacketHandler {
+	return func(msg []byte, addr net.Addr) error {
+		pkt := capturedPacket{
+			Ts:   time.Now(),
+			Data: msg, // encoded before the server reuses it
+		}
+		if addr != nil {
+			pkt.Src = addr.String()
+		}
+
+		r.mu.Lock()
+		err := r.enc.Encode(&pkt)
+		r.mu.Unlock()
+		if err != nil {
+			log.Println("record error:", err.Error())
+		}
+
+		return fn(msg, addr)
+	}
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+
+	return r.f.Close()
+}
+
+func readCapture(r io.Reader) ([]capturedPacket, error) {
+	packets := []capturedPacket{}
+
+	dec := json.NewDecoder(r)
+	for {
+		var pkt capturedPacket
+		if err := dec.Decode(&pkt); err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("INVALID_CAPTURE (packet %d: %s)", len(packets)+1, err.Error())
+		}
+
+		packets = append(packets, pkt)
+	}
+}
+
+// replay sends packets to conn, spacing them out by their original receive times divided by
+// speed (or as fast as possible if speed is 0), stopping early if stopCh is closed
+func replay(conn net.Conn, packets []capturedPacket, speed float64, stopCh <-chan struct{}) (int, error) {
+	if len(packets) == 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	first := packets[0].Ts
+
+	for i, pkt := range packets {
+		if speed > 0 {
+			offset := time.Duration(float64(pkt.Ts.Sub(first)) / speed)
+			select {
+			case <-stopCh:
+				return i, nil
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		} else {
+			select {
+			case <-stopCh:
+				return i, nil
+			default:
+			}
+		}
+
+		if _, err := conn.Write(pkt.Data); err != nil {
+			return i, err
+		}
+	}
+
+	return len(packets), nil
+}
+
+// replay subcommand: resend a capture written with -record
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("target", "127.0.0.1:8125", "address to send packets to")
+	speed := flags.Float64("speed", 1, "replay speed relative to the original capture (0 sends as fast as possible)")
+	loop := flags.Bool("loop", false, "replay the capture repeatedly until interrupted")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s replay [flags] capture.jsonl\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	packets, err := readCapture(f)
+	f.Close()
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	if len(packets) == 0 {
+		log.Printf("capture is empty (%s)", flags.Arg(0))
+		return 1
+	}
+
+	conn, err := net.Dial("udp", *target)
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	defer conn.Close()
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	total := 0
+	for {
+		n, err := replay(conn, packets, *speed, stopCh)
+		total += n
+		if err != nil {
+			log.Println("replay error:", err.Error())
+			return 1
+		}
+
+		select {
+		case <-stopCh:
+		default:
+			if *loop {
+				continue
+			}
+		}
+
+		break
+	}
+
+	log.Printf("replayed %d packets to %s", total, *target)
+	return 0
+}
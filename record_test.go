@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	msgs := []string{"page.views:1|c", "_e{5,5}:Error|Error", "_sc|db|0", "bad\xff\xfe:1|c\nnext:1|c"}
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+
+	// record
+	filename := filepath.Join(t.TempDir(), "capture.jsonl")
+	rec, err := newRecorder(filename)
+	assert.NoError(err)
+
+	handled := []string{}
+	handler := rec.packetHandler(func(msg []byte, addr net.Addr) error {
+		handled = append(handled, string(msg))
+		return nil
+	})
+	for _, msg := range msgs {
+		assert.NoError(handler([]byte(msg), src))
+	}
+	assert.NoError(rec.close())
+	assert.Equal(msgs, handled)
+
+	f, err := os.Open(filename)
+	assert.NoError(err)
+	defer f.Close()
+
+	packets, err := readCapture(f)
+	assert.NoError(err)
+	assert.Len(packets, len(msgs))
+	for i, pkt := range packets {
+		assert.Equal(msgs[i], string(pkt.Data))
+		assert.Equal("10.0.0.1:1234", pkt.Src)
+		assert.WithinDuration(time.Now(), pkt.Ts, time.Second)
+	}
+
+	// replay
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(err)
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	assert.NoError(err)
+	defer conn.Close()
+
+	n, err := replay(conn, packets, 0, make(chan struct{}))
+	assert.NoError(err)
+	assert.Equal(len(msgs), n)
+
+	buf := make([]byte, 1024)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	for _, msg := range msgs {
+		n, _, err := listener.ReadFromUDP(buf)
+		assert.NoError(err)
+		assert.Equal(msg, string(buf[:n]))
+	}
+}
+
+func TestCapturedPacketJson(t *testing.T) {
+	assert := assert.New(t)
+
+	// packets which aren't valid UTF-8 survive a round trip
+	pkt := capturedPacket{Ts: time.Unix(1700000000, 0).UTC(), Src: "10.0.0.1:1234", Data: []byte("bad\xff\xfe:1|c")}
+	data, err := json.Marshal(&pkt)
+	assert.NoError(err)
+	assert.Equal(`{"ts":"2023-11-14T22:13:20Z","src":"10.0.0.1:1234","data":"YmFk//46MXxj"}`, string(data))
+
+	packets, err := readCapture(bytes.NewReader(append(data, '\n')))
+	assert.NoError(err)
+	assert.Equal([]capturedPacket{pkt}, packets)
+}
+
+func TestRunReplayEmptyCapture(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "capture.jsonl")
+	assert.NoError(t, os.WriteFile(filename, nil, 0644))
+
+	// rather than looping forever over nothing
+	assert.Equal(t, 1, runReplay([]string{"-loop", filename}))
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"gopkg.in/yaml.v3"
+)
+
+// redactionRule replaces every match of a regex, optionally only those which pass a check
+type redactionRule struct {
+	Name    string `yaml:"name"`
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"` // [REDACTED_<NAME>] by default
+
+	re    *regexp.Regexp
+	valid func(str string, start, end int) bool // whether str[start:end] is really a match
+}
+
+func (r *redactionRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("MISSING_NAME")
+	}
+	if r.Match == "" {
+		return fmt.Errorf("MISSING_MATCH")
+	}
+	if r.Replace == "" {
+		r.Replace = "[REDACTED_" + strings.ToUpper(r.Name) + "]"
+	}
+	// the replacement ends up in tags, so mustn't split them
+	if strings.ContainsAny(r.Replace, ",|\n") {
+		return fmt.Errorf("INVALID_REPLACEMENT (%s)", r.Replace)
+	}
+
+	var err error
+	if r.re, err = regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("INVALID_MATCH (%s)", r.Match)
+	}
+
+	return nil
+}
+
+// whether a number passes the Luhn checksum, as card numbers do
+func luhnValid(str string) bool {
+	sum, digits := 0, 0
+	for i := len(str) - 1; i >= 0; i-- {
+		c := str[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+
+	return digits > 0 && sum%10 == 0
+}
+
+// whether an IP address at str[start:end] parses and, if it's IPv6, stands alone rather than
+// being part of a longer word such as Foo::Bar or std::string and, if it's IPv4, isn't part of a
+// longer dotted number such as 1.3.6.1.4.1
+func ipValid(str string, start, end int) bool {
+	adjacent := func(c byte) bool {
+		return c == ':' || c == '.' || c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+	}
+
+	ip := str[start:end]
+	if strings.Contains(ip, ":") {
+		if start > 0 && adjacent(str[start-1]) || end < len(str) && adjacent(str[end]) {
+			return false
+		}
+	} else if start > 0 && str[start-1] == '.' || end < len(str) && str[end] == '.' {
+		return false
+	}
+	return net.ParseIP(ip) != nil
+}
+
+// built-in rules, by name; IPs and card numbers are checked so that versions, times, ids and
+// qualified names which merely look like them are left alone (see ipValid for the limits)
+var builtinRedactionRules = map[string]func() *redactionRule{
+	"email": func() *redactionRule {
+		return &redactionRule{Name: "email", Match: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`}
+	},
+	"bearer_token": func() *redactionRule {
+		return &redactionRule{Name: "bearer_token", Match: `(?i)\bbearer[ :=]+[A-Za-z0-9\-._~+/]+=*`}
+	},
+	"jwt": func() *redactionRule {
+		return &redactionRule{Name: "jwt", Match: `\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`}
+	},
+	"ip": func() *redactionRule {
+		return &redactionRule{
+			Name:  "ip",
+			Match: `\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{0,4}:){2,7}(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f]{0,4})`,
+			valid: ipValid,
+		}
+	},
+	"credit_card": func() *redactionRule {
+		return &redactionRule{
+			Name:  "credit_card",
+			Match: `\b(?:\d[ \-]?){12,18}\d\b`,
+			valid: func(str string, start, end int) bool { return luhnValid(str[start:end]) },
+		}
+	},
+}
+
+func builtinRedactionRuleNames() []string {
+	names := make([]string, 0, len(builtinRedactionRules))
+	for name := range builtinRedactionRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the field of a message where something was redacted, and by which rule
+type redaction struct {
+	rule  string
+	field string
+}
+
+// redactor replaces personal data and secrets in tag values, event titles and text, and service
+// check messages before anything else sees them, counting redactions and warning (once) about
+// each message name and field they're found in
+type redactor struct {
+	rules []*redactionRule
+
+	mu     sync.Mutex
+	counts map[string]int
+	warned map[string]bool
+}
+
+// redactionConfig is the YAML (or JSON) form of a redactor: built-in rules by name (or all),
+// and rules of its own
+type redactionConfig struct {
+	Builtin yamlStrings      `yaml:"builtin"`
+	Rules   []*redactionRule `yaml:"rules"`
+}
+
+func newRedactor(config redactionConfig) (*redactor, error) {
+	r := &redactor{
+		counts: map[string]int{},
+		warned: map[string]bool{},
+	}
+
+	for _, name := range config.Builtin {
+		if name == "all" {
+			for _, all := range builtinRedactionRuleNames() {
+				r.rules = append(r.rules, builtinRedactionRules[all]())
+			}
+			continue
+		}
+
+		rule, ok := builtinRedactionRules[name]
+		if !ok {
+			return nil, fmt.Errorf("UNKNOWN_REDACTION (%s), expected all or one of %s", name, strings.Join(builtinRedactionRuleNames(), ", "))
+		}
+		r.rules = append(r.rules, rule())
+	}
+
+	for i, rule := range config.Rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i+1, err.Error())
+		}
+		r.rules = append(r.rules, rule)
+	}
+
+	for _, rule := range r.rules {
+		if rule.re == nil {
+			if err := rule.compile(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func loadRedactionConfig(filename string) (redactionConfig, error) {
+	config := redactionConfig{}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true) // so that a misspelt field isn't silently ignored, leaving data unredacted
+	if err := dec.Decode(&config); err != nil && err != io.EOF {
+		return config, err
+	}
+	return config, nil
+}
+
+// redact a string with every rule, returning the name of the rule behind each redaction
+func (r *redactor) redact(str string) (string, []string) {
+	matched := []string{}
+	for _, rule := range r.rules {
+		var b strings.Builder
+		last, replaced := 0, false
+		for _, loc := range rule.re.FindAllStringIndex(str, -1) {
+			if rule.valid != nil && !rule.valid(str, loc[0], loc[1]) {
+				continue
+			}
+			b.WriteString(str[last:loc[0]])
+			b.WriteString(rule.Replace)
+			last, replaced = loc[1], true
+			matched = append(matched, rule.Name)
+		}
+
+		if replaced {
+			b.WriteString(str[last:])
+			str = b.String()
+		}
+	}
+
+	return str, matched
+}
+
+// redact the values of tags (or whole tags, without values)
+func (r *redactor) redactTags(tags []string, redactions []redaction) ([]string, []redaction) {
+	out := make([]string, len(tags))
+	for i, tag := range tags {
+		key, value, hasValue := strings.Cut(tag, ":")
+		if !hasValue {
+			key, value = "", tag
+		}
+
+		// a four-part version can't be told apart from an IPv4 address, so is left alone
+		if hasValue && strings.HasSuffix(strings.ToLower(key), "version") && net.ParseIP(value) != nil {
+			out[i] = tag
+			continue
+		}
+
+		var matched []string
+		if value, matched = r.redact(value); len(matched) == 0 {
+			out[i] = tag
+			continue
+		}
+
+		field := "tag"
+		if hasValue {
+			out[i], field = key+":"+value, "tag "+key
+		} else {
+			out[i] = value
+		}
+		for _, rule := range matched {
+			redactions = append(redactions, redaction{rule: rule, field: field})
+		}
+	}
+
+	return out, redactions
+}
+
+func (r *redactor) redactField(str string, field string, redactions []redaction) (string, []redaction) {
+	str, matched := r.redact(str)
+	for _, rule := range matched {
+		redactions = append(redactions, redaction{rule: rule, field: field})
+	}
+	return str, redactions
+}
+
+// redactMsg returns the message with everything matching a rule replaced, along with its name
+// (itself redacted) and where the redactions were made
+func (r *redactor) redactMsg(dMsg dogstatsd.Msg) (dogstatsd.Msg, string, []redaction) {
+	redactions := []redaction{}
+
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		msg.Tags, redactions = r.redactTags(msg.Tags, redactions)
+		return msg, msg.Name, redactions
+	case dogstatsd.Event:
+		msg.Title, redactions = r.redactField(msg.Title, "title", redactions)
+		msg.Text, redactions = r.redactField(msg.Text, "text", redactions)
+		msg.Tags, redactions = r.redactTags(msg.Tags, redactions)
+		return msg, msg.Title, redactions
+	case dogstatsd.ServiceCheck:
+		msg.Message, redactions = r.redactField(msg.Message, "message", redactions)
+		msg.Tags, redactions = r.redactTags(msg.Tags, redactions)
+		return msg, msg.Name, redactions
+	}
+
+	return dMsg, "", redactions
+}
+
+// record redactions, warning the first time each is found in a message's field
+func (r *redactor) record(kind string, name string, redactions []redaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, red := range redactions {
+		r.counts[red.rule]++
+
+		key := kind + "|" + name + "|" + red.field + "|" + red.rule
+		if !r.warned[key] {
+			r.warned[key] = true
+			log.Printf("REDACTED %s %s: %s in %s (further redactions here are counted silently)", kind, name, red.rule, red.field)
+		}
+	}
+}
+
+// redactDatagram returns a single message with every rule applied, or nil if it has to be
+// dropped. Messages which can't be parsed have the rules applied to the whole datagram, since
+// they're still reported
+func (r *redactor) redactDatagram(msg []byte) []byte {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		redacted, matched := r.redact(string(msg))
+		if len(matched) == 0 {
+			return msg
+		}
+
+		redactions := []redaction{}
+		for _, rule := range matched {
+			redactions = append(redactions, redaction{rule: rule, field: "datagram"})
+		}
+		r.record("unparseable", "message", redactions)
+		return []byte(redacted)
+	}
+
+	redactedMsg, name, redactions := r.redactMsg(dMsg)
+	if len(redactions) == 0 {
+		return msg
+	}
+	r.record(dMsg.Type().String(), name, redactions)
+
+	encoded, err := dogstatsd.Encode(redactedMsg)
+	if err != nil {
+		// passing the original on would leak what was redacted
+		log.Printf("dropping %s %s which can't be encoded once redacted: %s", dMsg.Type().String(), name, err.Error())
+		return nil
+	}
+	return encoded
+}
+
+// filter returns a packet with every message redacted, or nil if none are left
+func (r *redactor) filter(packet []byte) []byte {
+	msgs := dogstatsd.SplitPacket(packet)
+	out := make([][]byte, 0, len(msgs))
+	changed := false
+	for _, msg := range msgs {
+		redacted := r.redactDatagram(msg)
+		changed = changed || !bytes.Equal(redacted, msg)
+		if redacted != nil {
+			out = append(out, redacted)
+		}
+	}
+
+	switch {
+	case len(out) == 0:
+		return nil
+	case !changed:
+		return packet
+	}
+	return bytes.Join(out, []byte("\n"))
+}
+
+// packetHandler passes packets on to fn once redacted
+func (r *redactor) packetHandler(fn dogstatsd.PacketHandler) dogstatsd.PacketHandler {
+	return func(packet []byte, addr net.Addr) error {
+		if out := r.filter(packet); out != nil {
+			return fn(out, addr)
+		}
+		return nil
+	}
+}
+
+// the number of redactions made by each rule which has made any
+func (r *redactor) summary() (int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	counts := []string{}
+	for _, rule := range r.rules {
+		if count := r.counts[rule.Name]; count > 0 {
+			total += count
+			counts = append(counts, fmt.Sprintf("%s: %d", rule.Name, count))
+		}
+	}
+
+	return total, strings.Join(counts, ", ")
+}
+
+// redaction flags: built-in rules and a file of rules
+type redactFlags struct {
+	builtin string
+	file    string
+}
+
+func (f *redactFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.builtin, "redact", "", "redact tag values, event titles and text, and service check messages matching these comma-separated built-in rules (or all): "+strings.Join(builtinRedactionRuleNames(), "|"))
+	flags.StringVar(&f.file, "redact-rules", "", "YAML or JSON file of built-in and custom redaction rules")
+}
+
+// build a redactor from the rules given, or nil if there are none
+func (f *redactFlags) redactor() (*redactor, error) {
+	config := redactionConfig{}
+	if f.file != "" {
+		var err error
+		if config, err = loadRedactionConfig(f.file); err != nil {
+			return nil, err
+		}
+	}
+	config.Builtin = append(config.Builtin, splitList(f.builtin)...)
+
+	if len(config.Builtin) == 0 && len(config.Rules) == 0 {
+		return nil, nil
+	}
+	return newRedactor(config)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLuhnValid(t *testing.T) {
+	assert.True(t, luhnValid("4111 1111 1111 1111"))
+	assert.True(t, luhnValid("5500-0000-0000-0004"))
+	assert.False(t, luhnValid("4111 1111 1111 1112"))
+	assert.False(t, luhnValid(""))
+}
+
+func TestRedactor(t *testing.T) {
+	red, err := newRedactor(redactionConfig{
+		Builtin: yamlStrings{"all"},
+		Rules:   []*redactionRule{{Name: "api_key", Match: `sk_live_[A-Za-z0-9]+`}},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{"login:1|c|#user:jo@example.com,env:dev", "login:1|c|#user:[REDACTED_EMAIL],env:dev"},
+		{"req:1|c|#auth:Bearer abc.def-123", "req:1|c|#auth:[REDACTED_BEARER_TOKEN]"},
+		{"req:1|c|#token:eyJhbGciOi.eyJzdWIiOi.sig_nature", "req:1|c|#token:[REDACTED_JWT]"},
+		{"req:1|c|#client:10.1.2.3,ip:10.1.2.3", "req:1|c|#client:[REDACTED_IP],ip:[REDACTED_IP]"},
+		{"req:1|c|#client:fe80::1,mapped:::ffff:10.1.2.3", "req:1|c|#client:[REDACTED_IP],mapped:[REDACTED_IP]"},
+		{"req:1|c|#version:1.2.3,time:12:30:45,build:999.999.999.999", "req:1|c|#version:1.2.3,time:12:30:45,build:999.999.999.999"},
+		{"req:1|c|#version:1.2.3.4,app_version:10.0.0.1,oid:1.3.6.1.4.1", "req:1|c|#version:1.2.3.4,app_version:10.0.0.1,oid:1.3.6.1.4.1"},
+		{"req:1|c|#class:Foo::Bar,type:std::string,handler:Api::Users", "req:1|c|#class:Foo::Bar,type:std::string,handler:Api::Users"},
+		{"pay:1|c|#card:4111 1111 1111 1111", "pay:1|c|#card:[REDACTED_CREDIT_CARD]"},
+		{"pay:1|c|#order:4111111111111112,ts:1700000000000", "pay:1|c|#order:4111111111111112,ts:1700000000000"},
+		{"req:1|c|#sk_live_abc123", "req:1|c|#[REDACTED_API_KEY]"},
+		{"_e{21,13}:signup jo@example.com|from 10.0.0.1|d:1000", "_e{23,18}:signup [REDACTED_EMAIL]|from [REDACTED_IP]|d:1000"},
+		{"_sc|db.up|2|d:1000|m:login failed for jo@example.com", "_sc|db.up|2|d:1000|m:login failed for [REDACTED_EMAIL]"},
+		{"not a datagram from jo@example.com", "not a datagram from [REDACTED_EMAIL]"},
+		{"a:1|c\nb:2|c|#user:jo@example.com", "a:1|c\nb:2|c|#user:[REDACTED_EMAIL]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			assert.Equal(t, test.expected, string(red.filter([]byte(test.msg))))
+		})
+	}
+
+	total, counts := red.summary()
+	assert.Equal(t, 14, total)
+	assert.Equal(t, "bearer_token: 1, credit_card: 1, email: 5, ip: 5, jwt: 1, api_key: 1", counts)
+}
+
+func TestRedactorPacketHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	red, err := newRedactor(redactionConfig{Builtin: yamlStrings{"email"}})
+	assert.NoError(err)
+
+	packet := []byte("a:1|c|#env:dev")
+	received := [][]byte{}
+	handler := red.packetHandler(func(packet []byte, _ net.Addr) error {
+		received = append(received, packet)
+		return nil
+	})
+	handler(packet, nil)
+	handler([]byte("b:1|c|#jo@example.com"), nil)
+
+	// untouched packets are passed on as they are
+	assert.Len(received, 2)
+	assert.Same(&packet[0], &received[0][0])
+	assert.Equal("b:1|c|#[REDACTED_EMAIL]", string(received[1]))
+}
+
+func TestRedactFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	flags := redactFlags{}
+	red, err := flags.redactor()
+	assert.NoError(err)
+	assert.Nil(red)
+
+	flags = redactFlags{builtin: "email, ip"}
+	red, err = flags.redactor()
+	assert.NoError(err)
+	assert.Len(red.rules, 2)
+
+	tests := map[string]redactionConfig{
+		"UNKNOWN_REDACTION (phone), expected all or one of bearer_token, credit_card, email, ip, jwt": {Builtin: yamlStrings{"phone"}},
+		"rule 1: MISSING_NAME":              {Rules: []*redactionRule{{Match: "x"}}},
+		"rule 1: MISSING_MATCH":             {Rules: []*redactionRule{{Name: "x"}}},
+		"rule 1: INVALID_MATCH (()":         {Rules: []*redactionRule{{Name: "x", Match: "("}}},
+		"rule 1: INVALID_REPLACEMENT (a,b)": {Rules: []*redactionRule{{Name: "x", Match: "x", Replace: "a,b"}}},
+	}
+	for expected, config := range tests {
+		_, err := newRedactor(config)
+		assert.EqualError(err, expected)
+	}
+}
+
+func TestLoadRedactionConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	filename := filepath.Join(t.TempDir(), "redact.yaml")
+	assert.NoError(os.WriteFile(filename, []byte("builtin: email\nrules: [{name: order, match: 'ord-\\d+'}]\n"), 0644))
+	config, err := loadRedactionConfig(filename)
+	assert.NoError(err)
+	assert.Equal(yamlStrings{"email"}, config.Builtin)
+	assert.Equal(`ord-\d+`, config.Rules[0].Match)
+
+	assert.NoError(os.WriteFile(filename, []byte("rules: [{name: order, mtach: 'ord-\\d+'}]\n"), 0644))
+	_, err = loadRedactionConfig(filename)
+	assert.EqualError(err, "yaml: unmarshal errors:\n  line 1: field mtach not found in type main.redactionRule")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// remote-write metadata metric types
+const (
+	counterRemoteWriteType   = 1
+	gaugeRemoteWriteType     = 2
+	histogramRemoteWriteType = 3
+)
+
+type remoteWriteSample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+type remoteWriteSeries struct {
+	labels  []promLabel // including __name__, sorted
+	samples []remoteWriteSample
+}
+
+type remoteWriteMetadata struct {
+	metricType int
+	family     string
+	help       string
+}
+
+// WriteRequest, from prometheus/prompb/remote.proto
+type remoteWriteRequest struct {
+	series   []remoteWriteSeries
+	metadata []remoteWriteMetadata
+}
+
+func (r remoteWriteRequest) marshalProto() []byte {
+	b := &protoBuffer{}
+	for _, series := range r.series {
+		b.message(1, func(b *protoBuffer) {
+			for _, label := range series.labels {
+				b.message(1, func(b *protoBuffer) {
+					b.string(1, label.name)
+					b.string(2, label.value)
+				})
+			}
+			for _, sample := range series.samples {
+				b.message(2, func(b *protoBuffer) {
+					b.double(1, sample.value)
+					b.varint(2, sample.timestamp)
+				})
+			}
+		})
+	}
+	for _, m := range r.metadata {
+		b.message(3, func(b *protoBuffer) {
+			b.varint(1, int64(m.metricType))
+			b.string(2, m.family)
+			b.string(4, m.help)
+		})
+	}
+	return b.buf
+}
+
+// the running totals of a histogram series, weighted by sample rate
+type remoteWriteHistogram struct {
+	buckets []float64 // per upper bound, not yet cumulative
+	count   float64
+	sum     float64
+}
+
+// remoteWriteSink pushes aggregated series to Prometheus-compatible storage using the
+// remote-write protocol. As Prometheus expects, counters are cumulative (keeping a running total
+// per series) and so are histograms, which are built from every sample of timers, histograms
+// and distributions; their .count, .avg and other summaries aren't written
+type remoteWriteSink struct {
+	url     string
+	buckets []float64
+	client  *intakeClient
+
+	mu         sync.Mutex
+	counters   map[string]float64
+	histograms map[string]*remoteWriteHistogram
+	negatives  map[string]bool // counters already reported as going down
+}
+
+func newRemoteWriteSink(url string, buckets []float64, client *intakeClient) *remoteWriteSink {
+	return &remoteWriteSink{
+		url:        url,
+		buckets:    buckets,
+		client:     client,
+		counters:   map[string]float64{},
+		negatives:  map[string]bool{},
+		histograms: map[string]*remoteWriteHistogram{},
+	}
+}
+
+func (s *remoteWriteSink) String() string {
+	return s.url
+}
+
+// labels for a series: its sanitized name along with its tags, sorted by name
+func remoteWriteLabels(name string, tags []string, extra ...promLabel) []promLabel {
+	labels := append(promLabels(tags), extra...)
+	labels = append(labels, promLabel{name: "__name__", value: name})
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func (s *remoteWriteSink) request(series []aggregatedSeries) remoteWriteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req := remoteWriteRequest{}
+	families := map[string]bool{}
+	write := func(name string, tags []string, value float64, timestamp int64, extra ...promLabel) {
+		req.series = append(req.series, remoteWriteSeries{
+			labels:  remoteWriteLabels(name, tags, extra...),
+			samples: []remoteWriteSample{{value: value, timestamp: timestamp}},
+		})
+	}
+	describe := func(family string, metricType int, ser aggregatedSeries) {
+		if !families[family] {
+			families[family] = true
+			req.metadata = append(req.metadata, remoteWriteMetadata{
+				metricType: metricType,
+				family:     family,
+				help:       fmt.Sprintf("dogstatsd %s %s", ser.metricType.String(), ser.name),
+			})
+		}
+	}
+
+	for _, ser := range series {
+		name := promSanitize(ser.name, true)
+		key := name + "|" + strings.Join(ser.tags, ",")
+		timestamp := ser.timestamp.UnixMilli()
+
+		switch {
+		case ser.kind == histogramSeriesKind:
+			h, ok := s.histograms[key]
+			if !ok {
+				h = &remoteWriteHistogram{buckets: make([]float64, len(s.buckets))}
+				s.histograms[key] = h
+			}
+			for i, sample := range ser.samples.values {
+				weight := ser.samples.weights[i]
+				h.count += weight
+				h.sum += sample * weight
+				if j := sort.SearchFloat64s(s.buckets, sample); j < len(s.buckets) {
+					h.buckets[j] += weight
+				}
+			}
+
+			cumulative := 0.0
+			for i, bound := range s.buckets {
+				cumulative += h.buckets[i]
+				write(name+"_bucket", ser.tags, cumulative, timestamp, promLabel{name: "le", value: formatPromFloat(bound)})
+			}
+			write(name+"_bucket", ser.tags, h.count, timestamp, promLabel{name: "le", value: "+Inf"})
+			write(name+"_sum", ser.tags, h.sum, timestamp)
+			write(name+"_count", ser.tags, h.count, timestamp)
+			describe(name, histogramRemoteWriteType, ser)
+		case ser.metricType != dogstatsd.CounterMetricType && ser.metricType != dogstatsd.GaugeMetricType && ser.metricType != dogstatsd.SetMetricType:
+			// .count, .avg and the like, which the histograms already cover
+		case ser.kind == gaugeSeriesKind:
+			write(name, ser.tags, ser.value, timestamp)
+			describe(name, gaugeRemoteWriteType, ser)
+		default:
+			// counters are aggregated as rates, so scale them back up to a count for the interval
+			value := ser.value
+			if ser.kind == rateSeriesKind {
+				value *= ser.interval.Seconds()
+			}
+
+			// prometheus reads a counter going down as a reset, so intervals in which a counter
+			// was decremented overall leave its total as it was
+			if value < 0 {
+				if !s.negatives[ser.name] {
+					s.negatives[ser.name] = true
+					log.Printf("remote write: ignoring negative increments of counter %s", ser.name)
+				}
+				value = 0
+			}
+
+			name = strings.TrimSuffix(name, "_total")
+			s.counters[key] += value
+			write(name+"_total", ser.tags, s.counters[key], timestamp)
+			describe(name, counterRemoteWriteType, ser)
+		}
+	}
+
+	return req
+}
+
+func (s *remoteWriteSink) submit(series []aggregatedSeries) error {
+	req := s.request(series)
+	if len(req.series) == 0 {
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-protobuf")
+	header.Set("Content-Encoding", "snappy")
+	header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return s.client.postEncoded(s.url, header, snappyEncode(req.marshalProto()))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decode a remote-write request into one line per sample, like the text exposition format
+func decodeRemoteWrite(t *testing.T, body []byte) (samples []string, metadata []string) {
+	for _, f := range decodeProto(t, body) {
+		fields := decodeProto(t, f.bytes)
+
+		switch f.num {
+		case 1:
+			labels := []string{}
+			name := ""
+			for _, l := range protoFields(fields, 1) {
+				label := decodeProto(t, l.bytes)
+				key, value := string(protoFields(label, 1)[0].bytes), string(protoFields(label, 2)[0].bytes)
+				if key == "__name__" {
+					name = value
+				} else {
+					labels = append(labels, fmt.Sprintf("%s=%q", key, value))
+				}
+			}
+
+			for _, s := range protoFields(fields, 2) {
+				sample := decodeProto(t, s.bytes)
+				value := 0.0
+				if vs := protoFields(sample, 1); len(vs) > 0 {
+					value = math.Float64frombits(vs[0].value)
+				}
+				timestamp := protoFields(sample, 2)[0].value
+				samples = append(samples, fmt.Sprintf("%s{%s} %v %d", name, strings.Join(labels, ","), value, timestamp))
+			}
+		case 3:
+			metadata = append(metadata, fmt.Sprintf("%s %d %s",
+				protoFields(fields, 2)[0].bytes, protoFields(fields, 1)[0].value, protoFields(fields, 4)[0].bytes))
+		}
+	}
+
+	return samples, metadata
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	assert := assert.New(t)
+
+	intake := newTestIntake(t, http.StatusServiceUnavailable)
+	sink := newRemoteWriteSink(intake.URL+"/api/v1/write", []float64{10, 100}, newTestIntakeClient())
+
+	flush := func(now time.Time, msgs ...string) {
+		agg := newAggregator(10 * time.Second)
+		for _, msg := range msgs {
+			agg.handler([]byte(msg))
+		}
+		assert.NoError(sink.submit(agg.flush(now)))
+	}
+
+	flush(time.Unix(100, 0),
+		"page.views:10|c|#env:dev,le:5",
+		"requests_total:5|c|@0.5",
+		"fuel.level:0.5|g",
+		"api.latency:1:20|ms",
+		"api.latency:400|ms|@0.5",
+	)
+	flush(time.Unix(110, 0),
+		"page.views:1|c|#env:dev,le:5",
+		"api.latency:5|ms",
+	)
+
+	assert.Len(intake.requests, 3)
+	for _, r := range intake.requests {
+		assert.Equal("/api/v1/write", r.URL.Path)
+		assert.Equal("snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal("application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal("0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+	}
+
+	samples, metadata := decodeRemoteWrite(t, intake.bodies[1])
+	assert.Equal([]string{
+		`api_latency_bucket{le="10"} 1 100000`,
+		`api_latency_bucket{le="100"} 2 100000`,
+		`api_latency_bucket{le="+Inf"} 4 100000`,
+		`api_latency_sum{} 821 100000`,
+		`api_latency_count{} 4 100000`,
+		`fuel_level{} 0.5 100000`,
+		`page_views_total{env="dev",tag_le="5"} 10 100000`,
+		`requests_total{} 10 100000`,
+	}, samples)
+	assert.Equal([]string{
+		"api_latency 3 dogstatsd timer api.latency",
+		"fuel_level 2 dogstatsd gauge fuel.level",
+		"page_views 1 dogstatsd counter page.views",
+		"requests 1 dogstatsd counter requests_total",
+	}, metadata)
+
+	// counters and histograms keep adding up
+	samples, _ = decodeRemoteWrite(t, intake.bodies[2])
+	assert.Equal([]string{
+		`api_latency_bucket{le="10"} 2 110000`,
+		`api_latency_bucket{le="100"} 3 110000`,
+		`api_latency_bucket{le="+Inf"} 5 110000`,
+		`api_latency_sum{} 826 110000`,
+		`api_latency_count{} 5 110000`,
+		`page_views_total{env="dev",tag_le="5"} 11 110000`,
+	}, samples)
+}
+
+func TestRemoteWriteSinkNegativeCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	intake := newTestIntake(t)
+	sink := newRemoteWriteSink(intake.URL+"/api/v1/write", nil, newTestIntakeClient())
+
+	for i, msgs := range [][]string{
+		{"page.views:5|c"},
+		{"page.views:-3|c", "page.views:1|c"},
+		{"page.views:-1|c"},
+		{"page.views:2|c"},
+	} {
+		agg := newAggregator(10 * time.Second)
+		for _, msg := range msgs {
+			agg.handler([]byte(msg))
+		}
+		assert.NoError(sink.submit(agg.flush(time.Unix(int64(100+10*i), 0))))
+	}
+
+	// the total never goes down, and decrements are reported once
+	totals := []string{}
+	for _, body := range intake.bodies {
+		samples, _ := decodeRemoteWrite(t, body)
+		totals = append(totals, samples...)
+	}
+	assert.Equal([]string{
+		"page_views_total{} 5 100000",
+		"page_views_total{} 5 110000",
+		"page_views_total{} 5 120000",
+		"page_views_total{} 7 130000",
+	}, totals)
+	assert.Equal(1, strings.Count(logged.String(), "ignoring negative increments of counter page.views"))
+}
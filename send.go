@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// dial a dogstatsd target: host:port or udp://host:port, unix:///path/to.sock for a datagram
+// unix socket, or tcp://host:port. Stream connections need each packet newline-terminated
+func dialTarget(target string) (conn net.Conn, stream bool, err error) {
+	network, addr, ok := strings.Cut(target, "://")
+	if !ok {
+		network, addr = "udp", target
+	}
+
+	switch network {
+	case "udp":
+	case "unix", "unixgram":
+		network = "unixgram"
+	case "tcp":
+		stream = true
+	default:
+		return nil, false, fmt.Errorf("INVALID_TARGET (%s)", target)
+	}
+
+	conn, err = net.Dial(network, addr)
+	return conn, stream, err
+}
+
+// the message to build from flags rather than reading datagrams
+type sendOptions struct {
+	metric       string
+	metricType   string
+	value        string
+	sampleRate   float64
+	event        string
+	text         string
+	alertType    string
+	priority     string
+	aggKey       string
+	sourceType   string
+	serviceCheck string
+	status       string
+	message      string
+	hostname     string
+	tags         []string
+}
+
+var eventPriorityNames = map[string]dogstatsd.EventPriority{
+	"":       dogstatsd.NormalEventPriority,
+	"normal": dogstatsd.NormalEventPriority,
+	"low":    dogstatsd.LowEventPriority,
+}
+
+var eventAlertTypeNames = map[string]dogstatsd.EventAlertType{
+	"":        dogstatsd.InfoEventAlertType,
+	"info":    dogstatsd.InfoEventAlertType,
+	"success": dogstatsd.SuccessEventAlertType,
+	"warning": dogstatsd.WarningEventAlertType,
+	"error":   dogstatsd.ErrorEventAlertType,
+}
+
+// build a message from whichever of metric, event or service check was given, returning nil
+// if none were
+func (o *sendOptions) msg() (dogstatsd.Msg, error) {
+	switch {
+	case o.metric != "" && o.event == "" && o.serviceCheck == "":
+		metricType, ok := metricTypeNames[strings.ToLower(o.metricType)]
+		if !ok {
+			return nil, fmt.Errorf("INVALID_TYPE (%s)", o.metricType)
+		}
+
+		metric := dogstatsd.Metric{
+			Name:       o.metric,
+			MetricType: metricType,
+			SampleRate: o.sampleRate,
+			Tags:       o.tags,
+		}
+		for _, raw := range strings.Split(o.value, ":") {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("INVALID_VALUE (%s)", raw)
+			}
+			metric.Values = append(metric.Values, dogstatsd.MetricValue{Raw: raw, Numeric: value})
+		}
+
+		return metric, nil
+	case o.event != "" && o.metric == "" && o.serviceCheck == "":
+		priority, ok := eventPriorityNames[strings.ToLower(o.priority)]
+		if !ok {
+			return nil, fmt.Errorf("INVALID_PRIORITY (%s)", o.priority)
+		}
+
+		alertType, ok := eventAlertTypeNames[strings.ToLower(o.alertType)]
+		if !ok {
+			return nil, fmt.Errorf("INVALID_ALERT_TYPE (%s)", o.alertType)
+		}
+
+		return dogstatsd.Event{
+			Title:          o.event,
+			Text:           o.text,
+			Hostname:       o.hostname,
+			AggregationKey: o.aggKey,
+			Priority:       priority,
+			SourceType:     o.sourceType,
+			AlertType:      alertType,
+			Tags:           o.tags,
+		}, nil
+	case o.serviceCheck != "" && o.metric == "" && o.event == "":
+		status, ok := serviceCheckStatusNames[strings.ToLower(o.status)]
+		if !ok {
+			return nil, fmt.Errorf("INVALID_STATUS (%s)", o.status)
+		}
+
+		return dogstatsd.ServiceCheck{
+			Name:     o.serviceCheck,
+			Status:   status,
+			Hostname: o.hostname,
+			Tags:     o.tags,
+			Message:  o.message,
+		}, nil
+	case o.metric == "" && o.event == "" && o.serviceCheck == "":
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("ONLY_ONE_OF_METRIC_EVENT_OR_SERVICE_CHECK")
+}
+
+// build the datagram for the message given by flags, returning "" if there was none
+func (o *sendOptions) datagram() (string, error) {
+	dMsg, err := o.msg()
+	if err != nil || dMsg == nil {
+		return "", err
+	}
+
+	data, err := dogstatsd.Encode(dMsg)
+	return string(data), err
+}
+
+// read datagrams one per line, skipping blank lines
+func readDatagrams(r io.Reader) ([]string, error) {
+	datagrams := []string{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			datagrams = append(datagrams, line)
+		}
+	}
+
+	return datagrams, scanner.Err()
+}
+
+// check every datagram parses, returning an error describing each one which doesn't
+func validateDatagrams(datagrams []string) ([]dogstatsd.Msg, error) {
+	msgs := make([]dogstatsd.Msg, 0, len(datagrams))
+	invalid := []string{}
+
+	for _, datagram := range datagrams {
+		dMsg, err := dogstatsd.Parse([]byte(datagram))
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%q: %s", datagram, err.Error()))
+			continue
+		}
+		if !utf8.ValidString(datagram) {
+			invalid = append(invalid, fmt.Sprintf("%q: INVALID_UTF8", datagram))
+			continue
+		}
+
+		msgs = append(msgs, dMsg)
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid packets:\n  %s", strings.Join(invalid, "\n  "))
+	}
+
+	return msgs, nil
+}
+
+// send subcommand: build or read datagrams, check they parse and send them to a target
+func runSend(args []string) int {
+	opts := sendOptions{}
+	var tags stringsFlag
+
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	target := flags.String("target", "127.0.0.1:8125", "where to send: host:port, udp://host:port, unix:///path/to.sock or tcp://host:port")
+	flags.StringVar(&opts.metric, "metric", "", "send a metric with this name")
+	flags.StringVar(&opts.metricType, "type", "c", "metric type: c|g|s|ms|h|d")
+	flags.StringVar(&opts.value, "value", "1", "metric value, or several separated by colons")
+	flags.Float64Var(&opts.sampleRate, "sample-rate", 1, "-metric sample rate; like a client library, only this fraction of its packets are sent")
+	flags.StringVar(&opts.event, "event", "", "send an event with this title")
+	flags.StringVar(&opts.text, "text", "", "event text")
+	flags.StringVar(&opts.alertType, "alert-type", "", "event alert type: info|success|warning|error")
+	flags.StringVar(&opts.priority, "priority", "", "event priority: normal|low")
+	flags.StringVar(&opts.aggKey, "aggregation-key", "", "event aggregation key")
+	flags.StringVar(&opts.sourceType, "source-type", "", "event source type")
+	flags.StringVar(&opts.serviceCheck, "service-check", "", "send a service check with this name")
+	flags.StringVar(&opts.status, "status", "ok", "service check status: ok|warning|critical|unknown")
+	flags.StringVar(&opts.message, "message", "", "service check message")
+	flags.StringVar(&opts.hostname, "hostname", "", "event or service check hostname")
+	flags.Var(&tags, "tag", "tag to add (repeatable)")
+	repeat := flags.Int("repeat", 1, "how many times to send each packet")
+	rate := flags.Float64("rate", 0, "packets per second (0 sends as fast as possible)")
+	quiet := flags.Bool("quiet", false, "don't print each packet sent")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s send [flags] [datagram ...]\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Builds a packet from -metric, -event or -service-check, or sends the given datagrams\n")
+		fmt.Fprintf(flags.Output(), "(read from stdin, one per line, if there are none).\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	opts.tags = tags
+
+	if *repeat < 1 || *rate < 0 || opts.sampleRate <= 0 || opts.sampleRate > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	datagram, err := opts.datagram()
+	if err != nil {
+		log.Println(err.Error())
+		return 2
+	}
+
+	var datagrams []string
+	switch {
+	case datagram != "" && flags.NArg() > 0:
+		log.Println("datagrams can't be given along with -metric, -event or -service-check")
+		return 2
+	case datagram != "":
+		datagrams = []string{datagram}
+	case flags.NArg() > 0:
+		datagrams = flags.Args()
+	default:
+		if datagrams, err = readDatagrams(os.Stdin); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+	}
+
+	msgs, err := validateDatagrams(datagrams)
+	if err != nil {
+		log.Println(err.Error())
+		return 2
+	}
+
+	for _, dMsg := range msgs {
+		if name, violations := lintDogstatsdMsg(dMsg); len(violations) > 0 {
+			log.Printf("WARNING: %s %s: %s", dMsg.Type().String(), name, strings.Join(violations, "; "))
+		}
+	}
+
+	conn, stream, err := dialTarget(*target)
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	defer conn.Close()
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// only the metric built from -metric is sampled; given datagrams carry their own rates
+	sampleRate := 1.0
+	if opts.metric != "" {
+		sampleRate = opts.sampleRate
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	start := time.Now()
+	sent, sampledOut, bytes := 0, 0, 0
+	printed := make([]bool, len(datagrams))
+
+send:
+	for i := 0; i < *repeat; i++ {
+		for j, datagram := range datagrams {
+			if tick != nil && sent+sampledOut > 0 {
+				select {
+				case <-tick:
+				case <-stopCh:
+					break send
+				}
+			} else {
+				select {
+				case <-stopCh:
+					break send
+				default:
+				}
+			}
+
+			if sampleRate < 1 && random.Float64() >= sampleRate {
+				sampledOut++
+				continue
+			}
+
+			packet := datagram
+			if stream {
+				packet += "\n"
+			}
+
+			if _, err := conn.Write([]byte(packet)); err != nil {
+				log.Println("send error:", err.Error())
+				return 1
+			}
+
+			if !printed[j] && !*quiet {
+				log.Println("sent:", datagram)
+				printed[j] = true
+			}
+			sent++
+			bytes += len(packet)
+		}
+	}
+
+	report := fmt.Sprintf("sent %d packets (%d bytes) to %s in %s", sent, bytes, *target, time.Since(start).Truncate(time.Millisecond))
+	if sampledOut > 0 {
+		report += fmt.Sprintf(", %d sampled out", sampledOut)
+	}
+	log.Println(report)
+
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/anujdas/dogstatsd-local/dogstatsdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendOptionsDatagram(t *testing.T) {
+	var tests = []struct {
+		name     string
+		opts     sendOptions
+		datagram string
+		err      string
+	}{
+		{"none", sendOptions{}, "", ""},
+		{"counter", sendOptions{metric: "page.views", metricType: "c", value: "1", sampleRate: 1}, "page.views:1|c", ""},
+		{"timer", sendOptions{metric: "api.latency", metricType: "timer", value: "12:15", sampleRate: 0.5, tags: []string{"env:dev", "a"}}, "api.latency:12:15|ms|@0.5|#env:dev,a", ""},
+		{"bad value", sendOptions{metric: "x", metricType: "c", value: "1:two", sampleRate: 1}, "", "INVALID_VALUE (two)"},
+		{"bad tag", sendOptions{metric: "x", metricType: "c", value: "1", sampleRate: 1, tags: []string{"a,b"}}, "", `UNENCODABLE_TAG ("a,b")`},
+		{"bad type", sendOptions{metric: "x", metricType: "q", value: "1", sampleRate: 1}, "", "INVALID_TYPE (q)"},
+		{"event", sendOptions{event: "Déploy", text: "line 1\nline 2", alertType: "error", priority: "low", tags: []string{"env:dev"}}, `_e{7,14}:Déploy|line 1\nline 2|p:low|t:error|#env:dev`, ""},
+		{"service check", sendOptions{serviceCheck: "db", status: "critical", hostname: "h1", message: "down", tags: []string{"env:dev"}}, "_sc|db|2|h:h1|#env:dev|m:down", ""},
+		{"bad priority", sendOptions{event: "x", priority: "urgent"}, "", "INVALID_PRIORITY (urgent)"},
+		{"bad status", sendOptions{serviceCheck: "db", status: "bad"}, "", "INVALID_STATUS (bad)"},
+		{"both", sendOptions{metric: "x", event: "y"}, "", "ONLY_ONE_OF_METRIC_EVENT_OR_SERVICE_CHECK"},
+	}
+
+	assert := assert.New(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datagram, err := tt.opts.datagram()
+			if tt.err != "" {
+				assert.EqualError(err, tt.err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.datagram, datagram)
+			if datagram != "" {
+				_, err := dogstatsd.Parse([]byte(datagram))
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func TestValidateDatagrams(t *testing.T) {
+	datagrams, err := readDatagrams(strings.NewReader("page.views:1|c\n\n_sc|db|0\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"page.views:1|c", "_sc|db|0"}, datagrams)
+
+	msgs, err := validateDatagrams(datagrams)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+
+	_, err = validateDatagrams([]string{"page.views:1|c", "page.views|c", "page.views:x|c"})
+	assert.EqualError(t, err, "invalid packets:\n"+
+		`  "page.views|c": INVALID_MSG_MISSING_NAME_AND_VALUE (page.views)`+"\n"+
+		`  "page.views:x|c": INVALID_MSG_INVALID_VALUE (x)`)
+}
+
+func TestDialTarget(t *testing.T) {
+	server := dogstatsdtest.NewServer(t)
+
+	conn, stream, err := dialTarget("udp://" + server.Addr())
+	assert.NoError(t, err)
+	assert.False(t, stream)
+	conn.Write([]byte("page.views:1|c"))
+	conn.Close()
+	server.AssertCounterSum(t, "page.views", 1)
+
+	sock := filepath.Join(t.TempDir(), "dsd.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	conn, stream, err = dialTarget("unix://" + sock)
+	assert.NoError(t, err)
+	assert.False(t, stream)
+	conn.Write([]byte("page.views:1|c"))
+	conn.Close()
+
+	buf := make([]byte, 64)
+	n, err := listener.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "page.views:1|c", string(buf[:n]))
+
+	_, _, err = dialTarget("http://localhost")
+	assert.EqualError(t, err, "INVALID_TARGET (http://localhost)")
+}
+
+func TestRunSendSampleRate(t *testing.T) {
+	server := dogstatsdtest.NewServer(t)
+
+	// raw datagrams are sent as given, whatever the sample rate
+	assert.Equal(t, 0, runSend([]string{"-target", server.Addr(), "-sample-rate", "0.01", "-repeat", "20", "-quiet", "page.views:1|c"}))
+	server.AssertCounterSum(t, "page.views", 20)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// https://docs.datadoghq.com/api/latest/metrics/#submit-metrics
+const (
+	seriesPath = "/api/v2/series"
+
+	// series per request, keeping payloads well inside the intake's size limits
+	seriesBatchSize = 1000
+)
+
+type seriesPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type seriesResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type seriesMetric struct {
+	Metric    string           `json:"metric"`
+	Type      int              `json:"type"`
+	Points    []seriesPoint    `json:"points"`
+	Interval  int64            `json:"interval,omitempty"`
+	Tags      []string         `json:"tags,omitempty"`
+	Resources []seriesResource `json:"resources,omitempty"`
+}
+
+type seriesPayload struct {
+	Series []seriesMetric `json:"series"`
+}
+
+// metric intake types: 0 is unspecified
+var seriesTypes = map[seriesKind]int{
+	countSeriesKind: 1,
+	rateSeriesKind:  2,
+	gaugeSeriesKind: 3,
+}
+
+// seriesApiSink submits aggregated series to a Datadog-compatible /api/v2/series endpoint.
+// Histograms are skipped, as they're also summarized as .count, .avg and so on
+type seriesApiSink struct {
+	url      string
+	hostname string
+	client   *intakeClient
+}
+
+func newSeriesApiSink(base string, hostname string, client *intakeClient) *seriesApiSink {
+	return &seriesApiSink{
+		url:      intakeUrl(base, seriesPath),
+		hostname: hostname,
+		client:   client,
+	}
+}
+
+func (s *seriesApiSink) String() string {
+	return s.url
+}
+
+func (s *seriesApiSink) payload(series []aggregatedSeries) seriesPayload {
+	payload := seriesPayload{Series: make([]seriesMetric, 0, len(series))}
+	for _, ser := range series {
+		metric := seriesMetric{
+			Metric: ser.name,
+			Type:   seriesTypes[ser.kind],
+			Points: []seriesPoint{{Timestamp: ser.timestamp.Unix(), Value: ser.value}},
+			Tags:   ser.tags,
+		}
+
+		if ser.kind != gaugeSeriesKind {
+			metric.Interval = int64(math.Round(ser.interval.Seconds()))
+		}
+
+		if s.hostname != "" {
+			metric.Resources = []seriesResource{{Name: s.hostname, Type: "host"}}
+		}
+
+		payload.Series = append(payload.Series, metric)
+	}
+
+	return payload
+}
+
+func (s *seriesApiSink) submit(all []aggregatedSeries) error {
+	series := make([]aggregatedSeries, 0, len(all))
+	for _, ser := range all {
+		if ser.kind != histogramSeriesKind {
+			series = append(series, ser)
+		}
+	}
+
+	for start := 0; start < len(series); start += seriesBatchSize {
+		end := start + seriesBatchSize
+		if end > len(series) {
+			end = len(series)
+		}
+
+		if err := s.client.postJson(s.url, s.payload(series[start:end])); err != nil {
+			return fmt.Errorf("%d series not submitted: %s", len(series)-start, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a stand-in intake recording the decompressed body of each request, failing the first few
+type testIntake struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures []int
+	requests []*http.Request
+	bodies   []json.RawMessage
+}
+
+func newTestIntake(t *testing.T, failures ...int) *testIntake {
+	intake := &testIntake{failures: failures}
+	intake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		intake.mu.Lock()
+		defer intake.mu.Unlock()
+
+		intake.requests = append(intake.requests, r)
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			assert.NoError(t, err)
+			body, err = io.ReadAll(gz)
+			assert.NoError(t, err)
+		case "snappy":
+			body, err = snappyDecode(body)
+			assert.NoError(t, err)
+		}
+		if r.Header.Get("Content-Type") == "application/json" {
+			assert.True(t, json.Valid(body), string(body))
+		}
+		intake.bodies = append(intake.bodies, body)
+
+		if len(intake.failures) > 0 {
+			status := intake.failures[0]
+			intake.failures = intake.failures[1:]
+			http.Error(w, `{"errors":["nope"]}`, status)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(intake.Close)
+
+	return intake
+}
+
+func newTestIntakeClient() *intakeClient {
+	client := newIntakeClient("key")
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestIntakeClientRetries(t *testing.T) {
+	intake := newTestIntake(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	assert.NoError(t, newTestIntakeClient().postJson(intake.URL+"/x", map[string]int{"a": 1}))
+	assert.Len(t, intake.requests, 3)
+
+	for _, r := range intake.requests {
+		assert.Equal(t, "/x", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("DD-API-KEY"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	}
+	assert.JSONEq(t, `{"a":1}`, string(intake.bodies[2]))
+
+	// client errors aren't retried
+	intake = newTestIntake(t, http.StatusForbidden)
+	err := newTestIntakeClient().postJson(intake.URL, 1)
+	assert.EqualError(t, err, "INTAKE_ERROR ("+intake.URL+`: 403 Forbidden {"errors":["nope"]})`)
+	assert.Len(t, intake.requests, 1)
+
+	// and retries give up eventually
+	intake = newTestIntake(t, 500, 500, 500, 500, 500)
+	assert.Error(t, newTestIntakeClient().postJson(intake.URL, 1))
+	assert.Len(t, intake.requests, 4)
+}
+
+func TestSeriesApiSink(t *testing.T) {
+	intake := newTestIntake(t)
+	sink := newSeriesApiSink(intake.URL+"/", "host1", newTestIntakeClient())
+
+	agg := newAggregator(10 * time.Second)
+	agg.handler([]byte("page.views:10|c|#env:dev"))
+	agg.handler([]byte("fuel.level:0.5|g"))
+	assert.NoError(t, sink.submit(agg.flush(time.Unix(100, 0))))
+
+	assert.Len(t, intake.requests, 1)
+	assert.Equal(t, seriesPath, intake.requests[0].URL.Path)
+	assert.JSONEq(t, `{"series": [
+		{"metric": "fuel.level", "type": 3, "points": [{"timestamp": 100, "value": 0.5}], "resources": [{"name": "host1", "type": "host"}]},
+		{"metric": "page.views", "type": 2, "points": [{"timestamp": 100, "value": 1}], "interval": 10, "tags": ["env:dev"], "resources": [{"name": "host1", "type": "host"}]}
+	]}`, string(intake.bodies[0]))
+}
@@ -0,0 +1,105 @@
+package main
+
+import "encoding/binary"
+
+// https://github.com/google/snappy/blob/main/format_description.txt
+const (
+	snappyLiteralTag = 0
+	snappyCopy1Tag   = 1 // 1 byte offset, 4-11 byte length
+	snappyCopy2Tag   = 2 // 2 byte offset, 1-64 byte length
+
+	// input is compressed in blocks of this size, so copy offsets always fit in 2 bytes
+	snappyBlockSize = 1 << 16
+	snappyTableBits = 14
+)
+
+// snappyEncode compresses src in the snappy block format (not the framed stream format), as the
+// Prometheus remote-write protocol requires. It finds matches greedily with a hash of the next 4
+// bytes, which compresses less than the reference implementation but is always valid
+func snappyEncode(src []byte) []byte {
+	dst := appendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+
+	for start := 0; start < len(src); start += snappyBlockSize {
+		end := start + snappyBlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		dst = snappyEncodeBlock(dst, src, start, end)
+	}
+
+	return dst
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyTableBits)
+}
+
+func snappyEncodeBlock(dst []byte, src []byte, start int, end int) []byte {
+	// positions (plus one, so zero is empty) of the last 4 bytes seen with each hash
+	var table [1 << snappyTableBits]int32
+
+	literal := start
+	for i := start; i+4 <= end; {
+		u := binary.LittleEndian.Uint32(src[i:])
+		h := snappyHash(u)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < start || binary.LittleEndian.Uint32(src[candidate:]) != u {
+			i++
+			continue
+		}
+
+		dst = snappyAppendLiteral(dst, src[literal:i])
+
+		length := 4
+		for i+length < end && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyAppendCopy(dst, i-candidate, length)
+
+		i += length
+		literal = i
+	}
+
+	return snappyAppendLiteral(dst, src[literal:end])
+}
+
+func snappyAppendLiteral(dst []byte, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyLiteralTag)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyLiteralTag, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyLiteralTag, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyLiteralTag, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyLiteralTag, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, lit...)
+}
+
+func snappyAppendCopy(dst []byte, offset int, length int) []byte {
+	// long matches are split into copies of at most 64 bytes, never leaving fewer than 4
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyCopy2Tag, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyCopy2Tag, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+
+	if length <= 11 && offset < 2048 {
+		return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyCopy1Tag, byte(offset))
+	}
+	return append(dst, byte(length-1)<<2|snappyCopy2Tag, byte(offset), byte(offset>>8))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decode the snappy block format
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid length")
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case snappyLiteralTag:
+			n := int(tag >> 2)
+			src = src[1:]
+			if n >= 60 {
+				size := n - 59
+				if len(src) < size {
+					return nil, fmt.Errorf("short literal length")
+				}
+				n = 0
+				for i := size - 1; i >= 0; i-- {
+					n = n<<8 | int(src[i])
+				}
+				src = src[size:]
+			}
+			n++
+			if len(src) < n {
+				return nil, fmt.Errorf("short literal")
+			}
+			dst = append(dst, src[:n]...)
+			src = src[n:]
+		case snappyCopy1Tag, snappyCopy2Tag:
+			var length, offset int
+			if tag&3 == snappyCopy1Tag {
+				if len(src) < 2 {
+					return nil, fmt.Errorf("short copy")
+				}
+				length = int(tag>>2&7) + 4
+				offset = int(tag>>5)<<8 | int(src[1])
+				src = src[2:]
+			} else {
+				if len(src) < 3 {
+					return nil, fmt.Errorf("short copy")
+				}
+				length = int(tag>>2) + 1
+				offset = int(binary.LittleEndian.Uint16(src[1:]))
+				src = src[3:]
+			}
+			if offset == 0 || offset > len(dst) {
+				return nil, fmt.Errorf("invalid offset %d", offset)
+			}
+			for i := 0; i < length; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+		default:
+			return nil, fmt.Errorf("unexpected 4 byte offset copy")
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("decoded %d bytes, expected %d", len(dst), length)
+	}
+	return dst, nil
+}
+
+func TestSnappyEncode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("00", hex.EncodeToString(snappyEncode(nil)))
+	assert.Equal("051068656c6c6f", hex.EncodeToString(snappyEncode([]byte("hello"))))
+	// a literal "abcd", then a copy of 8 bytes from 4 back
+	assert.Equal("0c0c61626364"+"1104", hex.EncodeToString(snappyEncode([]byte("abcdabcdabcd"))))
+
+	inputs := [][]byte{
+		bytes.Repeat([]byte("a"), 100000),
+		bytes.Repeat([]byte("page.views:1|c|#env:dev\n"), 10000),
+		make([]byte, 3*snappyBlockSize+5),
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []int{59, 60, 61, 300, 70000} {
+		input := make([]byte, size)
+		random.Read(input)
+		inputs = append(inputs, input)
+	}
+
+	for _, input := range inputs {
+		encoded := snappyEncode(input)
+		decoded, err := snappyDecode(encoded)
+		assert.NoError(err)
+		assert.True(bytes.Equal(input, decoded), "round trip of %d bytes", len(input))
+	}
+
+	// repetitive input actually compresses
+	assert.Less(len(snappyEncode(inputs[1])), len(inputs[1])/10)
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	roundTrips := func(input []byte, repeat uint8) bool {
+		input = bytes.Repeat(input, int(repeat%8)+1)
+		decoded, err := snappyDecode(snappyEncode(input))
+		return err == nil && bytes.Equal(input, decoded)
+	}
+
+	assert.NoError(t, quick.Check(roundTrips, nil))
+}
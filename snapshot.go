@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+// snapshotter normalizes the received stream into one line per distinct message context
+// (sorted, with timestamps and values stripped and repeats collapsed into a count) so that
+// it can be compared against a golden file
+type snapshotter struct {
+	mu       sync.Mutex
+	contexts map[string]int
+}
+
+func newSnapshotter() *snapshotter {
+	return &snapshotter{
+		contexts: map[string]int{},
+	}
+}
+
+func snapshotTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	return " #" + strings.Join(sorted, ",")
+}
+
+// snapshotContext normalizes a message into its snapshot line, without the count
+func snapshotContext(dMsg dogstatsd.Msg) string {
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		context := fmt.Sprintf("metric %s %s", msg.MetricType.String(), msg.Name)
+		if msg.SampleRate != 1 {
+			context += fmt.Sprintf(" @%g", msg.SampleRate)
+		}
+		return context + snapshotTags(msg.Tags)
+	case dogstatsd.Event:
+		return fmt.Sprintf("event %q %s %s", msg.Title, msg.AlertType.String(), msg.Priority.String()) + snapshotTags(msg.Tags)
+	case dogstatsd.ServiceCheck:
+		return fmt.Sprintf("service_check %s %s", msg.Name, msg.Status.String()) + snapshotTags(msg.Tags)
+	}
+
+	return ""
+}
+
+func (s *snapshotter) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.contexts[snapshotContext(dMsg)]++
+	return nil
+}
+
+func (s *snapshotter) lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]string, 0, len(s.contexts))
+	for context, count := range s.contexts {
+		lines = append(lines, fmt.Sprintf("%s x%d", context, count))
+	}
+	sort.Strings(lines)
+
+	return lines
+}
+
+// compare the snapshot against the golden file, returning a unified diff of the changes, or
+// rewrite the golden file if update is set
+func (s *snapshotter) compare(golden string, update bool) (string, error) {
+	received := s.lines()
+
+	if update {
+		return "", os.WriteFile(golden, []byte(strings.Join(received, "\n")+"\n"), 0644)
+	}
+
+	contents, err := os.ReadFile(golden)
+	if err != nil {
+		return "", err
+	}
+
+	expected := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line != "" {
+			expected = append(expected, line)
+		}
+	}
+
+	return unifiedDiff(golden, "received", expected, received, 3), nil
+}
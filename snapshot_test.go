@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotCompare(t *testing.T) {
+	assert := assert.New(t)
+	golden := filepath.Join(t.TempDir(), "golden.txt")
+
+	s := newSnapshotter()
+	for _, msg := range []string{
+		"page.views:1|c|#b:2,a:1",
+		"page.views:7|c|#a:1,b:2",
+		"page.views:1|c|@0.5",
+		"fuel.level:0.5|g",
+		"_e{5,5}:Error|Error|t:error|d:10",
+		"_sc|db|2|#env:dev",
+		"not a metric",
+	} {
+		assert.NoError(s.handler([]byte(msg)))
+	}
+
+	diff, err := s.compare(golden, true)
+	assert.NoError(err)
+	assert.Empty(diff)
+
+	contents, err := os.ReadFile(golden)
+	assert.NoError(err)
+	assert.Equal(`event "Error" error normal x1
+metric counter page.views #a:1,b:2 x2
+metric counter page.views @0.5 x1
+metric gauge fuel.level x1
+service_check db CRITICAL #env:dev x1
+`, string(contents))
+
+	diff, err = s.compare(golden, false)
+	assert.NoError(err)
+	assert.Empty(diff)
+
+	assert.NoError(s.handler([]byte("page.views:1|c|#a:1,b:2")))
+	assert.NoError(s.handler([]byte("fuel.level:0.5|g|#new")))
+
+	diff, err = s.compare(golden, false)
+	assert.NoError(err)
+	assert.Equal("--- "+golden+`
++++ received
+@@ -1,5 +1,6 @@
+ event "Error" error normal x1
+-metric counter page.views #a:1,b:2 x2
++metric counter page.views #a:1,b:2 x3
+ metric counter page.views @0.5 x1
++metric gauge fuel.level #new x1
+ metric gauge fuel.level x1
+ service_check db CRITICAL #env:dev x1
+`, diff)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+type summaryMetric struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Packets int64   `json:"packets"`
+	Values  int64   `json:"values"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Mean    float64 `json:"mean"`
+	Sum     float64 `json:"sum"`
+	TagSets int     `json:"tag_combinations"`
+
+	tagSets map[string]struct{}
+}
+
+type summaryReport struct {
+	Start         time.Time        `json:"start"`
+	End           time.Time        `json:"end"`
+	Packets       int64            `json:"packets"`
+	ParseErrors   int64            `json:"parse_errors"`
+	Dropped       int64            `json:"dropped"`
+	Events        int64            `json:"events"`
+	ServiceChecks int64            `json:"service_checks"`
+	Metrics       []*summaryMetric `json:"metrics"`
+}
+
+// summary accumulates statistics for everything received during a session
+type summary struct {
+	mu     sync.Mutex
+	report summaryReport
+
+	metrics map[string]*summaryMetric
+}
+
+func newSummary() *summary {
+	return &summary{
+		report:  summaryReport{Start: time.Now()},
+		metrics: map[string]*summaryMetric{},
+	}
+}
+
+func (s *summary) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Packets++
+	if err != nil {
+		s.report.ParseErrors++
+		return nil
+	}
+
+	switch dMsg.Type() {
+	case dogstatsd.EventMsgType:
+		s.report.Events++
+		return nil
+	case dogstatsd.ServiceCheckMsgType:
+		s.report.ServiceChecks++
+		return nil
+	}
+
+	metric, _ := dMsg.(dogstatsd.Metric)
+	key := metric.Name + "|" + metric.MetricType.String()
+	sm, ok := s.metrics[key]
+	if !ok {
+		sm = &summaryMetric{
+			Name:    metric.Name,
+			Type:    metric.MetricType.String(),
+			Min:     math.Inf(1),
+			Max:     math.Inf(-1),
+			tagSets: map[string]struct{}{},
+		}
+		s.metrics[key] = sm
+	}
+
+	sm.Packets++
+	for _, value := range metric.Values {
+		sm.Values++
+		sm.Sum += value.Numeric
+		sm.Min = math.Min(sm.Min, value.Numeric)
+		sm.Max = math.Max(sm.Max, value.Numeric)
+	}
+
+	tags := make([]string, len(metric.Tags))
+	copy(tags, metric.Tags)
+	sort.Strings(tags)
+	sm.tagSets[strings.Join(tags, ",")] = struct{}{}
+
+	return nil
+}
+
+// dropHandler wraps the pool's submit function so that rejected messages are counted
+func (s *summary) dropHandler(fn msgHandler) msgHandler {
+	return func(msg []byte) error {
+		err := fn(msg)
+		if err != nil {
+			s.mu.Lock()
+			s.report.Dropped++
+			s.mu.Unlock()
+		}
+
+		return err
+	}
+}
+
+func (s *summary) snapshot() summaryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := s.report
+	report.End = time.Now()
+	report.Metrics = make([]*summaryMetric, 0, len(s.metrics))
+	for _, sm := range s.metrics {
+		m := *sm
+		m.TagSets = len(sm.tagSets)
+		if m.Values > 0 {
+			m.Mean = m.Sum / float64(m.Values)
+		} else {
+			m.Min, m.Max = 0, 0
+		}
+		report.Metrics = append(report.Metrics, &m)
+	}
+
+	sort.Slice(report.Metrics, func(i, j int) bool {
+		if report.Metrics[i].Name != report.Metrics[j].Name {
+			return report.Metrics[i].Name < report.Metrics[j].Name
+		}
+		return report.Metrics[i].Type < report.Metrics[j].Type
+	})
+
+	return report
+}
+
+// write the summary to dest: "-" prints it as text to stderr, otherwise it is written to
+// the named file as JSON (.json), Markdown (.md) or text
+func (s *summary) write(dest string) error {
+	report := s.snapshot()
+
+	if dest == "-" {
+		return writeTextSummary(os.Stderr, report)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(dest)) {
+	case ".json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&report)
+	case ".md", ".markdown":
+		return writeMarkdownSummary(f, report)
+	}
+
+	return writeTextSummary(f, report)
+}
+
+func writeTextSummary(w io.Writer, report summaryReport) error {
+	fmt.Fprintf(w, "session summary: %s to %s (%s)\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339), report.End.Sub(report.Start).Truncate(time.Second))
+	fmt.Fprintf(w, "packets: %d, parse errors: %d, dropped: %d, events: %d, service checks: %d\n\n", report.Packets, report.ParseErrors, report.Dropped, report.Events, report.ServiceChecks)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tPACKETS\tMIN\tMAX\tMEAN\tSUM\tTAG SETS")
+	for _, m := range report.Metrics {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%d\n", m.Name, m.Type, m.Packets, m.Min, m.Max, m.Mean, m.Sum, m.TagSets)
+	}
+
+	return tw.Flush()
+}
+
+func writeMarkdownSummary(w io.Writer, report summaryReport) error {
+	fmt.Fprintf(w, "# dogstatsd-local session summary\n\n")
+	fmt.Fprintf(w, "%s to %s (%s)\n\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339), report.End.Sub(report.Start).Truncate(time.Second))
+	fmt.Fprintf(w, "| Packets | Parse errors | Dropped | Events | Service checks |\n")
+	fmt.Fprintf(w, "|---:|---:|---:|---:|---:|\n")
+	fmt.Fprintf(w, "| %d | %d | %d | %d | %d |\n\n", report.Packets, report.ParseErrors, report.Dropped, report.Events, report.ServiceChecks)
+
+	fmt.Fprintf(w, "## Metrics\n\n")
+	fmt.Fprintf(w, "| Name | Type | Packets | Min | Max | Mean | Sum | Tag sets |\n")
+	fmt.Fprintf(w, "|---|---|---:|---:|---:|---:|---:|---:|\n")
+	for _, m := range report.Metrics {
+		_, err := fmt.Fprintf(w, "| `%s` | %s | %d | %.2f | %.2f | %.2f | %.2f | %d |\n", m.Name, m.Type, m.Packets, m.Min, m.Max, m.Mean, m.Sum, m.TagSets)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
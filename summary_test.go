@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSummary(t *testing.T) *summary {
+	sum := newSummary()
+	for _, msg := range []string{
+		"api.requests:1|c|#env:dev,route:home",
+		"api.requests:3|c|#route:home,env:dev",
+		"api.requests:2|c|#env:prod",
+		"api.requests:5|g",
+		"api.latency:10:30|ms",
+		"_e{5,4}:title|text",
+		"_sc|db.up|0",
+		"not a metric",
+	} {
+		assert.NoError(t, sum.handler([]byte(msg)))
+	}
+
+	return sum
+}
+
+func TestSummaryHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	report := testSummary(t).snapshot()
+	assert.Equal(int64(8), report.Packets)
+	assert.Equal(int64(1), report.ParseErrors)
+	assert.Equal(int64(1), report.Events)
+	assert.Equal(int64(1), report.ServiceChecks)
+
+	// tag sets are only reported as a count
+	for _, m := range report.Metrics {
+		m.tagSets = nil
+	}
+	assert.Equal([]*summaryMetric{
+		{Name: "api.latency", Type: "timer", Packets: 1, Values: 2, Min: 10, Max: 30, Mean: 20, Sum: 40, TagSets: 1},
+		{Name: "api.requests", Type: "counter", Packets: 3, Values: 3, Min: 1, Max: 3, Mean: 2, Sum: 6, TagSets: 2},
+		{Name: "api.requests", Type: "gauge", Packets: 1, Values: 1, Min: 5, Max: 5, Mean: 5, Sum: 5, TagSets: 1},
+	}, report.Metrics)
+}
+
+func TestSummaryDropHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	sum := newSummary()
+	errFull := errors.New("FULL")
+	full := false
+	handler := sum.dropHandler(func([]byte) error {
+		if full {
+			return errFull
+		}
+		return nil
+	})
+
+	assert.NoError(handler([]byte("a:1|c")))
+	full = true
+	assert.Equal(errFull, handler([]byte("a:1|c")))
+	assert.Equal(errFull, handler([]byte("a:1|c")))
+
+	assert.Equal(int64(2), sum.snapshot().Dropped)
+}
+
+func TestSummaryWriters(t *testing.T) {
+	assert := assert.New(t)
+
+	report := testSummary(t).snapshot()
+	report.Start = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	report.End = report.Start.Add(90 * time.Second)
+	report.Dropped = 4
+
+	var buf bytes.Buffer
+	assert.NoError(writeTextSummary(&buf, report))
+	assert.Equal(`session summary: 2024-01-02T03:04:05Z to 2024-01-02T03:05:35Z (1m30s)
+packets: 8, parse errors: 1, dropped: 4, events: 1, service checks: 1
+
+NAME          TYPE     PACKETS  MIN    MAX    MEAN   SUM    TAG SETS
+api.latency   timer    1        10.00  30.00  20.00  40.00  1
+api.requests  counter  3        1.00   3.00   2.00   6.00   2
+api.requests  gauge    1        5.00   5.00   5.00   5.00   1
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(writeMarkdownSummary(&buf, report))
+	assert.Equal("# dogstatsd-local session summary\n\n"+
+		"2024-01-02T03:04:05Z to 2024-01-02T03:05:35Z (1m30s)\n\n"+
+		"| Packets | Parse errors | Dropped | Events | Service checks |\n"+
+		"|---:|---:|---:|---:|---:|\n"+
+		"| 8 | 1 | 4 | 1 | 1 |\n\n"+
+		"## Metrics\n\n"+
+		"| Name | Type | Packets | Min | Max | Mean | Sum | Tag sets |\n"+
+		"|---|---|---:|---:|---:|---:|---:|---:|\n"+
+		"| `api.latency` | timer | 1 | 10.00 | 30.00 | 20.00 | 40.00 | 1 |\n"+
+		"| `api.requests` | counter | 3 | 1.00 | 3.00 | 2.00 | 6.00 | 2 |\n"+
+		"| `api.requests` | gauge | 1 | 5.00 | 5.00 | 5.00 | 5.00 | 1 |\n", buf.String())
+}
+
+func TestSummaryWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	sum := testSummary(t)
+	dir := t.TempDir()
+
+	// the format follows the file's extension
+	jsonFile := filepath.Join(dir, "summary.json")
+	assert.NoError(sum.write(jsonFile))
+	data, err := os.ReadFile(jsonFile)
+	assert.NoError(err)
+
+	report := summaryReport{}
+	assert.NoError(json.Unmarshal(data, &report))
+	assert.Equal(int64(8), report.Packets)
+	assert.Len(report.Metrics, 3)
+	assert.Equal(2, report.Metrics[1].TagSets)
+
+	for file, header := range map[string]string{
+		"summary.md":  "# dogstatsd-local session summary\n",
+		"summary.txt": "session summary: ",
+	} {
+		assert.NoError(sum.write(filepath.Join(dir, file)))
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		assert.NoError(err)
+		assert.Contains(string(data), header, file)
+	}
+
+	assert.Error(sum.write(filepath.Join(dir, "missing", "summary.json")))
+}
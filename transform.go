@@ -0,0 +1,373 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"gopkg.in/yaml.v3"
+)
+
+// tagRewrite replaces regex matches in the values of tags whose key matches a glob
+type tagRewrite struct {
+	Key     string `yaml:"key"` // a key glob, or every tag if empty
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"` // may refer to groups as $1 or ${name}
+
+	re *regexp.Regexp
+}
+
+func (r *tagRewrite) compile() error {
+	if r.Match == "" {
+		return fmt.Errorf("MISSING_MATCH")
+	}
+	if err := checkGlob(r.Key); err != nil {
+		return fmt.Errorf("INVALID_PATTERN (%s)", r.Key)
+	}
+	// the replacement ends up in tags, so mustn't split them
+	if strings.ContainsAny(r.Replace, ",|\n") {
+		return fmt.Errorf("INVALID_REPLACEMENT (%s)", r.Replace)
+	}
+
+	var err error
+	if r.re, err = regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("INVALID_MATCH (%s)", r.Match)
+	}
+
+	return nil
+}
+
+// transformStep is a single step of a transformer, only one of whose fields may be set
+type transformStep struct {
+	AddTags       yamlStrings       `yaml:"add_tags"`
+	DropTags      yamlStrings       `yaml:"drop_tags"` // key globs, or key:value globs
+	RenameTags    map[string]string `yaml:"rename_tags"`
+	RewriteTags   []*tagRewrite     `yaml:"rewrite_tags"`
+	LowercaseTags bool              `yaml:"lowercase_tags"`
+	NormalizeTags bool              `yaml:"normalize_tags"` // lowercase, and replace characters Datadog doesn't allow
+	AddPrefix     string            `yaml:"add_prefix"`     // metric names only
+	StripPrefix   string            `yaml:"strip_prefix"`   // metric names only
+}
+
+// the names of the fields set on a step
+func (s *transformStep) set() []string {
+	set := []string{}
+	for name, ok := range map[string]bool{
+		"add_tags":       len(s.AddTags) > 0,
+		"drop_tags":      len(s.DropTags) > 0,
+		"rename_tags":    len(s.RenameTags) > 0,
+		"rewrite_tags":   len(s.RewriteTags) > 0,
+		"lowercase_tags": s.LowercaseTags,
+		"normalize_tags": s.NormalizeTags,
+		"add_prefix":     s.AddPrefix != "",
+		"strip_prefix":   s.StripPrefix != "",
+	} {
+		if ok {
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+func (s *transformStep) compile() error {
+	switch set := s.set(); len(set) {
+	case 0:
+		return fmt.Errorf("EMPTY_TRANSFORM")
+	case 1:
+	default:
+		return fmt.Errorf("MULTIPLE_TRANSFORMS (%s)", strings.Join(set, ", "))
+	}
+
+	for _, tag := range s.AddTags {
+		if tag == "" || strings.ContainsAny(tag, ",|") {
+			return fmt.Errorf("INVALID_TAG (%s)", tag)
+		}
+	}
+
+	for _, pattern := range s.DropTags {
+		if err := checkGlob(pattern); err != nil {
+			return fmt.Errorf("INVALID_PATTERN (%s)", pattern)
+		}
+	}
+
+	for from, to := range s.RenameTags {
+		if from == "" || to == "" || strings.ContainsAny(to, ",|:") {
+			return fmt.Errorf("INVALID_RENAME (%s: %s)", from, to)
+		}
+	}
+
+	for i, rewrite := range s.RewriteTags {
+		if err := rewrite.compile(); err != nil {
+			return fmt.Errorf("rewrite %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// normalize a tag the way Datadog does on intake: lowercased, with runs of disallowed
+// characters replaced by an underscore, leading non-letters and trailing underscores removed, and
+// truncated to the maximum length
+func normalizeTag(tag string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(tag) {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case b.Len() == 0:
+			// tags must start with a letter
+			continue
+		case c >= '0' && c <= '9' || strings.ContainsRune("_-:./", c):
+		default:
+			c = '_'
+		}
+		if c == '_' && strings.HasSuffix(b.String(), "_") {
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	normalized := b.String()
+	if len(normalized) > lintMaxTagLength {
+		normalized = normalized[:lintMaxTagLength]
+	}
+	return strings.TrimRight(normalized, "_")
+}
+
+func (s *transformStep) tags(tags []string) []string {
+	out := make([]string, 0, len(tags)+len(s.AddTags))
+
+	for _, tag := range tags {
+		key, value, hasValue := strings.Cut(tag, ":")
+
+		switch {
+		case len(s.DropTags) > 0:
+			dropped := false
+			for _, pattern := range s.DropTags {
+				dropped = dropped || tagPatternMatches(pattern, tag)
+			}
+			if dropped {
+				continue
+			}
+		case len(s.RenameTags) > 0:
+			if to, ok := s.RenameTags[key]; ok {
+				key = to
+			}
+		case hasValue && len(s.RewriteTags) > 0:
+			for _, rewrite := range s.RewriteTags {
+				if rewrite.Key == "" || globMatch(rewrite.Key, key) {
+					value = rewrite.re.ReplaceAllString(value, rewrite.Replace)
+				}
+			}
+		case s.LowercaseTags:
+			key, value = strings.ToLower(key), strings.ToLower(value)
+		case s.NormalizeTags:
+			if tag = normalizeTag(tag); tag != "" {
+				out = append(out, tag)
+			}
+			continue
+		}
+
+		if hasValue {
+			tag = key + ":" + value
+		} else {
+			tag = key
+		}
+		out = append(out, tag)
+	}
+
+	// added tags aren't repeated if a message already has them
+	for _, tag := range s.AddTags {
+		present := false
+		for _, existing := range out {
+			present = present || existing == tag
+		}
+		if !present {
+			out = append(out, tag)
+		}
+	}
+
+	return out
+}
+
+func (s *transformStep) name(name string) string {
+	if s.StripPrefix != "" {
+		return strings.TrimPrefix(name, s.StripPrefix)
+	}
+	return s.AddPrefix + name
+}
+
+// msgTransformer rewrites the tags of every message, and the names of metrics, between parsing
+// and any handler; its steps are applied in order
+type msgTransformer struct {
+	Transforms []*transformStep `yaml:"transforms"`
+}
+
+func loadMsgTransformer(filename string) (*msgTransformer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMsgTransformer(f)
+}
+
+// parse a YAML (or JSON) list of transforms
+func parseMsgTransformer(r io.Reader) (*msgTransformer, error) {
+	transformer := &msgTransformer{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true) // so that a misspelt transform isn't silently skipped
+	if err := dec.Decode(transformer); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if err := transformer.compile(); err != nil {
+		return nil, err
+	}
+
+	return transformer, nil
+}
+
+func (t *msgTransformer) compile() error {
+	for i, step := range t.Transforms {
+		if err := step.compile(); err != nil {
+			return fmt.Errorf("transform %d: %s", i+1, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// transform returns the message with every step applied
+func (t *msgTransformer) transform(dMsg dogstatsd.Msg) dogstatsd.Msg {
+	switch msg := dMsg.(type) {
+	case dogstatsd.Metric:
+		for _, step := range t.Transforms {
+			msg.Tags = step.tags(msg.Tags)
+			msg.Name = step.name(msg.Name)
+		}
+		return msg
+	case dogstatsd.Event:
+		for _, step := range t.Transforms {
+			msg.Tags = step.tags(msg.Tags)
+		}
+		return msg
+	case dogstatsd.ServiceCheck:
+		for _, step := range t.Transforms {
+			msg.Tags = step.tags(msg.Tags)
+		}
+		return msg
+	}
+
+	return dMsg
+}
+
+// msgHandler transforms each message before passing it on to fn. Messages which can't be parsed
+// are passed on unchanged, as are those whose transformation can't be encoded, with a warning
+func (t *msgTransformer) msgHandler(fn msgHandler) msgHandler {
+	return func(msg []byte) error {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil {
+			return fn(msg)
+		}
+
+		transformed, err := dogstatsd.Encode(t.transform(dMsg))
+		if err != nil {
+			log.Printf("passing on %q untransformed, as it can't be encoded once transformed: %s", msg, err.Error())
+			return fn(msg)
+		}
+
+		return fn(transformed)
+	}
+}
+
+// flags for the common transforms, applied after any from a file in the order they're listed
+// here; global tags are added last, so they're never dropped or renamed
+type transformFlags struct {
+	file          string
+	dropTags      stringsFlag
+	renameTags    stringsFlag
+	lowercaseTags bool
+	normalizeTags bool
+	stripPrefix   string
+	prefix        string
+	globalTags    string
+	globalEnvTags bool
+}
+
+func (f *transformFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.file, "transform", "", "YAML or JSON file of transforms applied to every message's tags (and metric names) before any handler")
+	flags.Var(&f.dropTags, "drop-tag", "remove tags with this key glob or key:value glob (repeatable)")
+	flags.Var(&f.renameTags, "rename-tag", "rename a tag key, as old=new (repeatable)")
+	flags.BoolVar(&f.lowercaseTags, "lowercase-tags", false, "lowercase every tag")
+	flags.BoolVar(&f.normalizeTags, "normalize-tags", false, "normalize every tag as Datadog does: lowercased, with disallowed characters replaced by underscores")
+	flags.StringVar(&f.stripPrefix, "strip-prefix", "", "remove this prefix from metric names")
+	flags.StringVar(&f.prefix, "prefix", "", "add this prefix to metric names, like the agent's statsd_metric_namespace")
+	flags.StringVar(&f.globalTags, "global-tags", "", "space or comma-separated tags added to every message")
+	flags.BoolVar(&f.globalEnvTags, "global-tags-from-env", false, "also add the tags in $DD_DOGSTATSD_TAGS to every message, like the agent")
+}
+
+// build a transformer from the file and flags given, or nil if there are none
+func (f *transformFlags) msgTransformer() (*msgTransformer, error) {
+	transformer := &msgTransformer{}
+	if f.file != "" {
+		var err error
+		if transformer, err = loadMsgTransformer(f.file); err != nil {
+			return nil, err
+		}
+	}
+
+	add := func(step *transformStep) {
+		transformer.Transforms = append(transformer.Transforms, step)
+	}
+
+	if len(f.dropTags) > 0 {
+		add(&transformStep{DropTags: yamlStrings(f.dropTags)})
+	}
+	if len(f.renameTags) > 0 {
+		renames := map[string]string{}
+		for _, rename := range f.renameTags {
+			from, to, ok := strings.Cut(rename, "=")
+			if !ok {
+				return nil, fmt.Errorf("INVALID_RENAME (%s)", rename)
+			}
+			renames[from] = to
+		}
+		add(&transformStep{RenameTags: renames})
+	}
+	if f.lowercaseTags {
+		add(&transformStep{LowercaseTags: true})
+	}
+	if f.normalizeTags {
+		add(&transformStep{NormalizeTags: true})
+	}
+	if f.stripPrefix != "" {
+		add(&transformStep{StripPrefix: f.stripPrefix})
+	}
+	if f.prefix != "" {
+		add(&transformStep{AddPrefix: f.prefix})
+	}
+	globalTags := f.globalTags
+	if f.globalEnvTags {
+		globalTags += " " + os.Getenv("DD_DOGSTATSD_TAGS")
+	}
+	if tags := strings.FieldsFunc(globalTags, func(c rune) bool { return c == ' ' || c == ',' }); len(tags) > 0 {
+		add(&transformStep{AddTags: tags})
+	}
+
+	if len(transformer.Transforms) == 0 {
+		return nil, nil
+	}
+	if err := transformer.compile(); err != nil {
+		return nil, err
+	}
+
+	return transformer, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTransforms = `
+transforms:
+  - drop_tags: [debug, "tmp_*"]
+  - rename_tags: {svc: service}
+  - rewrite_tags:
+      - key: path
+        match: '^/users/\d+'
+        replace: /users/:id
+      - match: '^(prod|production)$'
+        replace: prod
+  - lowercase_tags: true
+  - strip_prefix: legacy.
+  - add_prefix: app.
+  - add_tags: env:dev, team:web
+`
+
+func TestMsgTransformer(t *testing.T) {
+	transformer, err := parseMsgTransformer(strings.NewReader(testTransforms))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{"legacy.requests:1|c|#svc:API,debug,tmp_id:5,path:/users/42/posts,stage:production", "app.requests:1|c|#service:api,path:/users/:id/posts,stage:prod,env:dev,team:web"},
+		{"requests:1|c|#env:dev", "app.requests:1|c|#env:dev,team:web"},
+		{"_e{5,4}:title|text|d:1000|#svc:web", "_e{5,4}:title|text|d:1000|#service:web,env:dev,team:web"},
+		{"_sc|legacy.up|0|d:1000|#debug:true", "_sc|legacy.up|0|d:1000|#env:dev,team:web"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			dMsg, err := dogstatsd.Parse([]byte(test.msg))
+			assert.NoError(t, err)
+
+			encoded, err := dogstatsd.Encode(transformer.transform(dMsg))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(encoded))
+		})
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := map[string]string{
+		"Env:Dev":                "env:dev",
+		"route:/Users/{id}":      "route:/users/_id",
+		"__1key:a  b":            "key:a_b",
+		"123":                    "",
+		"ok-tag.v1/x":            "ok-tag.v1/x",
+		strings.Repeat("a", 250): strings.Repeat("a", lintMaxTagLength),
+	}
+
+	for tag, expected := range tests {
+		assert.Equal(t, expected, normalizeTag(tag), tag)
+	}
+}
+
+func TestMsgTransformerMsgHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	transformer := &msgTransformer{Transforms: []*transformStep{
+		{NormalizeTags: true},
+		{StripPrefix: "legacy."},
+	}}
+	assert.NoError(transformer.compile())
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	received := []string{}
+	handler := transformer.msgHandler(func(msg []byte) error {
+		received = append(received, string(msg))
+		return nil
+	})
+	for _, msg := range []string{"a:1|c|#Env:Dev,123", "legacy.:1|c", "not a datagram"} {
+		handler([]byte(msg))
+	}
+
+	// a metric whose name is stripped entirely can't be encoded, so is passed on unchanged
+	assert.Equal([]string{"a:1|c|#env:dev", "legacy.:1|c", "not a datagram"}, received)
+	assert.Contains(logged.String(), `passing on "legacy.:1|c" untransformed, as it can't be encoded once transformed: UNENCODABLE_NAME ("")`)
+}
+
+func TestTransformFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	flags := transformFlags{}
+	transformer, err := flags.msgTransformer()
+	assert.NoError(err)
+	assert.Nil(transformer)
+
+	flags = transformFlags{
+		dropTags:      stringsFlag{"env"},
+		renameTags:    stringsFlag{"svc=service"},
+		normalizeTags: true,
+		prefix:        "app.",
+		globalTags:    "env:dev team:web,region:us",
+	}
+	transformer, err = flags.msgTransformer()
+	assert.NoError(err)
+	assert.Len(transformer.Transforms, 5)
+
+	dMsg, _ := dogstatsd.Parse([]byte("requests:1|c|#env:prod,svc:API"))
+	encoded, err := dogstatsd.Encode(transformer.transform(dMsg))
+	assert.NoError(err)
+	assert.Equal("app.requests:1|c|#service:api,env:dev,team:web,region:us", string(encoded))
+
+	// the agent's environment variable is only read when asked for
+	t.Setenv("DD_DOGSTATSD_TAGS", "env:ci")
+	flags = transformFlags{}
+	transformer, err = flags.msgTransformer()
+	assert.NoError(err)
+	assert.Nil(transformer)
+
+	flags = transformFlags{globalTags: "team:web", globalEnvTags: true}
+	transformer, err = flags.msgTransformer()
+	assert.NoError(err)
+	encoded, err = dogstatsd.Encode(transformer.transform(dMsg))
+	assert.NoError(err)
+	assert.Equal("requests:1|c|#env:prod,svc:API,team:web,env:ci", string(encoded))
+
+	flags = transformFlags{renameTags: stringsFlag{"svc"}}
+	_, err = flags.msgTransformer()
+	assert.EqualError(err, "INVALID_RENAME (svc)")
+}
+
+func TestParseMsgTransformerErrors(t *testing.T) {
+	tests := map[string]string{
+		"transforms: [{}]": "transform 1: EMPTY_TRANSFORM",
+		"transforms: [{add_prefix: a., strip_prefix: b.}]":           "transform 1: MULTIPLE_TRANSFORMS (add_prefix, strip_prefix)",
+		"transforms: [{add_tags: [a, '']}]":                          "transform 1: INVALID_TAG ()",
+		"transforms: [{lowercase_tags: true}, {drop_tags: '['}]":     "transform 2: INVALID_PATTERN ([)",
+		"transforms: [{rename_tags: {a: 'b:c'}}]":                    "transform 1: INVALID_RENAME (a: b:c)",
+		"transforms: [{rewrite_tags: [{key: a}]}]":                   "transform 1: rewrite 1: MISSING_MATCH",
+		"transforms: [{rewrite_tags: [{match: '('}]}]":               "transform 1: rewrite 1: INVALID_MATCH (()",
+		"transforms: [{rewrite_tags: [{match: '-', replace: ','}]}]": "transform 1: rewrite 1: INVALID_REPLACEMENT (,)",
+		"transforms: [{add_prefx: a.}]":                              "yaml: unmarshal errors:\n  line 1: field add_prefx not found in type main.transformStep",
+	}
+
+	for config, expected := range tests {
+		_, err := parseMsgTransformer(strings.NewReader(config))
+		assert.EqualError(t, err, expected, config)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"golang.org/x/term"
+)
+
+type tuiSortKey int
+
+const (
+	nameTuiSortKey tuiSortKey = iota
+	typeTuiSortKey
+	valueTuiSortKey
+	rateTuiSortKey
+	countTuiSortKey
+	ageTuiSortKey
+)
+
+func (k tuiSortKey) String() string {
+	switch k {
+	case nameTuiSortKey:
+		return "name"
+	case typeTuiSortKey:
+		return "type"
+	case valueTuiSortKey:
+		return "value"
+	case rateTuiSortKey:
+		return "rate"
+	case countTuiSortKey:
+		return "count"
+	case ageTuiSortKey:
+		return "age"
+	}
+
+	return "unknown"
+}
+
+// a single metric context (name, type and tag set) shown as one row of the dashboard
+type tuiContext struct {
+	name       string
+	metricType dogstatsd.MetricType
+	tags       []string
+
+	lastValue float64
+	count     int64
+	prevCount int64
+	rate      float64
+	lastSeen  time.Time
+	last      dogstatsd.Metric // matched against the where expression
+}
+
+type tui struct {
+	in  *os.File
+	out *os.File
+
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	contexts    map[string]*tuiContext
+	otherMsgs   int64
+	parseErrors int64
+	lastLog     string
+	lastTick    time.Time
+
+	sortBy      tuiSortKey
+	sortReverse bool
+	filter      string
+	where       *msgExpr
+	input       *string // pending filter (or where expression) while the user is typing it
+	inputWhere  bool
+	inputErr    string
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newTui(in *os.File, out *os.File) *tui {
+	return &tui{
+		in:              in,
+		out:             out,
+		refreshInterval: time.Second,
+		contexts:        map[string]*tuiContext{},
+		lastTick:        time.Now(),
+		stopCh:          make(chan struct{}),
+		doneCh:          make(chan struct{}),
+	}
+}
+
+// handler records each metric against its context; it is safe to call from the handler pool
+func (t *tui) handler(msg []byte) error {
+	dMsg, err := dogstatsd.Parse(msg)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.parseErrors++
+		return nil
+	}
+
+	metric, ok := dMsg.(dogstatsd.Metric)
+	if !ok {
+		t.otherMsgs++
+		return nil
+	}
+
+	tags := make([]string, len(metric.Tags))
+	copy(tags, metric.Tags)
+	sort.Strings(tags)
+
+	key := fmt.Sprintf("%s|%s|%s", metric.Name, metric.MetricType.String(), strings.Join(tags, ","))
+	ctx, ok := t.contexts[key]
+	if !ok {
+		ctx = &tuiContext{
+			name:       metric.Name,
+			metricType: metric.MetricType,
+			tags:       tags,
+		}
+		t.contexts[key] = ctx
+	}
+
+	if len(metric.Values) > 0 {
+		ctx.lastValue = metric.Values[len(metric.Values)-1].Numeric
+	}
+	ctx.count += int64(len(metric.Values))
+	ctx.lastSeen = metric.Timestamp
+	ctx.last = metric
+
+	return nil
+}
+
+// Write captures log output so that it can be shown in the status line rather than
+// scribbled over the dashboard
+func (t *tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastLog = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+// run takes over the terminal until stop is called; quit is called when the user asks to exit
+func (t *tui) run(quit func()) error {
+	defer close(t.doneCh)
+
+	fd := int(t.in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	// switch to the alternate screen and hide the cursor
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+
+	// closing the input stops the reader once the dashboard is done with it
+	input, closeInput, err := pollableInput(t.in)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+
+	keyCh := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := input.Read(buf); err != nil {
+				return
+			}
+
+			select {
+			case keyCh <- buf[0]:
+			case <-t.stopCh:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(t.refreshInterval)
+	defer ticker.Stop()
+
+	t.render()
+	for {
+		select {
+		case <-t.stopCh:
+			return nil
+		case key := <-keyCh:
+			if !t.key(key) {
+				quit()
+			}
+		case now := <-ticker.C:
+			t.tick(now)
+		}
+
+		t.render()
+	}
+}
+
+func (t *tui) stop() {
+	close(t.stopCh)
+	<-t.doneCh
+}
+
+// key handles a single keystroke, returning false if the user asked to quit
+func (t *tui) key(key byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.input != nil {
+		if key != '\r' && key != '\n' {
+			t.inputErr = ""
+		}
+
+		switch key {
+		case '\r', '\n':
+			if !t.inputWhere {
+				t.filter = *t.input
+			} else if *t.input == "" {
+				t.where = nil
+			} else if where, err := compileMsgExpr(*t.input); err != nil {
+				// leave the expression open to be fixed
+				t.inputErr = err.Error()
+				return true
+			} else {
+				t.where = where
+			}
+			t.input = nil
+		case 0x1b: // escape
+			t.input = nil
+		case 0x7f, 0x08: // backspace
+			if len(*t.input) > 0 {
+				*t.input = (*t.input)[:len(*t.input)-1]
+			}
+		default:
+			if key >= 0x20 && key < 0x7f {
+				*t.input += string(key)
+			}
+		}
+
+		return true
+	}
+
+	sortKeys := map[byte]tuiSortKey{
+		'n': nameTuiSortKey,
+		't': typeTuiSortKey,
+		'v': valueTuiSortKey,
+		'r': rateTuiSortKey,
+		'c': countTuiSortKey,
+		'a': ageTuiSortKey,
+	}
+
+	switch key {
+	case 'q', 0x03: // ctrl-c doesn't raise SIGINT in raw mode
+		return false
+	case '/':
+		input := ""
+		t.input, t.inputWhere, t.inputErr = &input, false, ""
+	case 'w':
+		// start from the current expression, to be edited
+		input := ""
+		if t.where != nil {
+			input = t.where.String()
+		}
+		t.input, t.inputWhere, t.inputErr = &input, true, ""
+	case 0x1b:
+		t.filter = ""
+		t.where = nil
+	case 'x':
+		t.contexts = map[string]*tuiContext{}
+	default:
+		if sortKey, ok := sortKeys[key]; ok {
+			t.sortReverse = sortKey == t.sortBy && !t.sortReverse
+			t.sortBy = sortKey
+		}
+	}
+
+	return true
+}
+
+// tick recomputes per-second rates from the counts seen since the previous tick
+func (t *tui) tick(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elapsed := now.Sub(t.lastTick).Seconds()
+	t.lastTick = now
+	if elapsed <= 0 {
+		return
+	}
+
+	for _, ctx := range t.contexts {
+		ctx.rate = float64(ctx.count-ctx.prevCount) / elapsed
+		ctx.prevCount = ctx.count
+	}
+}
+
+func (t *tui) rows() []*tuiContext {
+	rows := make([]*tuiContext, 0, len(t.contexts))
+	for _, ctx := range t.contexts {
+		if t.filter != "" && !strings.Contains(ctx.name, t.filter) && !strings.Contains(strings.Join(ctx.tags, ","), t.filter) {
+			continue
+		}
+		if t.where != nil && !t.where.matches(ctx.last) {
+			continue
+		}
+		rows = append(rows, ctx)
+	}
+
+	less := func(a, b *tuiContext) bool {
+		switch t.sortBy {
+		case typeTuiSortKey:
+			if a.metricType != b.metricType {
+				return a.metricType.String() < b.metricType.String()
+			}
+		case valueTuiSortKey:
+			if a.lastValue != b.lastValue {
+				return a.lastValue > b.lastValue
+			}
+		case rateTuiSortKey:
+			if a.rate != b.rate {
+				return a.rate > b.rate
+			}
+		case countTuiSortKey:
+			if a.count != b.count {
+				return a.count > b.count
+			}
+		case ageTuiSortKey:
+			if !a.lastSeen.Equal(b.lastSeen) {
+				return a.lastSeen.After(b.lastSeen)
+			}
+		}
+
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return strings.Join(a.tags, ",") < strings.Join(b.tags, ",")
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if t.sortReverse {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+
+	return rows
+}
+
+// truncate a line to width characters, never splitting one, so tags and names which aren't
+// ASCII don't leave half a character at the edge of the screen
+func truncateTuiLine(str string, width int) string {
+	for i, n := 0, 0; i < len(str); n++ {
+		if n == width {
+			return str[:i]
+		}
+		_, size := utf8.DecodeRuneInString(str[i:])
+		i += size
+	}
+	return str
+}
+
+func (t *tui) render() {
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 120, 40
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var buf bytes.Buffer
+	// lines are truncated to the terminal's width before any style is applied, so that the
+	// escape sequence resetting it is never cut off
+	styledLine := func(style string, format string, args ...interface{}) {
+		str := truncateTuiLine(fmt.Sprintf(format, args...), width)
+		if style != "" {
+			str = style + str + "\x1b[0m"
+		}
+		buf.WriteString(str)
+		buf.WriteString("\x1b[K\r\n")
+	}
+	line := func(format string, args ...interface{}) {
+		styledLine("", format, args...)
+	}
+
+	rows := t.rows()
+	now := time.Now()
+
+	buf.WriteString("\x1b[H")
+	line(
+		"dogstatsd-local | %d contexts | %d events/service checks | %d parse errors | sort: %s",
+		len(t.contexts), t.otherMsgs, t.parseErrors, t.sortBy.String(),
+	)
+	where := ""
+	if t.where != nil {
+		where = t.where.String()
+	}
+	switch {
+	case t.input != nil && t.inputWhere && t.inputErr != "":
+		line("where: %s_  (%s)", *t.input, t.inputErr)
+	case t.input != nil && t.inputWhere:
+		line("where: %s_", *t.input)
+	case t.input != nil:
+		line("filter: %s_ | where: %s", *t.input, where)
+	default:
+		line("filter: %s | where: %s", t.filter, where)
+	}
+	styledLine("\x1b[7m", "%-40s %-12s %14s %10s %10s %8s  %s", "NAME", "TYPE", "VALUE", "RATE/S", "COUNT", "AGE", "TAGS")
+
+	// leave room for the header and the status/help lines
+	for i, ctx := range rows {
+		if i >= height-5 {
+			break
+		}
+
+		value := fmt.Sprintf("%.2f", ctx.lastValue)
+		if ctx.metricType == dogstatsd.TimerMetricType {
+			value += "ms"
+		}
+
+		line(
+			"%-40s %-12s %14s %10.2f %10d %8s  %s",
+			ctx.name,
+			ctx.metricType.String(),
+			value,
+			ctx.rate,
+			ctx.count,
+			now.Sub(ctx.lastSeen).Truncate(time.Second).String(),
+			strings.Join(ctx.tags, " "),
+		)
+	}
+
+	buf.WriteString("\x1b[J")
+	buf.WriteString(fmt.Sprintf("\x1b[%d;1H", height-1))
+	line("%s", t.lastLog)
+	buf.WriteString("sort: [n]ame [t]ype [v]alue [r]ate [c]ount [a]ge | [/] filter [w]here [esc] clear | [x] reset | [q]uit\x1b[K")
+
+	io.Copy(t.out, &buf)
+}
@@ -0,0 +1,11 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package main
+
+import "os"
+
+// pollableInput returns in itself where reads can't be interrupted; its reader finishes on the
+// next keystroke instead
+func pollableInput(in *os.File) (*os.File, func(), error) {
+	return in, func() {}, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tuiRowNames(ui *tui) []string {
+	names := []string{}
+	for _, ctx := range ui.rows() {
+		names = append(names, ctx.name)
+	}
+	return names
+}
+
+func TestTuiHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	ui := newTui(nil, nil)
+	for _, msg := range []string{
+		"api.requests:1|c|#route:home,env:dev",
+		"api.requests:3|c|#env:dev,route:home",
+		"api.requests:1|c|#env:prod",
+		"api.requests:1|g|#env:dev,route:home",
+		"api.latency:10:20|ms",
+		"_e{5,4}:title|text",
+		"not a metric",
+	} {
+		assert.NoError(ui.handler([]byte(msg)))
+	}
+
+	// contexts are a name, type and tag set, whatever the tags' order
+	assert.Len(ui.contexts, 4)
+	assert.Equal(int64(1), ui.otherMsgs)
+	assert.Equal(int64(1), ui.parseErrors)
+
+	ctx := ui.contexts["api.requests|counter|env:dev,route:home"]
+	if assert.NotNil(ctx) {
+		assert.Equal([]string{"env:dev", "route:home"}, ctx.tags)
+		assert.Equal(int64(2), ctx.count)
+		assert.Equal(3.0, ctx.lastValue)
+	}
+
+	latency := ui.contexts["api.latency|timer|"]
+	if assert.NotNil(latency) {
+		assert.Equal(int64(2), latency.count)
+		assert.Equal(20.0, latency.lastValue)
+	}
+
+	// rates are per second since the previous tick
+	ui.tick(ui.lastTick.Add(2 * time.Second))
+	assert.Equal(1.0, ctx.rate)
+	assert.Equal(1.0, latency.rate)
+
+	ui.handler([]byte("api.requests:1|c|#env:dev,route:home"))
+	ui.tick(ui.lastTick.Add(time.Second))
+	assert.Equal(1.0, ctx.rate)
+	assert.Equal(0.0, latency.rate)
+
+	// a tick without time passing leaves rates alone
+	ui.tick(ui.lastTick)
+	assert.Equal(1.0, ctx.rate)
+}
+
+func TestTuiKey(t *testing.T) {
+	assert := assert.New(t)
+
+	ui := newTui(nil, nil)
+	for _, msg := range []string{
+		"api.requests:1|c|#env:dev",
+		"api.requests:1|c|#env:dev",
+		"api.requests:1|c|#env:dev",
+		"db.queries:5|c|#env:prod",
+		"cache.hits:2|g|#env:dev",
+		"cache.hits:2|g|#env:dev",
+	} {
+		ui.handler([]byte(msg))
+	}
+
+	assert.Equal([]string{"api.requests", "cache.hits", "db.queries"}, tuiRowNames(ui))
+
+	// pressing a sort key twice reverses the order
+	assert.True(ui.key('c'))
+	assert.Equal(countTuiSortKey, ui.sortBy)
+	assert.Equal([]string{"api.requests", "cache.hits", "db.queries"}, tuiRowNames(ui))
+	assert.True(ui.key('c'))
+	assert.True(ui.sortReverse)
+	assert.Equal([]string{"db.queries", "cache.hits", "api.requests"}, tuiRowNames(ui))
+	assert.True(ui.key('v'))
+	assert.False(ui.sortReverse)
+	assert.Equal([]string{"db.queries", "cache.hits", "api.requests"}, tuiRowNames(ui))
+	assert.True(ui.key('t'))
+	assert.Equal([]string{"api.requests", "db.queries", "cache.hits"}, tuiRowNames(ui))
+	ui.key('n')
+
+	// keys typed into the filter are text, not commands, until enter
+	for _, key := range []byte("/cax\x7f") {
+		assert.True(ui.key(key))
+	}
+	assert.Equal("ca", *ui.input)
+	assert.Equal("", ui.filter)
+	assert.Len(tuiRowNames(ui), 3)
+	ui.key('\r')
+	assert.Nil(ui.input)
+	assert.Equal([]string{"cache.hits"}, tuiRowNames(ui))
+
+	// filters match tags too
+	for _, key := range []byte("/env:prod\r") {
+		ui.key(key)
+	}
+	assert.Equal([]string{"db.queries"}, tuiRowNames(ui))
+
+	// escape abandons the filter being typed, then clears the current one
+	for _, key := range []byte("/api\x1b") {
+		ui.key(key)
+	}
+	assert.Nil(ui.input)
+	assert.Equal("env:prod", ui.filter)
+	ui.key(0x1b)
+	assert.Equal("", ui.filter)
+	assert.Len(tuiRowNames(ui), 3)
+
+	// an invalid where expression is left open to be fixed
+	for _, key := range []byte("wvalue >\r") {
+		ui.key(key)
+	}
+	assert.NotNil(ui.input)
+	assert.NotEmpty(ui.inputErr)
+	for _, key := range []byte(" 1\r") {
+		ui.key(key)
+	}
+	assert.Nil(ui.input)
+	assert.Equal([]string{"cache.hits", "db.queries"}, tuiRowNames(ui))
+
+	// and is edited from where it was left
+	ui.key('w')
+	assert.Equal(ui.where.String(), *ui.input)
+	ui.key(0x1b)
+	ui.key(0x1b)
+	assert.Nil(ui.where)
+
+	ui.key('x')
+	assert.Empty(tuiRowNames(ui))
+
+	assert.False(ui.key('q'))
+	assert.False(ui.key(0x03))
+}
+
+func TestTruncateTuiLine(t *testing.T) {
+	tests := []struct {
+		str      string
+		width    int
+		expected string
+	}{
+		{"page.views", 20, "page.views"},
+		{"page.views", 4, "page"},
+		{"page.views", 0, ""},
+		{"café.views", 4, "café"},
+		{"café.views", 10, "café.views"},
+		{"日本語タグ", 3, "日本語"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, truncateTuiLine(test.str, test.width), "%q at %d", test.str, test.width)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// pollableInput returns a copy of in whose pending reads are interrupted by closing it, along
+// with a function closing it and leaving in as it was
+func pollableInput(in *os.File) (*os.File, func(), error) {
+	fd, err := syscall.Dup(int(in.Fd()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a file is only read through the runtime's poller (and so can be interrupted) if it's
+	// non-blocking, which the copy shares with in until it's closed
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, nil, err
+	}
+
+	input := os.NewFile(uintptr(fd), in.Name())
+	return input, func() {
+		input.Close()
+		syscall.SetNonblock(int(in.Fd()), false)
+	}, nil
+}
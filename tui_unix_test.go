@@ -0,0 +1,49 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollableInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	input, closeInput, err := pollableInput(r)
+	assert.NoError(t, err)
+
+	w.Write([]byte("a"))
+	buf := make([]byte, 1)
+	_, err = input.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(buf))
+
+	// closing the input interrupts a pending read
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := input.Read(buf)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	closeInput()
+
+	select {
+	case err := <-readErr:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read wasn't interrupted")
+	}
+
+	// the original is still usable
+	w.Write([]byte("b"))
+	_, err = r.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(buf))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+)
+
+var errWaitForTimeout = errors.New("TIMEOUT")
+
+// waiter watches incoming packets for the first message matching matcher
+type waiter struct {
+	matcher *msgMatcher
+	matched chan dogstatsd.Msg
+}
+
+func newWaiter(matcher *msgMatcher) *waiter {
+	return &waiter{
+		matcher: matcher,
+		matched: make(chan dogstatsd.Msg, 1),
+	}
+}
+
+func (w *waiter) packetHandler(packet []byte, _ net.Addr) error {
+	for _, msg := range dogstatsd.SplitPacket(packet) {
+		dMsg, err := dogstatsd.Parse(msg)
+		if err != nil || !w.matcher.matches(dMsg) {
+			continue
+		}
+
+		select {
+		case w.matched <- dMsg:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// ask a running instance started with -http to wait for a matching message; its /wait
+// endpoint also matches messages received before the request was made
+func waitForUrl(base string, query url.Values, timeout time.Duration) (*httpApiMsg, error) {
+	query.Set("timeout", timeout.String())
+
+	// leave the server time to answer with a timeout of its own
+	client := &http.Client{Timeout: timeout + 5*time.Second}
+	resp, err := client.Get(strings.TrimRight(base, "/") + "/wait?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var msg httpApiMsg
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	case http.StatusGatewayTimeout:
+		return nil, errWaitForTimeout
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return nil, fmt.Errorf("UNEXPECTED_RESPONSE (%s: %s)", resp.Status, body.Error)
+}
+
+// wait-for subcommand: block until a matching message arrives, exiting 0 and printing it, or
+// exit 1 once the timeout passes
+func runWaitFor(args []string) int {
+	var tags stringsFlag
+
+	flags := flag.NewFlagSet("wait-for", flag.ExitOnError)
+	host := flags.String("host", "0.0.0.0", "bind address")
+	port := flags.Int("port", 8125, "listen port")
+	base := flags.String("url", "", "instead of listening, wait on a running instance's HTTP API at this URL (see -http)")
+	kind := flags.String("kind", "any", "message kind: metric|event|service_check|any")
+	name := flags.String("name", "", "metric or service check name (or event title) glob")
+	metricType := flags.String("type", "", "metric type")
+	status := flags.String("status", "", "service check status")
+	flags.Var(&tags, "tag", "tag glob the message must have (repeatable)")
+	value := flags.String("value", "", "metric value predicate, e.g. >500, <=10, 42 or 0..100")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait before giving up")
+	asJson := flags.Bool("json", false, "print the matching message as JSON rather than the raw datagram")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s wait-for [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	matcher := &msgMatcher{
+		Kind:   *kind,
+		Name:   *name,
+		Type:   *metricType,
+		Status: *status,
+		Tags:   tags,
+	}
+	if *value != "" {
+		var err error
+		if matcher.Value, err = parseValueRange(*value); err != nil {
+			log.Println(err.Error())
+			return 2
+		}
+	}
+	if err := matcher.compile(); err != nil {
+		log.Println(err.Error())
+		return 2
+	}
+
+	if *base != "" {
+		query := url.Values{
+			"kind":   {*kind},
+			"name":   {*name},
+			"type":   {*metricType},
+			"status": {*status},
+			"tag":    tags,
+			"value":  {*value},
+		}
+
+		msg, err := waitForUrl(*base, query, *timeout)
+		if err == errWaitForTimeout {
+			log.Printf("no %s received within %s", matcher.String(), *timeout)
+			return 1
+		} else if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+
+		if *asJson {
+			json.NewEncoder(os.Stdout).Encode(msg.Message)
+		} else {
+			fmt.Println(msg.Data)
+		}
+		return 0
+	}
+
+	w := newWaiter(matcher)
+	srv := dogstatsd.NewServer(fmt.Sprintf("%s:%d", *host, *port), w.packetHandler)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Listen()
+	}()
+
+	// only a server which is listening has to be stopped
+	if srv.Addr() == nil {
+		if err := <-errCh; err != nil {
+			log.Println(err.Error())
+		}
+		return 1
+	}
+	defer srv.Stop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+
+	timer := time.NewTimer(*timeout)
+	defer timer.Stop()
+
+	select {
+	case dMsg := <-w.matched:
+		if *asJson {
+			json.NewEncoder(os.Stdout).Encode(newDogstatsdJsonMsg(dMsg))
+		} else {
+			fmt.Println(string(dMsg.Data()))
+		}
+		return 0
+	case err := <-errCh:
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return 1
+	case <-timer.C:
+		log.Printf("no %s received within %s", matcher.String(), *timeout)
+		return 1
+	case <-sigCh:
+		return 1
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anujdas/dogstatsd-local/dogstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaiter(t *testing.T) {
+	matcher := &msgMatcher{Name: "api.latency", Type: "ms", Tags: []string{"env:*"}, Value: &valueRange{Min: new(float64)}}
+	assert.NoError(t, matcher.compile())
+
+	w := newWaiter(matcher)
+	srv := dogstatsd.NewServer("127.0.0.1:0", w.packetHandler)
+	go srv.Listen()
+	defer srv.Stop()
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range []string{
+		"api.latency:12|ms",
+		"api.latency:-1|ms|#env:dev",
+		"api.requests:1|c|#env:dev\napi.latency:12|ms|#env:dev",
+	} {
+		conn.Write([]byte(msg))
+	}
+
+	select {
+	case dMsg := <-w.matched:
+		assert.Equal(t, "api.latency:12|ms|#env:dev", string(dMsg.Data()))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message matched")
+	}
+}
+
+func TestWaitForUrl(t *testing.T) {
+	api := newHttpApi(10)
+	srv := httptest.NewServer(api.mux())
+	defer srv.Close()
+
+	query := url.Values{"name": {"deploys"}, "kind": {"metric"}}
+
+	_, err := waitForUrl(srv.URL, query, 10*time.Millisecond)
+	assert.Equal(t, errWaitForTimeout, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		api.handler([]byte("deploys:1|c|#env:dev"))
+	}()
+
+	msg, err := waitForUrl(srv.URL, query, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "deploys:1|c|#env:dev", msg.Data)
+
+	_, err = waitForUrl(srv.URL, url.Values{"kind": {"log"}}, time.Second)
+	assert.EqualError(t, err, "UNEXPECTED_RESPONSE (400 Bad Request: INVALID_KIND (log))")
+}
+
+func TestRunWaitForBusyPort(t *testing.T) {
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer busy.Close()
+
+	port := busy.LocalAddr().(*net.UDPAddr).Port
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- runWaitFor([]string{"-host", "127.0.0.1", "-port", fmt.Sprint(port), "-timeout", "30s"})
+	}()
+
+	select {
+	case exitCode := <-exitCh:
+		assert.Equal(t, 1, exitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait-for hung on a busy port")
+	}
+}